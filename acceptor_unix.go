@@ -23,7 +23,10 @@
 package gnet
 
 import (
+	"net"
 	"os"
+	"syscall"
+	"time"
 
 	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/socket"
@@ -31,34 +34,295 @@ import (
 )
 
 func (svr *server) acceptNewConnection(fd int) error {
+	// The listener is still in the poller if accepting has just been paused.
+	if svr.isAcceptPaused() {
+		return svr.mainLoop.poller.ModNone(fd)
+	}
 	nfd, sa, err := unix.Accept(fd)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		// A stale listener is removed from the poller, the main reactor keeps serving the other ones.
+		if err == unix.EBADF {
+			delete(svr.mainLoop.listeners, fd)
+			return os.NewSyscallError("accept", err)
+		}
+		if isTransientAcceptError(err) {
+			return svr.mainLoop.handleAcceptError(fd, err)
+		}
+		if svr.mainLoop.rebindListener(fd, err) {
+			return nil
+		}
 		return errors.ErrAcceptSocket
 	}
+	delete(svr.mainLoop.backoffs, fd)
+	if !svr.admitConn() {
+		_ = unix.Close(nfd)
+		return nil
+	}
 	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 		return err
 	}
 
 	netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	el := svr.lb.next(netAddr)
-	c := newTCPConn(nfd, el, sa, netAddr)
+	ln := svr.mainLoop.listeners[fd]
+	if err = ln.controlConn(nfd, netAddr); err != nil {
+		_ = unix.Close(nfd)
+		svr.logger.Warnf("SocketControl failed on the connection from %v: %v", netAddr, err)
+		return nil
+	}
+	if err = svr.registerConn(nfd, ln, sa, ln.connLocalAddr(nfd), netAddr); err != nil {
+		_ = unix.Close(nfd)
+	}
+	return nil
+}
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+
+	minRebindBackoff = 100 * time.Millisecond
+	maxRebindBackoff = 30 * time.Second
+)
+
+// isTransientAcceptError reports whether the error of accept(2) may go away by itself.
+func isTransientAcceptError(err error) bool {
+	switch err {
+	case unix.ECONNABORTED, unix.EMFILE, unix.ENFILE, unix.ENOBUFS, unix.ENOMEM:
+		return true
+	}
+	return false
+}
+
+// handleAcceptError applies Options.AcceptErrorPolicy to the transient error of accepting on the listener fd,
+// the returned error stops the event-loop.
+func (el *eventloop) handleAcceptError(fd int, err error) error {
+	opts := el.svr.opts
+	switch opts.AcceptErrorPolicy {
+	case AcceptShutdown:
+		el.svr.logger.Errorf("failed to accept on listener(fd=%d): %v", fd, err)
+		return errors.ErrAcceptSocket
+	case AcceptCallback:
+		if opts.AcceptErrorHandler != nil && opts.AcceptErrorHandler(err) == Shutdown {
+			return errors.ErrServerShutdown
+		}
+	}
+
+	// The aborted connection is gone already, so go on accepting the pending ones.
+	if err == unix.ECONNABORTED {
+		return nil
+	}
+	if err == unix.EMFILE || err == unix.ENFILE {
+		el.svr.shedConn(fd)
+	}
+
+	if el.backoffs == nil {
+		el.backoffs = make(map[int]time.Duration)
+	}
+	backoff := el.backoffs[fd] * 2
+	if backoff < minAcceptBackoff {
+		backoff = minAcceptBackoff
+	} else if backoff > maxAcceptBackoff {
+		backoff = maxAcceptBackoff
+	}
+	el.backoffs[fd] = backoff
+	el.svr.logger.Warnf("failed to accept on listener(fd=%d): %v, retrying in %v", fd, err, backoff)
+
+	if err = el.poller.ModNone(fd); err != nil {
+		return err
+	}
+	ln := el.listeners[fd]
+	time.AfterFunc(backoff, func() {
+		_ = el.poller.Trigger(func() error {
+			// The listener may have been closed, replaced or paused by the time the backoff is over.
+			if el.listeners[fd] != ln || el.svr.isAcceptPaused() {
+				return nil
+			}
+			return el.watchListener(fd)
+		})
+	})
+	return nil
+}
+
+// rebindListener closes the listener fd failing on accepting with err and binds its address again in
+// the background if Options.RebindListeners is on, it reports whether the failure has been taken care of.
+func (el *eventloop) rebindListener(fd int, err error) bool {
+	ln := el.listeners[fd]
+	if !el.svr.opts.RebindListeners || ln == nil || ln.inherited {
+		return false
+	}
+	el.svr.logger.Warnf("listener(fd=%d) on %s://%s failed on accepting: %v, rebinding it", fd, ln.network, ln.addr, err)
+	_ = el.poller.Delete(fd)
+	delete(el.listeners, fd)
+	delete(el.backoffs, fd)
+	ln.close()
+	go el.rebind(ln)
+	return true
+}
+
+// rebind keeps binding a new listener in place of the closed ln until it succeeds or the server is shut down.
+func (el *eventloop) rebind(ln *listener) {
+	for backoff := minRebindBackoff; !el.svr.isInShutdown(); {
+		time.Sleep(backoff)
+		l, err := ln.rebind(el.svr.opts)
+		if err != nil {
+			el.svr.logger.Warnf("failed to rebind listener on %s://%s: %v, retrying in %v", ln.network, ln.addr, err, backoff)
+			if backoff *= 2; backoff > maxRebindBackoff {
+				backoff = maxRebindBackoff
+			}
+			continue
+		}
+
+		err = el.poller.Trigger(func() error {
+			if el.svr.isInShutdown() {
+				l.close()
+				return nil
+			}
+			if err := el.poller.AddRead(l.fd); err != nil {
+				el.svr.logger.Errorf("failed to watch the rebound listener on %s://%s: %v", ln.network, ln.addr, err)
+				l.close()
+				return nil
+			}
+			el.listeners[l.fd] = l
+			if el == el.svr.mainLoop {
+				el.svr.lnsMu.Lock()
+				for i := range el.svr.lns {
+					if el.svr.lns[i] == ln {
+						el.svr.lns[i] = l
+					}
+				}
+				el.svr.lnsMu.Unlock()
+			}
+			if el.svr.isAcceptPaused() {
+				return el.poller.ModNone(l.fd)
+			}
+			return nil
+		})
+		if err != nil {
+			l.close()
+		}
+		return
+	}
+}
 
-	err = el.poller.Trigger(func() (err error) {
+// reserveFd opens the spare file-descriptor of Options.SpareFd.
+func (svr *server) reserveFd() error {
+	fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("open", err)
+	}
+	svr.spareFd = fd
+	return nil
+}
+
+func (svr *server) releaseFd() {
+	svr.spareMu.Lock()
+	if svr.spareFd >= 0 {
+		_ = unix.Close(svr.spareFd)
+		svr.spareFd = -1
+	}
+	svr.spareMu.Unlock()
+}
+
+// shedConn frees the spare file-descriptor to accept one pending connection on the listener fd and closes it
+// right away, then reserves the spare one again, which is nothing but best effort.
+func (svr *server) shedConn(fd int) {
+	svr.spareMu.Lock()
+	defer svr.spareMu.Unlock()
+	if svr.spareFd < 0 {
+		return
+	}
+	_ = unix.Close(svr.spareFd)
+	svr.spareFd = -1
+	if nfd, _, err := unix.Accept(fd); err == nil {
+		_ = unix.Close(nfd)
+	}
+	if err := svr.reserveFd(); err != nil {
+		svr.logger.Warnf("failed to reserve the spare file-descriptor: %v", err)
+	}
+}
+
+// registerConn hands the non-blocking connected socket over to the next event-loop,
+// ln is the listener that accepted the socket, which is nil for the imported ones.
+// The socket is left to the caller if it can't be handed over.
+func (svr *server) registerConn(nfd int, ln *listener, sa unix.Sockaddr, localAddr, remoteAddr net.Addr) error {
+	el := svr.lb.next(remoteAddr)
+	c := newTCPConn(nfd, el, sa, localAddr, remoteAddr)
+	if ln != nil {
+		c.route(ln)
+	}
+
+	err := el.poller.Trigger(func() (err error) {
 		if err = el.poller.AddRead(nfd); err != nil {
 			_ = unix.Close(nfd)
 			c.releaseTCP()
+			el.recycleConn(c)
 			return
 		}
-		el.connections[nfd] = c
+		el.connections.set(nfd, c)
 		err = el.loopOpen(c)
 		return
 	})
 	if err != nil {
-		_ = unix.Close(nfd)
 		c.releaseTCP()
 	}
-	return nil
+	return err
+}
+
+func (svr *server) importFD(fd int) error {
+	if svr.isInShutdown() {
+		return errors.ErrServerInShutdown
+	}
+	if !svr.isStarted() {
+		return errors.ErrServerNotStarted
+	}
+	typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if typ != unix.SOCK_STREAM {
+		return errors.ErrUnsupportedOp
+	}
+	lsa, err := unix.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	sa, err := unix.Getpeername(fd)
+	if err != nil {
+		return os.NewSyscallError("getpeername", err)
+	}
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(fd, true)); err != nil {
+		return err
+	}
+
+	return svr.registerConn(fd, nil, sa, socket.SockaddrToTCPOrUnixAddr(lsa), socket.SockaddrToTCPOrUnixAddr(sa))
+}
+
+func (svr *server) importConn(c net.Conn) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errors.ErrUnsupportedOp
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var (
+		nfd  int
+		derr error
+	)
+	if err = rc.Control(func(fd uintptr) {
+		nfd, derr = unix.Dup(int(fd))
+	}); err != nil {
+		return err
+	}
+	if derr != nil {
+		return os.NewSyscallError("dup", derr)
+	}
+	if err = svr.importFD(nfd); err != nil {
+		_ = unix.Close(nfd)
+		return err
+	}
+	return c.Close()
 }
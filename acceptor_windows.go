@@ -22,11 +22,18 @@
 package gnet
 
 import (
+	"errors"
+	"io"
+	"net"
 	"runtime"
+	"syscall"
 	"time"
+
+	errorset "github.com/panjf2000/gnet/errors"
+	"golang.org/x/sys/windows"
 )
 
-func (svr *server) listenerRun(lockOSThread bool) {
+func (svr *server) listenerRun(ln *listener, lockOSThread bool) {
 	if lockOSThread {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
@@ -36,39 +43,105 @@ func (svr *server) listenerRun(lockOSThread bool) {
 	defer func() { svr.signalShutdownWithErr(err) }()
 	var buffer [0x10000]byte
 	for {
-		if svr.ln.pconn != nil {
+		if ln.pconn != nil {
 			// Read data from UDP socket.
-			n, addr, e := svr.ln.pconn.ReadFrom(buffer[:])
+			n, addr, e := ln.pconn.ReadFrom(buffer[:])
 			if e != nil {
 				err = e
 				return
 			}
 
 			el := svr.lb.next(addr)
-			c := newUDPConn(el, svr.ln.lnaddr, addr)
+			c := newUDPConn(el, ln.pconn, ln.lnaddr, addr)
+			c.route(ln)
 			el.ch <- packUDPConn(c, buffer[:n])
 		} else {
 			// Accept TCP socket.
-			conn, e := svr.ln.ln.Accept()
+			conn, e := ln.ln.Accept()
 			if e != nil {
 				err = e
 				return
 			}
-			el := svr.lb.next(conn.RemoteAddr())
-			c := newTCPConn(conn, el)
-			el.ch <- c
-			go func() {
-				var buffer [0x10000]byte
-				for {
-					n, err := c.conn.Read(buffer[:])
-					if err != nil {
-						_ = c.conn.SetReadDeadline(time.Time{})
-						el.ch <- &stderr{c, err}
-						return
-					}
-					el.ch <- packTCPConn(c, buffer[:n])
-				}
-			}()
+			if !svr.admitConn() {
+				_ = conn.Close()
+				continue
+			}
+			if e = ln.controlConn(conn); e != nil {
+				_ = conn.Close()
+				svr.logger.Warnf("SocketControl failed on the connection from %v: %v", conn.RemoteAddr(), e)
+				continue
+			}
+			svr.registerConn(conn, ln, ln.lnaddr)
+		}
+	}
+}
+
+// registerConn hands the connection over to the next event-loop and starts reading from it,
+// ln is the listener that accepted the connection, which is nil for the imported ones.
+func (svr *server) registerConn(conn net.Conn, ln *listener, localAddr net.Addr) {
+	el := svr.lb.next(conn.RemoteAddr())
+	c := newTCPConn(conn, el, localAddr)
+	if ln != nil {
+		c.route(ln)
+	}
+	el.ch <- c
+	go func() {
+		var buffer [0x10000]byte
+		for {
+			n, err := c.conn.Read(buffer[:])
+			if err != nil {
+				_ = c.conn.SetReadDeadline(time.Time{})
+				el.ch <- &stderr{c, readCloseReason(err)}
+				return
+			}
+			el.ch <- packTCPConn(c, buffer[:n])
 		}
+	}()
+}
+
+func (svr *server) importFD(_ int) error {
+	return errorset.ErrUnsupportedOp
+}
+
+func (svr *server) importConn(c net.Conn) error {
+	if svr.isInShutdown() {
+		return errorset.ErrServerInShutdown
+	}
+	if !svr.isStarted() {
+		return errorset.ErrServerNotStarted
+	}
+	svr.registerConn(c, nil, c.LocalAddr())
+	return nil
+}
+
+// readCloseReason translates the error of reading from a connection into the reason of closing it.
+func readCloseReason(err error) error {
+	switch {
+	case err == io.EOF:
+		return errorset.ErrPeerClosed
+	case errors.Is(err, syscall.WSAECONNRESET):
+		return errorset.ErrPeerReset
+	case isUnreachable(err):
+		return errorset.ErrPeerUnreachable
+	default:
+		return err
 	}
 }
+
+// writeCloseReason translates the error of writing to a connection into the reason of closing it.
+func writeCloseReason(err error) error {
+	if errors.Is(err, syscall.WSAECONNRESET) || errors.Is(err, syscall.WSAECONNABORTED) ||
+		errors.Is(err, windows.ERROR_BROKEN_PIPE) || errors.Is(err, windows.ERROR_NO_DATA) {
+		return errorset.ErrPeerReset
+	}
+	if isUnreachable(err) {
+		return errorset.ErrPeerUnreachable
+	}
+	return errorset.ErrWriteFailed
+}
+
+// isUnreachable reports whether err means that the peer can't be reached, like the keep-alive probes failing.
+func isUnreachable(err error) bool {
+	return errors.Is(err, windows.WSAETIMEDOUT) || errors.Is(err, windows.WSAEHOSTUNREACH) ||
+		errors.Is(err, windows.WSAENETUNREACH)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adminDumpTimeout is the maximum time to wait for the event-loops to report their connections.
+const adminDumpTimeout = 5 * time.Second
+
+// serverBinder is implemented by the built-in event-handlers of routes that need to access the server.
+type serverBinder interface {
+	bindServer(s Server)
+}
+
+// bindServer hands the server over to the event-handlers of listeners that need it.
+func bindServer(s Server, listeners []*listener) {
+	for _, ln := range listeners {
+		if b, ok := ln.eventHandler.(serverBinder); ok {
+			b.bindServer(s)
+		}
+	}
+}
+
+// isLocalProtoAddr reports whether protoAddr can only be reached from the host, which is the case for unix sockets
+// and TCP addresses on the loopback interface.
+func isLocalProtoAddr(protoAddr string) bool {
+	network, addr := parseProtoAddr(protoAddr)
+	if network == "unix" {
+		return true
+	}
+	if !strings.HasPrefix(network, "tcp") {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminRoute returns the route of the admin listener.
+func adminRoute(opts *Options) Route {
+	return Route{
+		ProtoAddr:    opts.AdminAddr,
+		EventHandler: &adminHandler{EventServer: new(EventServer)},
+		Codec:        NewLineBasedFrameCodec(1024, true),
+	}
+}
+
+type adminStats struct {
+	Connections      int      `json:"connections"`
+	EventLoops       int      `json:"event_loops"`
+	DroppedDatagrams uint64   `json:"dropped_datagrams"`
+	Metrics          *Metrics `json:"metrics,omitempty"`
+}
+
+type adminConnInfo struct {
+	Loop   int    `json:"loop"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// adminHandler serves the admin listener, it takes one command per line and answers with one line,
+// which is either a JSON document, "OK" or "ERR" followed by the reason:
+//
+//	stats            - the statistics of the server as JSON
+//	conns            - the connections on all event-loops as a JSON array
+//	memory           - the buffer memory of all event-loops as JSON
+//	loglevel <level> - change the level of the default logger
+//	drain            - stop accepting and shut down once all connections are closed
+//	quit             - close the admin connection
+type adminHandler struct {
+	*EventServer
+	server Server
+	conns  int32 // number of the open admin connections, which don't hold back draining
+}
+
+func (ah *adminHandler) bindServer(s Server) {
+	ah.server = s
+	s.svr.admin = ah
+}
+
+func (ah *adminHandler) OnOpened(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&ah.conns, 1)
+	return
+}
+
+func (ah *adminHandler) OnClosed(c Conn, err error) (action Action) {
+	atomic.AddInt32(&ah.conns, -1)
+	return
+}
+
+// countConns returns the number of the open admin connections, ah may be nil if there's no admin listener.
+func (ah *adminHandler) countConns() int {
+	if ah == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&ah.conns))
+}
+
+func (ah *adminHandler) React(frame []byte, c Conn) (out []byte, action Action) {
+	fields := strings.Fields(string(frame))
+	if len(fields) == 0 {
+		return
+	}
+	switch strings.ToLower(fields[0]) {
+	case "stats":
+		out = ah.stats()
+	case "conns":
+		go ah.dumpConns(c)
+	case "memory":
+		go ah.dumpMemory(c)
+	case "loglevel":
+		if len(fields) != 2 {
+			out = []byte("ERR usage: loglevel <level>")
+		} else {
+			out = adminResult(ah.server.UpdateOptions(WithLogLevel(fields[1])))
+		}
+	case "drain":
+		out = adminResult(ah.server.svr.drain())
+	case "quit":
+		action = Close
+	default:
+		out = []byte("ERR unknown command: " + fields[0])
+	}
+	return
+}
+
+func (ah *adminHandler) stats() []byte {
+	s := adminStats{
+		Connections:      ah.server.CountConnections(),
+		EventLoops:       ah.server.svr.lb.len(),
+		DroppedDatagrams: ah.server.CountDroppedDatagrams(),
+	}
+	if ah.server.svr.opts.LatencyMetrics {
+		m := ah.server.Metrics()
+		s.Metrics = &m
+	}
+	out, _ := json.Marshal(s)
+	return out
+}
+
+// dumpConns collects the connections on every event-loop and writes them to c.
+func (ah *adminHandler) dumpConns(c Conn) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		infos = make([]adminConnInfo, 0)
+	)
+	ah.server.svr.lb.iterate(func(i int, el *eventloop) bool {
+		wg.Add(1)
+		err := el.execute(func() error {
+			list := el.connInfos()
+			mu.Lock()
+			infos = append(infos, list...)
+			mu.Unlock()
+			wg.Done()
+			return nil
+		})
+		if err != nil {
+			wg.Done()
+		}
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(adminDumpTimeout):
+	}
+
+	mu.Lock()
+	out, _ := json.Marshal(infos)
+	mu.Unlock()
+	_ = c.AsyncWrite(out)
+}
+
+// dumpMemory writes the buffer memory of the server to c.
+func (ah *adminHandler) dumpMemory(c Conn) {
+	out, _ := json.Marshal(ah.server.MemoryStats())
+	_ = c.AsyncWrite(out)
+}
+
+func adminResult(err error) []byte {
+	if err != nil {
+		return []byte("ERR " + err.Error())
+	}
+	return []byte("OK")
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sync"
+	"time"
+)
+
+// acceptLimiter is a token bucket refilled at Options.AcceptRate tokens per second up to AcceptRate tokens,
+// each accepted connection takes a token.
+type acceptLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket refilled at rate, it reports false if there's none left.
+func (l *acceptLimiter) allow(rate int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// admitConn reports whether a newly accepted connection is within Options.MaxConns and Options.AcceptRate,
+// the connections that aren't must be closed right away.
+func (svr *server) admitConn() bool {
+	opts := svr.loadLiveOptions()
+	if opts.MaxConns > 0 {
+		var count int
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			count += int(el.loadConn())
+			return true
+		})
+		if count >= opts.MaxConns {
+			return false
+		}
+	}
+	return opts.AcceptRate <= 0 || svr.limiter.allow(opts.AcceptRate, time.Now())
+}
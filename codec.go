@@ -22,13 +22,104 @@ package gnet
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	errorset "github.com/panjf2000/gnet/errors"
 )
 
+// CodecFactory is an optional interface that an ICodec can implement to have a codec of its own instantiated
+// for every connection, so that the codecs can keep the state of the connections, e.g. the negotiated version
+// or the compression dictionaries, without locks. It is honored wherever a codec is set up.
+type CodecFactory interface {
+	// NewCodec returns the codec of a new connection.
+	NewCodec() ICodec
+}
+
+// CodecFactoryFunc is a CodecFactory that creates the codecs by calling itself.
+type CodecFactoryFunc func() ICodec
+
+// NewCodec calls f.
+func (f CodecFactoryFunc) NewCodec() ICodec {
+	return f()
+}
+
+// Encode is only there to make f an ICodec, the connections encode with the codecs created by f, so it always
+// fails with errors.ErrUnsupportedOp rather than losing the state of a codec.
+func (f CodecFactoryFunc) Encode(_ Conn, _ []byte) ([]byte, error) {
+	return nil, errorset.ErrUnsupportedOp
+}
+
+// Decode is only there to make f an ICodec, the connections decode with the codecs created by f, so it always
+// fails with a *CodecError of errors.ErrUnsupportedOp, which closes the connection, rather than losing the state
+// of a codec.
+func (f CodecFactoryFunc) Decode(_ Conn) ([]byte, error) {
+	return nil, &CodecError{Err: errorset.ErrUnsupportedOp}
+}
+
+// instantiateCodec returns the codec of a new connection, which is codec itself unless it's a CodecFactory.
+func instantiateCodec(codec ICodec) ICodec {
+	if f, ok := codec.(CodecFactory); ok {
+		return f.NewCodec()
+	}
+	return codec
+}
+
+// CodecError is returned by Decode to report the malformed input at the head of the inbound buffer, as opposed to
+// the incomplete one, it is handled by Options.CodecErrorPolicy. The other errors of Decode are ignored as before.
+type CodecError struct {
+	// Err is the reason of the malformation.
+	Err error
+	// N is the number of the malformed bytes at the head of the inbound buffer, which are left in the buffer by
+	// the codec so that the policy can decide what to do with them.
+	N int
+}
+
+// Error implements error.
+func (e *CodecError) Error() string {
+	return "malformed input: " + e.Err.Error()
+}
+
+// Unwrap returns the reason of the malformation.
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+// applyCodecError applies the CodecErrorPolicy of opts to the malformed input of c reported by err, it returns
+// the error frame to write back, the malformed bytes to react to under CodecRaw and whether c is to be closed.
+func applyCodecError(opts *Options, c Conn, err *CodecError) (out, raw []byte, closing bool) {
+	policy := opts.CodecErrorPolicy
+	if policy == CodecCallback && opts.CodecErrorHandler != nil {
+		out, policy = opts.CodecErrorHandler(c, err)
+	}
+	n := err.N
+	if l := c.BufferLength(); n > l {
+		n = l
+	}
+	// Nothing can be skipped or handed over without the malformed bytes, so close the connection instead of
+	// decoding the same input over and over again.
+	if n <= 0 {
+		return out, nil, true
+	}
+	switch policy {
+	case CodecSkip:
+		c.ShiftN(n)
+		return out, nil, false
+	case CodecRaw:
+		raw = c.Read()[:n]
+		c.ShiftN(n)
+		return out, raw, false
+	}
+	return out, nil, true
+}
+
 // CRLFByte represents a byte of CRLF.
 var CRLFByte = byte('\n')
 
@@ -37,7 +128,8 @@ type (
 	ICodec interface {
 		// Encode encodes frames upon server responses into TCP stream.
 		Encode(c Conn, buf []byte) ([]byte, error)
-		// Decode decodes frames from TCP stream via specific implementation.
+		// Decode decodes frames from TCP stream via specific implementation, the malformed input is reported with
+		// a *CodecError.
 		Decode(c Conn) ([]byte, error)
 	}
 
@@ -45,7 +137,10 @@ type (
 	BuiltInFrameCodec struct{}
 
 	// LineBasedFrameCodec encodes/decodes line-separated frames into/from TCP stream.
-	LineBasedFrameCodec struct{}
+	LineBasedFrameCodec struct {
+		maxLength int
+		crlf      bool
+	}
 
 	// DelimiterBasedFrameCodec encodes/decodes specific-delimiter-separated frames into/from TCP stream.
 	DelimiterBasedFrameCodec struct {
@@ -81,8 +176,20 @@ func (cc *BuiltInFrameCodec) Decode(c Conn) ([]byte, error) {
 	return buf, nil
 }
 
+// NewLineBasedFrameCodec instantiates and returns a line codec for text protocols like SMTP, telnet or STOMP.
+// Lines longer than maxLength bytes excluding the line ending are reported with a *CodecError of ErrLineTooLong,
+// 0 means no limit. An overlong line is reported piece by piece as it arrives, up to and including its line ending,
+// so that no more than about maxLength bytes of it are buffered. If crlf is true, the trailing CR of every line is
+// trimmed and lines are terminated with CRLF when encoding.
+func NewLineBasedFrameCodec(maxLength int, crlf bool) *LineBasedFrameCodec {
+	return &LineBasedFrameCodec{maxLength: maxLength, crlf: crlf}
+}
+
 // Encode ...
 func (cc *LineBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if cc.crlf {
+		return append(buf, '\r', CRLFByte), nil
+	}
 	return append(buf, CRLFByte), nil
 }
 
@@ -91,10 +198,28 @@ func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	buf := c.Read()
 	idx := bytes.IndexByte(buf, CRLFByte)
 	if idx == -1 {
+		if cc.maxLength > 0 {
+			// Report the head of an overlong line but leave the bytes which make it overlong without the head,
+			// so that the rest of it is still told overlong until its line ending without tracking it.
+			keep := cc.maxLength + 1
+			if cc.crlf {
+				keep++
+			}
+			if n := len(buf) - keep; n > 0 {
+				return nil, &CodecError{Err: errorset.ErrLineTooLong, N: n}
+			}
+		}
 		return nil, errorset.ErrCRLFNotFound
 	}
+	line := buf[:idx]
+	if cc.crlf && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if cc.maxLength > 0 && len(line) > cc.maxLength {
+		return nil, &CodecError{Err: errorset.ErrLineTooLong, N: idx + 1}
+	}
 	c.ShiftN(idx + 1)
-	return buf[:idx], nil
+	return line, nil
 }
 
 // NewDelimiterBasedFrameCodec instantiates and returns a codec with a specific delimiter.
@@ -159,6 +284,10 @@ type EncoderConfig struct {
 	// LengthIncludesLengthFieldLength is true, the length of the prepended length field is added to the value of
 	// the prepended length field
 	LengthIncludesLengthFieldLength bool
+	// Checksum generates the 4-byte checksum appended to every frame in ByteOrder, which is counted in the
+	// length field, no checksum is appended if it's nil. ChecksumCRC32 and ChecksumCRC32C are built in,
+	// other hash functions like xxhash can be plugged in as well.
+	Checksum func(data []byte) uint32
 }
 
 // DecoderConfig config for decoder.
@@ -173,11 +302,33 @@ type DecoderConfig struct {
 	LengthAdjustment int
 	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame
 	InitialBytesToStrip int
+	// Checksum verifies the 4-byte checksum at the end of every frame and strips it out, it must be the same
+	// as the one of EncoderConfig. The frame failing the verification is reported with a *CodecError of
+	// ErrChecksumMismatch.
+	Checksum func(data []byte) uint32
+}
+
+// checksumLength is the length of the checksum appended to every frame.
+const checksumLength = 4
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumCRC32 returns the CRC-32 checksum of data using the IEEE polynomial.
+func ChecksumCRC32(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// ChecksumCRC32C returns the CRC-32 checksum of data using the Castagnoli polynomial.
+func ChecksumCRC32C(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoliTable)
 }
 
 // Encode ...
 func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, err error) {
 	length := len(buf) + cc.encoderConfig.LengthAdjustment
+	if cc.encoderConfig.Checksum != nil {
+		length += checksumLength
+	}
 	if cc.encoderConfig.LengthIncludesLengthFieldLength {
 		length += cc.encoderConfig.LengthFieldLength
 	}
@@ -214,6 +365,11 @@ func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, er
 	}
 
 	out = append(out, buf...)
+	if cc.encoderConfig.Checksum != nil {
+		sum := make([]byte, checksumLength)
+		cc.encoderConfig.ByteOrder.PutUint32(sum, cc.encoderConfig.Checksum(buf))
+		out = append(out, sum...)
+	}
 	return
 }
 
@@ -261,11 +417,23 @@ func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 		return nil, errorset.ErrUnexpectedEOF
 	}
 
-	fullMessage := make([]byte, len(header)+len(lenBuf)+msgLength)
+	var sum []byte
+	if cc.decoderConfig.Checksum != nil {
+		// A complete frame too short to carry a checksum can't match one either.
+		if msgLength < checksumLength {
+			return nil, &CodecError{Err: errorset.ErrChecksumMismatch, N: len(header) + len(lenBuf) + msgLength}
+		}
+		msg, sum = msg[:msgLength-checksumLength], msg[msgLength-checksumLength:]
+		if cc.decoderConfig.ByteOrder.Uint32(sum) != cc.decoderConfig.Checksum(msg) {
+			return nil, &CodecError{Err: errorset.ErrChecksumMismatch, N: len(header) + len(lenBuf) + msgLength}
+		}
+	}
+
+	fullMessage := make([]byte, len(header)+len(lenBuf)+len(msg))
 	copy(fullMessage, header)
 	copy(fullMessage[len(header):], lenBuf)
 	copy(fullMessage[len(header)+len(lenBuf):], msg)
-	c.ShiftN(len(fullMessage))
+	c.ShiftN(len(fullMessage) + len(sum))
 	return fullMessage[cc.decoderConfig.InitialBytesToStrip:], nil
 }
 
@@ -327,3 +495,355 @@ func writeUint24(byteOrder binary.ByteOrder, v int) []byte {
 	}
 	return b
 }
+
+// Compressor compresses and decompresses frames for CompressionCodec, it must be safe for concurrent use as the
+// event-loops share it. Only gzip is built in to keep gnet free of third-party compression libraries, the other
+// algorithms like snappy or zstd can be plugged in by implementing it.
+type Compressor interface {
+	// Compress appends the compressed src to dst and returns the extended buffer.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed src to dst and returns the extended buffer.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// CompressionCodec compresses the frames before encoding them with the inner codec and decompresses
+// the frames decoded by the inner codec, which must be able to tell the boundaries of frames, e.g.
+// LengthFieldBasedFrameCodec. The algorithm is configured by the Compressor rather than negotiated with peers.
+type CompressionCodec struct {
+	codec      ICodec
+	compressor Compressor
+}
+
+// NewCompressionCodec instantiates and returns a codec compressing the frames of codec with compressor.
+func NewCompressionCodec(codec ICodec, compressor Compressor) *CompressionCodec {
+	return &CompressionCodec{codec: codec, compressor: compressor}
+}
+
+// Encode ...
+func (cc *CompressionCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	out, err := cc.compressor.Compress(nil, buf)
+	if err != nil {
+		return nil, err
+	}
+	return cc.codec.Encode(c, out)
+}
+
+// Decode ...
+func (cc *CompressionCodec) Decode(c Conn) ([]byte, error) {
+	frame, err := cc.codec.Decode(c)
+	if err != nil || frame == nil {
+		return nil, err
+	}
+	// The frame has been shifted out of the inbound buffer by the inner codec, so there is nothing left to skip
+	// and the failure must close the connection rather than lose the frame silently.
+	out, err := cc.compressor.Decompress(nil, frame)
+	if err != nil {
+		return nil, &CodecError{Err: err}
+	}
+	return out, nil
+}
+
+// defaultMaxDecompressedSize is the default limit of the size of a decompressed frame.
+const defaultMaxDecompressedSize = 4 << 20
+
+type gzipCompressor struct {
+	level   int
+	maxSize int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewGzipCompressor instantiates and returns a Compressor of gzip with the given compression level,
+// gzip.DefaultCompression is a good default. The frames decompressing to more than maxSize bytes fail with
+// ErrDecompressedTooLarge, which defaults to 4MB if maxSize is not positive, so that a small frame can't blow up
+// the memory. The encoders and decoders are pooled to avoid allocating them for every frame.
+func NewGzipCompressor(level, maxSize int) (Compressor, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid gzip compression level: %d", level)
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+	return &gzipCompressor{level: level, maxSize: maxSize}, nil
+}
+
+// appendWriter is an io.Writer appending to the underlying slice.
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (gc *gzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	w := &appendWriter{buf: dst}
+	zw, _ := gc.writers.Get().(*gzip.Writer)
+	if zw == nil {
+		zw, _ = gzip.NewWriterLevel(w, gc.level)
+	} else {
+		zw.Reset(w)
+	}
+	defer gc.writers.Put(zw)
+
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+func (gc *gzipCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	var err error
+	zr, _ := gc.readers.Get().(*gzip.Reader)
+	if zr == nil {
+		zr, err = gzip.NewReader(bytes.NewReader(src))
+	} else {
+		err = zr.Reset(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer gc.readers.Put(zr)
+
+	buf := bytes.NewBuffer(dst)
+	n, err := buf.ReadFrom(io.LimitReader(zr, int64(gc.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(gc.maxSize) {
+		return nil, errorset.ErrDecompressedTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	// fragmentHeaderLength is the length of the fragment header, which consists of the message ID(4 bytes),
+	// the fragment index(2 bytes), the fragment count(2 bytes) and the payload length(2 bytes) in big-endian.
+	fragmentHeaderLength = 10
+
+	// defaultFragmentTimeout is the default time to wait for the missing fragments of a message.
+	defaultFragmentTimeout = 5 * time.Second
+
+	// defaultMaxFragmentedSize is the default limit of the size of a fragmented message.
+	defaultMaxFragmentedSize = 4 << 20
+
+	// maxPendingMessages is the maximum number of incomplete messages buffered by a FragmentCodec.
+	maxPendingMessages = 1024
+)
+
+// FragmentCodec splits the messages larger than the MTU into numbered fragments and reassembles them on receipt,
+// the incomplete messages are dropped after a timeout.
+//
+// Over stream transports, it works as an ICodec, which reports the malformed fragments with a *CodecError.
+// It's a CodecFactory as well, so that every connection reassembles its messages with a codec of its own.
+// As for UDP, whose datagrams are not encoded by codecs, call Split to get the datagrams to send and Reassemble
+// on every received datagram in React instead.
+type FragmentCodec struct {
+	mtu          int
+	timeout      time.Duration
+	maxSize      int
+	maxFragments int
+	nextID       uint32
+	mu           sync.Mutex
+	lastPurge    time.Time
+	pending      map[fragmentKey]*fragmentedMessage
+	buffered     int // number of the payload bytes of the incomplete messages
+}
+
+type fragmentKey struct {
+	source interface{}
+	id     uint32
+}
+
+type fragmentedMessage struct {
+	parts    [][]byte
+	received int
+	size     int
+	deadline time.Time
+}
+
+// NewFragmentCodec instantiates and returns a codec splitting messages into fragments no larger than mtu bytes
+// including the 10-byte fragment header, timeout is the time to wait for the missing fragments of a message,
+// which defaults to 5 seconds. maxSize limits the size of a message, which defaults to 4MB if it's not positive.
+// To bound the memory held by the peers sending incomplete messages, the fragments of a message beyond maxSize,
+// of more than 1024 incomplete messages, or of more than 4 times maxSize bytes of incomplete messages in total
+// are rejected with ErrFragmentLimit.
+func NewFragmentCodec(mtu int, timeout time.Duration, maxSize int) (*FragmentCodec, error) {
+	if mtu <= fragmentHeaderLength || mtu > fragmentHeaderLength+0xffff {
+		return nil, fmt.Errorf("invalid MTU of fragments: %d", mtu)
+	}
+	if timeout <= 0 {
+		timeout = defaultFragmentTimeout
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxFragmentedSize
+	}
+	size := mtu - fragmentHeaderLength
+	maxFragments := (maxSize + size - 1) / size
+	if maxFragments > 0xffff {
+		maxFragments = 0xffff
+	}
+	return &FragmentCodec{
+		mtu:          mtu,
+		timeout:      timeout,
+		maxSize:      maxSize,
+		maxFragments: maxFragments,
+		pending:      make(map[fragmentKey]*fragmentedMessage),
+	}, nil
+}
+
+// NewCodec returns a codec of the same configuration with no incomplete messages.
+func (cc *FragmentCodec) NewCodec() ICodec {
+	return &FragmentCodec{
+		mtu:          cc.mtu,
+		timeout:      cc.timeout,
+		maxSize:      cc.maxSize,
+		maxFragments: cc.maxFragments,
+		pending:      make(map[fragmentKey]*fragmentedMessage),
+	}
+}
+
+// Split splits msg into fragments, each of which is no larger than the MTU.
+func (cc *FragmentCodec) Split(msg []byte) ([][]byte, error) {
+	if len(msg) > cc.maxSize {
+		return nil, fmt.Errorf("message is too large to fragment: %d bytes", len(msg))
+	}
+	size := cc.mtu - fragmentHeaderLength
+	count := (len(msg) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	// The index and the count of fragments wouldn't fit in the header.
+	if count > cc.maxFragments {
+		return nil, fmt.Errorf("message is split into too many fragments: %d", count)
+	}
+
+	id := atomic.AddUint32(&cc.nextID, 1)
+	fragments := make([][]byte, count)
+	for i := range fragments {
+		payload := msg[i*size:]
+		if len(payload) > size {
+			payload = payload[:size]
+		}
+		fragment := make([]byte, fragmentHeaderLength+len(payload))
+		binary.BigEndian.PutUint32(fragment, id)
+		binary.BigEndian.PutUint16(fragment[4:], uint16(i))
+		binary.BigEndian.PutUint16(fragment[6:], uint16(count))
+		binary.BigEndian.PutUint16(fragment[8:], uint16(len(payload)))
+		copy(fragment[fragmentHeaderLength:], payload)
+		fragments[i] = fragment
+	}
+	return fragments, nil
+}
+
+// Reassemble collects a fragment sent by source, which identifies the peer like the remote address,
+// and returns the message once all of its fragments have arrived, or nil otherwise.
+func (cc *FragmentCodec) Reassemble(source interface{}, fragment []byte) ([]byte, error) {
+	if len(fragment) < fragmentHeaderLength {
+		return nil, errorset.ErrInvalidFragment
+	}
+	id := binary.BigEndian.Uint32(fragment)
+	index := int(binary.BigEndian.Uint16(fragment[4:]))
+	count := int(binary.BigEndian.Uint16(fragment[6:]))
+	payload := fragment[fragmentHeaderLength:]
+	if index >= count || int(binary.BigEndian.Uint16(fragment[8:])) != len(payload) {
+		return nil, errorset.ErrInvalidFragment
+	}
+	if count == 1 {
+		return payload, nil
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	now := time.Now()
+	cc.purge(now)
+
+	key := fragmentKey{source, id}
+	fm := cc.pending[key]
+	if fm == nil {
+		if count > cc.maxFragments || len(cc.pending) >= maxPendingMessages {
+			return nil, errorset.ErrFragmentLimit
+		}
+		fm = &fragmentedMessage{parts: make([][]byte, count), deadline: now.Add(cc.timeout)}
+		cc.pending[key] = fm
+	} else if len(fm.parts) != count {
+		cc.drop(key, fm)
+		return nil, errorset.ErrInvalidFragment
+	}
+	if fm.parts[index] != nil {
+		return nil, nil // duplicate fragment
+	}
+	if fm.size+len(payload) > cc.maxSize || cc.buffered+len(payload) > 4*cc.maxSize {
+		cc.drop(key, fm)
+		return nil, errorset.ErrFragmentLimit
+	}
+	fm.parts[index] = append([]byte(nil), payload...)
+	fm.received++
+	fm.size += len(payload)
+	cc.buffered += len(payload)
+	if fm.received < count {
+		return nil, nil
+	}
+
+	cc.drop(key, fm)
+	msg := make([]byte, 0, fm.size)
+	for _, part := range fm.parts {
+		msg = append(msg, part...)
+	}
+	return msg, nil
+}
+
+// purge drops the expired incomplete messages, at most once per timeout.
+func (cc *FragmentCodec) purge(now time.Time) {
+	if now.Sub(cc.lastPurge) < cc.timeout {
+		return
+	}
+	cc.lastPurge = now
+	for key, fm := range cc.pending {
+		if now.After(fm.deadline) {
+			cc.drop(key, fm)
+		}
+	}
+}
+
+// drop removes the message of key from the incomplete ones.
+func (cc *FragmentCodec) drop(key fragmentKey, fm *fragmentedMessage) {
+	delete(cc.pending, key)
+	cc.buffered -= fm.size
+}
+
+// Encode ...
+func (cc *FragmentCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	fragments, err := cc.Split(buf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(fragments, nil), nil
+}
+
+// Decode ...
+func (cc *FragmentCodec) Decode(c Conn) ([]byte, error) {
+	for {
+		buf := c.Read()
+		if len(buf) < fragmentHeaderLength {
+			return nil, errorset.ErrUnexpectedEOF
+		}
+		n := fragmentHeaderLength + int(binary.BigEndian.Uint16(buf[8:]))
+		if len(buf) < n {
+			return nil, errorset.ErrUnexpectedEOF
+		}
+		msg, err := cc.Reassemble(c, buf[:n])
+		if err != nil {
+			return nil, &CodecError{Err: err, N: n}
+		}
+		c.ShiftN(n)
+		if msg != nil {
+			return msg, nil
+		}
+	}
+}
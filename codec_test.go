@@ -22,9 +22,11 @@ package gnet
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/panjf2000/gnet/errors"
 )
@@ -341,3 +343,330 @@ func TestInnerBufferReadN(t *testing.T) {
 		t.Fatal("wrong length of leftover bytes")
 	}
 }
+
+func TestCompressionCodec(t *testing.T) {
+	if _, err := NewGzipCompressor(10, 0); err == nil {
+		t.Fatal("should have an error of invalid compression level")
+	}
+	compressor, err := NewGzipCompressor(gzip.BestSpeed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoderConfig := EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4}
+	decoderConfig := DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4, InitialBytesToStrip: 4}
+	codec := NewCompressionCodec(NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig), compressor)
+
+	data := bytes.Repeat([]byte("gnet"), 1024)
+	for i := 0; i < 3; i++ {
+		out, err := codec.Encode(nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) >= len(data) {
+			t.Fatalf("encoded data(%d bytes) should be smaller than the original data(%d bytes)", len(out), len(data))
+		}
+		res, err := codec.Decode(&mockConn{buf: out})
+		if err != nil {
+			t.Fatalf("decode data with error: %v", err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatal("decoded data should be equal to the original data")
+		}
+	}
+
+	// The frames decompressing beyond the limit are rejected rather than inflated.
+	compressor, _ = NewGzipCompressor(gzip.BestSpeed, len(data)-1)
+	codec = NewCompressionCodec(NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig), compressor)
+	out, _ := codec.Encode(nil, data)
+	_, err = codec.Decode(&mockConn{buf: out})
+	if cerr, ok := err.(*CodecError); !ok || cerr.Err != errors.ErrDecompressedTooLarge || cerr.N != 0 {
+		t.Fatalf("expected decompressed frame too large, but got: %v", err)
+	}
+
+	// The corrupt frames have been consumed by the inner codec, which must close the connection.
+	codec = NewCompressionCodec(NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig), compressor)
+	corrupt := append([]byte{0, 0, 0, 8}, "not gzip"...)
+	_, err = codec.Decode(&mockConn{buf: corrupt})
+	if cerr, ok := err.(*CodecError); !ok || cerr.N != 0 {
+		t.Fatalf("expected codec error of the corrupt frame, but got: %v", err)
+	}
+}
+
+func TestLengthFieldBasedFrameCodecChecksum(t *testing.T) {
+	for _, checksum := range []func([]byte) uint32{ChecksumCRC32, ChecksumCRC32C} {
+		encoderConfig := EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, Checksum: checksum}
+		decoderConfig := DecoderConfig{
+			ByteOrder:           binary.BigEndian,
+			LengthFieldLength:   2,
+			InitialBytesToStrip: 2,
+			Checksum:            checksum,
+		}
+		codec := NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig)
+
+		data := make([]byte, 128)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		out, err := codec.Encode(nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 2+len(data)+4 || binary.BigEndian.Uint16(out) != uint16(len(data)+4) {
+			t.Fatalf("unexpected encoded frame of %d bytes", len(out))
+		}
+		if res, err := codec.Decode(&mockConn{buf: out}); err != nil {
+			t.Fatalf("decode data with error: %v", err)
+		} else if !bytes.Equal(res, data) {
+			t.Fatal("decoded data should be equal to the original data")
+		}
+
+		out[10] ^= 0xff
+		_, err = codec.Decode(&mockConn{buf: out})
+		if cerr, ok := err.(*CodecError); !ok || cerr.Err != errors.ErrChecksumMismatch || cerr.N != len(out) {
+			t.Fatalf("expected checksum mismatch of the whole frame, but got: %v", err)
+		}
+
+		// The frames shorter than a checksum are complete frames as well, which must be rejected as a whole.
+		for n := 1; n < 4; n++ {
+			short := append([]byte{0, byte(n)}, make([]byte, n)...)
+			_, err = codec.Decode(&mockConn{buf: short})
+			if cerr, ok := err.(*CodecError); !ok || cerr.Err != errors.ErrChecksumMismatch || cerr.N != len(short) {
+				t.Fatalf("expected checksum mismatch of the whole %d-byte frame, but got: %v", len(short), err)
+			}
+		}
+	}
+}
+
+type streamConn struct {
+	Conn
+	buf []byte
+}
+
+func (c *streamConn) Read() []byte {
+	return c.buf
+}
+
+func (c *streamConn) ShiftN(n int) int {
+	c.buf = c.buf[n:]
+	return n
+}
+
+func (c *streamConn) ResetBuffer() {
+	c.buf = nil
+}
+
+func TestFragmentCodec(t *testing.T) {
+	if _, err := NewFragmentCodec(fragmentHeaderLength, 0, 0); err == nil {
+		t.Fatal("should have an error of invalid MTU")
+	}
+	codec, err := NewFragmentCodec(100, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	fragments, err := codec.Split(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fragments) != 12 {
+		t.Fatalf("expected 12 fragments, but got %d", len(fragments))
+	}
+	rand.Shuffle(len(fragments), func(i, j int) { fragments[i], fragments[j] = fragments[j], fragments[i] })
+	for i, fragment := range fragments {
+		if len(fragment) > 100 {
+			t.Fatalf("fragment of %d bytes exceeds the MTU", len(fragment))
+		}
+		msg, err := codec.Reassemble("peer", fragment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(fragments)-1 && msg != nil {
+			t.Fatal("message should not be reassembled before all fragments arrive")
+		}
+		if i == len(fragments)-1 && !bytes.Equal(msg, data) {
+			t.Fatal("reassembled message should be equal to the original data")
+		}
+	}
+
+	out, err := codec.Encode(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, _ := codec.Encode(nil, []byte("gnet"))
+	c := &streamConn{buf: append(out, small...)}
+	if msg, err := codec.Decode(c); err != nil || !bytes.Equal(msg, data) {
+		t.Fatalf("decode data with error: %v", err)
+	}
+	if msg, err := codec.Decode(c); err != nil || string(msg) != "gnet" {
+		t.Fatalf("decode data with error: %v", err)
+	}
+	if _, err := codec.Decode(c); err != errors.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, but got: %v", err)
+	}
+
+	// The malformed fragment is left in the buffer for the policy of codec errors.
+	bad := append([]byte(nil), small...)
+	bad[4] = 0xff // the index is out of the count
+	c.buf = append(bad, small...)
+	_, err = codec.Decode(c)
+	if cerr, ok := err.(*CodecError); !ok || cerr.Err != errors.ErrInvalidFragment || cerr.N != len(bad) {
+		t.Fatalf("expected invalid fragment, but got: %v", err)
+	}
+	c.ShiftN(len(bad))
+	if msg, err := codec.Decode(c); err != nil || string(msg) != "gnet" {
+		t.Fatalf("decode data with error: %v", err)
+	}
+
+	// The incomplete messages are dropped after the timeout.
+	fragments, _ = codec.Split(data)
+	_, _ = codec.Reassemble("peer", fragments[0])
+	codec.purge(time.Now().Add(2 * time.Second))
+	if len(codec.pending) != 0 || codec.buffered != 0 {
+		t.Fatalf("expected the incomplete message to be dropped, but got %d pending", len(codec.pending))
+	}
+}
+
+// newFragment builds a fragment of the message id by hand, which may be forged by a peer.
+func newFragment(id uint32, index, count int, payload []byte) []byte {
+	fragment := make([]byte, fragmentHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(fragment, id)
+	binary.BigEndian.PutUint16(fragment[4:], uint16(index))
+	binary.BigEndian.PutUint16(fragment[6:], uint16(count))
+	binary.BigEndian.PutUint16(fragment[8:], uint16(len(payload)))
+	copy(fragment[fragmentHeaderLength:], payload)
+	return fragment
+}
+
+func TestFragmentCodecLimits(t *testing.T) {
+	codec, err := NewFragmentCodec(100, time.Second, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = codec.Split(make([]byte, 1001)); err == nil {
+		t.Fatal("should have an error of too large message")
+	}
+	payload := make([]byte, 90)
+
+	// The fragments of a message must be numbered within the header.
+	tiny, _ := NewFragmentCodec(fragmentHeaderLength+1, time.Second, 0x10000)
+	if _, err = tiny.Split(make([]byte, 0xffff)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tiny.Split(make([]byte, 0x10000)); err == nil {
+		t.Fatal("should have an error of too many fragments")
+	}
+
+	// Every connection reassembles its messages on its own.
+	if _, err = codec.Reassemble("peer", newFragment(1, 0, 2, payload)); err != nil {
+		t.Fatal(err)
+	}
+	if fresh := codec.NewCodec().(*FragmentCodec); len(fresh.pending) != 0 || fresh.maxSize != codec.maxSize {
+		t.Fatalf("expected a new codec of the same configuration with no incomplete messages")
+	}
+	codec, _ = NewFragmentCodec(100, time.Second, 1000)
+
+	// The fragment count can't exceed what maxSize takes.
+	if _, err = codec.Reassemble("peer", newFragment(1, 0, 13, payload)); err != errors.ErrFragmentLimit {
+		t.Fatalf("expected fragment limit, but got: %v", err)
+	}
+
+	// The sizes of the fragments can't add up beyond maxSize.
+	for i := 0; i < 11; i++ {
+		if _, err = codec.Reassemble("peer", newFragment(2, i, 12, payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = codec.Reassemble("peer", newFragment(2, 11, 12, payload)); err != errors.ErrFragmentLimit {
+		t.Fatalf("expected fragment limit, but got: %v", err)
+	}
+	if len(codec.pending) != 0 || codec.buffered != 0 {
+		t.Fatalf("expected the oversized message to be dropped, but got %d pending", len(codec.pending))
+	}
+
+	// The incomplete messages can't hold more than 4 times maxSize bytes in total.
+	for id := uint32(0); id < 44; id++ {
+		if _, err = codec.Reassemble("peer", newFragment(id, 0, 2, payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = codec.Reassemble("peer", newFragment(44, 0, 2, payload)); err != errors.ErrFragmentLimit {
+		t.Fatalf("expected fragment limit, but got: %v", err)
+	}
+
+	// Nor can there be more than 1024 incomplete messages.
+	codec, _ = NewFragmentCodec(100, time.Second, 1<<20)
+	for id := uint32(0); id < maxPendingMessages; id++ {
+		if _, err = codec.Reassemble("peer", newFragment(id, 0, 2, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = codec.Reassemble("peer", newFragment(maxPendingMessages, 0, 2, nil)); err != errors.ErrFragmentLimit {
+		t.Fatalf("expected fragment limit, but got: %v", err)
+	}
+}
+
+func TestLineBasedFrameCodec(t *testing.T) {
+	codec := NewLineBasedFrameCodec(8, true)
+	if out, _ := codec.Encode(nil, []byte("HELO")); string(out) != "HELO\r\n" {
+		t.Fatalf("unexpected encoded line: %q", out)
+	}
+
+	c := &streamConn{buf: []byte("HELO\r\nQUIT\ntoo long line\r\n")}
+	for _, want := range []string{"HELO", "QUIT"} {
+		if line, err := codec.Decode(c); err != nil || string(line) != want {
+			t.Fatalf("expected line %q, but got %q with error: %v", want, line, err)
+		}
+	}
+	skipLineTooLong(t, codec, c, len("too long line\r\n"))
+	if _, err := codec.Decode(c); err != errors.ErrCRLFNotFound {
+		t.Fatalf("expected no CRLF, but got: %v", err)
+	}
+
+	// A line of exactly maxLength bytes may be waiting for its LF.
+	c.buf = []byte("01234567\r")
+	if _, err := codec.Decode(c); err != errors.ErrCRLFNotFound {
+		t.Fatalf("expected no CRLF, but got: %v", err)
+	}
+	c.buf = append(c.buf, '\n')
+	if line, err := codec.Decode(c); err != nil || string(line) != "01234567" {
+		t.Fatalf("expected line %q, but got %q with error: %v", "01234567", line, err)
+	}
+
+	// The overlong line is discarded piece by piece up to its line ending, the next line is intact.
+	c.buf = []byte("0123456789AB")
+	skipLineTooLong(t, codec, c, 2)
+	c.buf = append(c.buf, "abc"...)
+	skipLineTooLong(t, codec, c, 3)
+	c.buf = append(c.buf, "def\r\nOK\r\n"...)
+	skipLineTooLong(t, codec, c, 15)
+	if line, err := codec.Decode(c); err != nil || string(line) != "OK" {
+		t.Fatalf("expected line %q, but got %q with error: %v", "OK", line, err)
+	}
+
+	// Without CRLF, a line of maxLength+1 bytes is too long as well.
+	codec = NewLineBasedFrameCodec(8, false)
+	c.buf = []byte("012345678\n")
+	skipLineTooLong(t, codec, c, 10)
+	c.buf = []byte("0123456789")
+	skipLineTooLong(t, codec, c, 1)
+	c.buf = append(c.buf, "\nOK\n"...)
+	skipLineTooLong(t, codec, c, 10)
+	if line, err := codec.Decode(c); err != nil || string(line) != "OK" {
+		t.Fatalf("expected line %q, but got %q with error: %v", "OK", line, err)
+	}
+}
+
+// skipLineTooLong expects codec to report n bytes of an overlong line at the head of c and skips them.
+func skipLineTooLong(t *testing.T, codec ICodec, c *streamConn, n int) {
+	t.Helper()
+	_, err := codec.Decode(c)
+	cerr, ok := err.(*CodecError)
+	if !ok || cerr.Err != errors.ErrLineTooLong || cerr.N != n {
+		t.Fatalf("expected %d bytes of line too long, but got: %v", n, err)
+	}
+	c.ShiftN(cerr.N)
+}
@@ -26,47 +26,113 @@ package gnet
 import (
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/socket"
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
+	"github.com/panjf2000/gnet/pool/slab"
 	"github.com/panjf2000/gnet/ringbuffer"
 	"golang.org/x/sys/unix"
 )
 
 type conn struct {
-	fd             int                    // file descriptor
-	sa             unix.Sockaddr          // remote socket address
-	ctx            interface{}            // user-defined context
-	loop           *eventloop             // connected event-loop
-	codec          ICodec                 // codec for TCP
-	buffer         []byte                 // reuse memory of inbound data as a temporary buffer
-	opened         bool                   // connection opened event fired
-	localAddr      net.Addr               // local addr
-	remoteAddr     net.Addr               // remote addr
-	byteBuffer     *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
-	inboundBuffer  *ringbuffer.RingBuffer // buffer for data from client
-	outboundBuffer *ringbuffer.RingBuffer // buffer for data that is ready to write to client
-}
-
-func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, remoteAddr net.Addr) (c *conn) {
-	return &conn{
-		fd:             fd,
-		sa:             sa,
-		loop:           el,
-		codec:          el.svr.codec,
-		localAddr:      el.ln.lnaddr,
-		remoteAddr:     remoteAddr,
-		inboundBuffer:  prb.Get(),
-		outboundBuffer: prb.Get(),
+	fd              int                    // file descriptor
+	sa              unix.Sockaddr          // remote socket address
+	origDst         unix.Sockaddr          // original destination of the UDP datagram redirected by TPROXY
+	ctx             interface{}            // user-defined context
+	loop            *eventloop             // connected event-loop
+	codec           ICodec                 // codec for TCP
+	eventHandler    EventHandler           // event-handler of the connection
+	protocols       []Protocol             // protocols to sniff before the connection is opened
+	opened          bool                   // connection opened event fired
+	priority        Priority               // QoS class of the connection
+	idleSweeps      uint8                  // number of idle sweeps without traffic in a row, see idleReaper
+	tags            []connTag              // tags set by SetTag
+	openedAt        time.Time              // when the connection was opened, only set with Options.AccessLog
+	bytesIn         uint64                 // number of bytes read from the connection
+	bytesOut        uint64                 // number of bytes written to the connection
+	frames          uint64                 // number of inbound frames handed over to the event handler
+	pcap            *pcapFlow              // capture of the traffic, nil unless selected by Options.PcapFilter
+	faults          *faultState            // fault injection, nil unless Options.Faults is set
+	readBufferCap   int                    // maximum number of bytes read on every readable event
+	coalescing      bool                   // coalescing the outbound data in outbound buffer
+	closeAfterFlush bool                   // close the connection once outbound buffer is drained
+	closeWrite      bool                   // shut down the writing side once outbound buffer is drained
+	readClosed      bool                   // the reading side has been shut down
+	writeClosed     bool                   // the writing side has been shut down
+	localAddr       net.Addr               // local addr
+	remoteAddr      net.Addr               // remote addr
+	byteBuffer      *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
+	inboundBuffer   *ringbuffer.RingBuffer // buffer for data from client
+	outboundBuffer  *ringbuffer.RingBuffer // buffer for data that is ready to write to client
+	drained         uint64                 // number of bytes shifted out of outbound buffer ever
+	flushWaiters    []flushWaiter          // callbacks of AsyncWrite waiting for outbound buffer to drain
+	gen             uint32                 // generation of the connection, bumped whenever it is released
+}
+
+// flushWaiter is a callback waiting for the outbound buffer of a connection to be drained up to mark.
+type flushWaiter struct {
+	mark     uint64
+	callback AsyncCallback
+}
+
+// connPool recycles the TCP connections released by the servers with Options.PoolConns on.
+var connPool = sync.Pool{New: func() interface{} { return new(conn) }}
+
+func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr net.Addr) (c *conn) {
+	// Writing to a peer that has gone away must fail with EPIPE rather than raise SIGPIPE.
+	_ = socket.SetNoSigPipe(fd)
+	if el.svr.opts.PoolConns {
+		c = connPool.Get().(*conn)
+	} else {
+		c = new(conn)
+	}
+	c.fd = fd
+	c.sa = sa
+	c.loop = el
+	c.codec = instantiateCodec(el.svr.codec)
+	c.eventHandler = el.eventHandler
+	c.localAddr = localAddr
+	c.remoteAddr = remoteAddr
+	c.inboundBuffer = prb.Get()
+	c.outboundBuffer = prb.Get()
+	var alloc ringbuffer.Allocator
+	if el.svr.opts.SlabBuffers {
+		alloc = slab.Default
 	}
+	c.inboundBuffer.SetAllocator(alloc)
+	c.outboundBuffer.SetAllocator(alloc)
+	if cfg := el.svr.opts.Faults; cfg != nil {
+		c.faults = newFaultState(cfg, fd)
+	}
+	return
 }
 
 func (c *conn) releaseTCP() {
+	// Invalidate the tasks queued for this connection, which may be recycled for another one by now.
+	atomic.AddUint32(&c.gen, 1)
 	c.opened = false
+	c.closeAfterFlush = false
+	c.closeWrite = false
+	c.readClosed = false
+	c.writeClosed = false
+	c.priority = PriorityNormal
+	c.idleSweeps = 0
+	c.readBufferCap = 0
+	c.drained = 0
+	c.flushWaiters = nil
+	untag(c.tags)
+	c.tags = nil
+	c.openedAt = time.Time{}
+	c.bytesIn, c.bytesOut, c.frames = 0, 0, 0
+	c.pcap = nil
+	c.faults = nil
 	c.sa = nil
 	c.ctx = nil
-	c.buffer = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
 	prb.Put(c.inboundBuffer)
@@ -77,15 +143,82 @@ func (c *conn) releaseTCP() {
 	c.byteBuffer = nil
 }
 
-func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
+// recycleConn hands the released connection over to connPool with Options.PoolConns on, which is put off until
+// the current iteration of the event-loop is done with c, since its callers may still be reading c by then.
+// It must be called on the event-loop.
+func (el *eventloop) recycleConn(c *conn) {
+	if !el.svr.opts.PoolConns {
+		return
+	}
+	if len(el.recycled) == 0 {
+		_ = el.poller.Trigger(el.flushRecycled)
+	}
+	el.recycled = append(el.recycled, c)
+}
+
+// flushRecycled puts the connections released in the previous iterations of the event-loop into connPool.
+func (el *eventloop) flushRecycled() error {
+	for i, c := range el.recycled {
+		c.fd = 0
+		c.codec = nil
+		c.eventHandler = nil
+		c.protocols = nil
+		connPool.Put(c)
+		el.recycled[i] = nil
+	}
+	el.recycled = el.recycled[:0]
+	return nil
+}
+
+func newUDPConn(fd int, el *eventloop, localAddr net.Addr, sa unix.Sockaddr) *conn {
 	return &conn{
-		fd:         fd,
-		sa:         sa,
-		localAddr:  el.ln.lnaddr,
-		remoteAddr: socket.SockaddrToUDPAddr(sa),
+		fd:            fd,
+		sa:            sa,
+		loop:          el,
+		eventHandler:  el.eventHandler,
+		localAddr:     localAddr,
+		remoteAddr:    socket.SockaddrToUDPAddr(sa),
+		inboundBuffer: ringbuffer.EmptyRingBuffer,
 	}
 }
 
+// route binds the connection to the event-handler and codec of the listener it arrived on, if any.
+func (c *conn) route(ln *listener) {
+	if ln.eventHandler != nil {
+		c.eventHandler = ln.eventHandler
+	}
+	if ln.codec != nil {
+		c.codec = instantiateCodec(ln.codec)
+	}
+	c.protocols = ln.protocols
+}
+
+// sniffing reports whether the protocol of the connection is yet to be known.
+func (c *conn) sniffing() bool {
+	return len(c.protocols) > 0
+}
+
+// sniff peeks the inbound data to bind the connection to the protocol it speaks,
+// it reports false if more data is needed to tell.
+func (c *conn) sniff() bool {
+	p, done := sniff(c.protocols, c.Read())
+	if !done {
+		return false
+	}
+	c.protocols = nil
+	if p != nil {
+		if p.EventHandler != nil {
+			c.eventHandler = p.EventHandler
+		}
+		if p.Codec != nil {
+			c.codec = instantiateCodec(p.Codec)
+		}
+	}
+	return true
+}
+
+// releaseUDP drops the references of the connection, except for the addresses that SendTo reads,
+// which may be called by other goroutines after React returns.
 func (c *conn) releaseUDP() {
 	c.ctx = nil
 	c.localAddr = nil
@@ -93,7 +226,7 @@ func (c *conn) releaseUDP() {
 }
 
 func (c *conn) open(buf []byte) {
-	n, err := unix.Write(c.fd, buf)
+	n, err := c.writeOut(buf)
 	if err != nil {
 		_, _ = c.outboundBuffer.Write(buf)
 		return
@@ -104,6 +237,65 @@ func (c *conn) open(buf []byte) {
 	}
 }
 
+// shiftOutbound discards the n bytes written to the kernel from outbound buffer and calls back the AsyncWrite
+// calls whose data has been flushed by now.
+func (c *conn) shiftOutbound(n int) {
+	c.outboundBuffer.Shift(n)
+	c.drained += uint64(n)
+	i := 0
+	for ; i < len(c.flushWaiters) && c.flushWaiters[i].mark <= c.drained; i++ {
+		c.flushWaiters[i].callback(c, nil)
+	}
+	if i > 0 {
+		c.flushWaiters = c.flushWaiters[i:]
+	}
+}
+
+// failFlushWaiters calls back all the AsyncWrite calls whose data is left in outbound buffer with err.
+func (c *conn) failFlushWaiters(err error) {
+	for _, w := range c.flushWaiters {
+		w.callback(c, err)
+	}
+	c.flushWaiters = nil
+}
+
+// watchWrite makes the poller watch the writable events of the connection, along with the readable events
+// unless the reading side of it has been shut down.
+func (c *conn) watchWrite() error {
+	if c.readClosed {
+		return c.loop.poller.ModWrite(c.fd)
+	}
+	return c.loop.poller.ModReadWrite(c.fd)
+}
+
+// unwatchWrite stops the poller watching the writable events of the connection.
+func (c *conn) unwatchWrite() error {
+	if c.readClosed {
+		return c.loop.poller.ModNone(c.fd)
+	}
+	return c.loop.poller.ModRead(c.fd)
+}
+
+// rewatch registers the connection to a poller that has been reset, watching the same events as before.
+func (c *conn) rewatch() error {
+	if err := c.loop.poller.AddRead(c.fd); err != nil {
+		return err
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		return c.watchWrite()
+	}
+	if c.readClosed {
+		return c.unwatchWrite()
+	}
+	return nil
+}
+
+// isUDP reports whether c is a UDP connection, which shares the socket of its listener.
+func (c *conn) isUDP() bool {
+	_, ok := c.remoteAddr.(*net.UDPAddr)
+	return ok
+}
+
 func (c *conn) read() ([]byte, error) {
 	return c.codec.Decode(c)
 }
@@ -113,136 +305,484 @@ func (c *conn) write(buf []byte) (err error) {
 	if outFrame, err = c.codec.Encode(c, buf); err != nil {
 		return
 	}
+	if c.faults != nil {
+		return c.injectOutbound(outFrame)
+	}
+	return c.writeFrame(outFrame)
+}
+
+// writeFrame writes the encoded outFrame to the connection, buffering what can't be written right away.
+func (c *conn) writeFrame(outFrame []byte) (err error) {
 	// If there is pending data in outbound buffer, the current data ought to be appended to the outbound buffer
-	// for maintaining the sequence of network packets.
-	if !c.outboundBuffer.IsEmpty() {
+	// for maintaining the sequence of network packets, so does it when the outbound data is being coalesced.
+	if c.coalescing || !c.outboundBuffer.IsEmpty() {
 		_, _ = c.outboundBuffer.Write(outFrame)
 		return
 	}
 
 	var n int
-	if n, err = unix.Write(c.fd, outFrame); err != nil {
+	if n, err = c.writeOut(outFrame); err != nil {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to client in the next round.
 		if err == unix.EAGAIN {
 			_, _ = c.outboundBuffer.Write(outFrame)
-			err = c.loop.poller.ModReadWrite(c.fd)
+			err = c.watchWrite()
 			return
 		}
-		return c.loop.loopCloseConn(c, os.NewSyscallError("write", err))
+		return c.loop.loopCloseConn(c, writeCloseReason(err))
 	}
 	// Fail to send all data back to client, buffer the leftover data for the next round.
 	if n < len(outFrame) {
 		_, _ = c.outboundBuffer.Write(outFrame[n:])
-		err = c.loop.poller.ModReadWrite(c.fd)
+		err = c.watchWrite()
 	}
 	return
 }
 
+// countIn accounts for the data read from the connection.
+func (c *conn) countIn(data []byte) {
+	c.idleSweeps = 0
+	c.bytesIn += uint64(len(data))
+	countTagged(c.tags, len(data), 0)
+	if c.pcap != nil {
+		c.pcap.inbound(data)
+	}
+}
+
+// writeOut writes data to the connection, every write of it goes through here to be accounted for by countOut.
+func (c *conn) writeOut(data []byte) (n int, err error) {
+	if n, err = socket.Write(c.fd, data); err == nil {
+		c.countOut(data[:n])
+	}
+	return
+}
+
+// countOut accounts for the data written to the connection.
+func (c *conn) countOut(data []byte) {
+	c.idleSweeps = 0
+	c.bytesOut += uint64(len(data))
+	countTagged(c.tags, 0, len(data))
+	if c.pcap != nil {
+		c.pcap.outbound(data)
+	}
+}
+
+func (c *conn) accessLogEntry(err error) AccessLogEntry {
+	return AccessLogEntry{
+		LocalAddr:  c.localAddr,
+		RemoteAddr: c.remoteAddr,
+		Duration:   time.Since(c.openedAt),
+		BytesIn:    c.bytesIn,
+		BytesOut:   c.bytesOut,
+		Frames:     c.frames,
+		Tags:       tagMap(c.tags),
+		Err:        err,
+	}
+}
+
 func (c *conn) sendTo(buf []byte) error {
-	return unix.Sendto(c.fd, buf, 0, c.sa)
+	if c.origDst != nil {
+		return c.loop.sendFromOrigDst(c.origDst, c.sa, buf)
+	}
+	return c.loop.sendToUDP(c.fd, c.sa, buf)
 }
 
 // ================================= Public APIs of gnet.Conn =================================
 
 func (c *conn) Read() []byte {
-	if c.inboundBuffer.IsEmpty() {
-		return c.buffer
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if tail == nil {
+		return head
 	}
-	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer)
+	bytebuffer.Put(c.byteBuffer)
+	c.byteBuffer = c.inboundBuffer.ByteBuffer()
 	return c.byteBuffer.Bytes()
 }
 
 func (c *conn) ResetBuffer() {
-	c.buffer = c.buffer[:0]
 	c.inboundBuffer.Reset()
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
 }
 
 func (c *conn) ReadN(n int) (size int, buf []byte) {
-	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := len(c.buffer)
-	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
-		n = totalLen
+	if inBufferLen := c.inboundBuffer.Length(); inBufferLen < n || n <= 0 {
+		n = inBufferLen
 	}
 	size = n
-	if c.inboundBuffer.IsEmpty() {
-		buf = c.buffer[:n]
+	head, tail := c.inboundBuffer.LazyRead(n)
+	if tail == nil {
+		buf = head
 		return
 	}
-	head, tail := c.inboundBuffer.LazyRead(n)
+	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = bytebuffer.Get()
 	_, _ = c.byteBuffer.Write(head)
 	_, _ = c.byteBuffer.Write(tail)
-	if inBufferLen >= n {
-		buf = c.byteBuffer.Bytes()
-		return
-	}
-
-	restSize := n - inBufferLen
-	_, _ = c.byteBuffer.Write(c.buffer[:restSize])
 	buf = c.byteBuffer.Bytes()
 	return
 }
 
 func (c *conn) ShiftN(n int) (size int) {
 	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := len(c.buffer)
-	if inBufferLen+tempBufferLen < n || n <= 0 {
+	if inBufferLen < n || n <= 0 {
 		c.ResetBuffer()
-		size = inBufferLen + tempBufferLen
+		size = inBufferLen
 		return
 	}
 	size = n
-	if c.inboundBuffer.IsEmpty() {
-		c.buffer = c.buffer[n:]
-		return
-	}
-
+	c.inboundBuffer.Shift(n)
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
+	return
+}
+
+func (c *conn) BufferLength() int {
+	return c.inboundBuffer.Length()
+}
 
-	if inBufferLen >= n {
-		c.inboundBuffer.Shift(n)
+func (c *conn) SetReadBufferCap(n int) {
+	if c.isUDP() || !c.opened {
 		return
 	}
-	c.inboundBuffer.Reset()
+	if n <= 0 || n > c.loop.svr.opts.ReadBufferCap {
+		n = c.loop.svr.opts.ReadBufferCap
+	}
+	c.readBufferCap = n
+	c.inboundBuffer.Resize(n)
+}
 
-	restSize := n - inBufferLen
-	c.buffer = c.buffer[restSize:]
-	return
+func (c *conn) SetWriteBufferCap(n int) {
+	if c.isUDP() || !c.opened {
+		return
+	}
+	c.outboundBuffer.Resize(n)
 }
 
-func (c *conn) BufferLength() int {
-	return c.inboundBuffer.Length() + len(c.buffer)
+func (c *conn) InboundBuffered() int {
+	return c.inboundBuffer.Length()
+}
+
+func (c *conn) OutboundBuffered() int {
+	return c.outboundBuffer.Length()
 }
 
-func (c *conn) AsyncWrite(buf []byte) error {
-	return c.loop.poller.Trigger(func() error {
-		if c.opened {
-			return c.write(buf)
+// current wraps task so that it only runs for the connection of the current generation, the tasks queued
+// before the connection is released must not act on another connection it is recycled for by Options.PoolConns.
+func (c *conn) current(task func() error) func() error {
+	gen := atomic.LoadUint32(&c.gen)
+	return func() error {
+		if atomic.LoadUint32(&c.gen) != gen {
+			return nil
 		}
-		return nil
+		return task()
+	}
+}
+
+func (c *conn) AsyncWrite(buf []byte, callbacks ...AsyncCallback) error {
+	gen := atomic.LoadUint32(&c.gen)
+	return c.loop.poller.Trigger(func() (err error) {
+		if !c.opened || atomic.LoadUint32(&c.gen) != gen {
+			invokeAsyncCallbacks(c, callbacks, errors.ErrConnectionClosed)
+			return
+		}
+		err = c.write(buf)
+		switch {
+		case !c.opened:
+			invokeAsyncCallbacks(c, callbacks, errors.ErrConnectionClosed)
+		case err != nil:
+			invokeAsyncCallbacks(c, callbacks, err)
+		case c.outboundBuffer.IsEmpty():
+			invokeAsyncCallbacks(c, callbacks, nil)
+		default:
+			mark := c.drained + uint64(c.outboundBuffer.Length())
+			for _, cb := range callbacks {
+				c.flushWaiters = append(c.flushWaiters, flushWaiter{mark, cb})
+			}
+		}
+		return
 	})
 }
 
 func (c *conn) SendTo(buf []byte) error {
-	return c.sendTo(buf)
+	// SendTo can be called by any goroutine, so the datagram is handed over to the event-loop, which owns
+	// the send queue and the transparent sockets of the original destinations, and keeps the datagrams of
+	// every caller in order.
+	el, fd, sa, dst, packet := c.loop, c.fd, c.sa, c.origDst, append([]byte(nil), buf...)
+	return el.poller.Trigger(func() error {
+		if dst != nil {
+			_ = el.sendFromOrigDst(dst, sa, packet)
+		} else {
+			_ = el.sendToUDP(fd, sa, packet)
+		}
+		return nil
+	})
+}
+
+func (c *conn) QueueTo(buf []byte) error {
+	if !c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	if c.origDst != nil {
+		return c.sendTo(buf)
+	}
+	c.loop.queueToUDP(c.fd, c.sa, buf)
+	return nil
+}
+
+func (c *conn) TCPInfo() (*TCPInfo, error) {
+	return getTCPInfo(c.fd)
+}
+
+func (c *conn) OriginalDst() (net.Addr, error) {
+	if _, ok := c.remoteAddr.(*net.UDPAddr); ok {
+		if c.origDst != nil {
+			return c.localAddr, nil
+		}
+		return nil, errors.ErrUnsupportedOp
+	}
+	return socket.GetOriginalDst(c.fd)
+}
+
+func (c *conn) SetMark(mark int) error {
+	return socket.SetMark(c.fd, mark)
+}
+
+func (c *conn) Priority() Priority {
+	return c.priority
+}
+
+func (c *conn) SetPriority(p Priority) {
+	if _, ok := c.remoteAddr.(*net.UDPAddr); ok || !c.opened {
+		return
+	}
+	c.priority = p
+	c.loop.poller.Prioritize(c.fd, p == PriorityHigh)
+}
+
+func (c *conn) Tag(key string) string {
+	return tagValue(c.tags, key)
+}
+
+func (c *conn) SetTag(key, value string) {
+	if _, ok := c.remoteAddr.(*net.UDPAddr); ok {
+		return
+	}
+	c.tags = c.loop.tags.setTag(c.tags, key, value)
+}
+
+func (c *conn) SetNoDelay(noDelay bool) error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	var v int
+	if noDelay {
+		v = 1
+	}
+	return socket.SetNoDelay(c.fd, v)
+}
+
+func (c *conn) SetLinger(sec int) error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetLinger(c.fd, sec)
+}
+
+func (c *conn) SetKeepAlivePeriod(period time.Duration) error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetKeepAlive(c.fd, int(period/time.Second))
+}
+
+func (c *conn) SetTOS(tos int) error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetTOS(c.fd, tos)
+}
+
+func (c *conn) SetQuickAck(quickAck bool) error {
+	var v int
+	if quickAck {
+		v = 1
+	}
+	return socket.SetQuickAck(c.fd, v)
+}
+
+func (c *conn) Flush() error {
+	if !c.opened {
+		return errors.ErrConnectionClosed
+	}
+	return c.loop.loopFlush(c)
+}
+
+func (c *conn) AsyncFlush() error {
+	return c.loop.poller.Trigger(c.current(func() error {
+		if !c.opened {
+			return nil
+		}
+		return c.loop.loopFlush(c)
+	}))
+}
+
+func (c *conn) CloseRead() error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	if !c.opened {
+		return errors.ErrConnectionClosed
+	}
+	if c.readClosed {
+		return nil
+	}
+	if err := unix.Shutdown(c.fd, unix.SHUT_RD); err != nil {
+		return os.NewSyscallError("shutdown", err)
+	}
+	return c.loop.loopStopReading(c)
+}
+
+func (c *conn) CloseWrite() error {
+	if c.isUDP() {
+		return errors.ErrUnsupportedOp
+	}
+	if !c.opened {
+		return errors.ErrConnectionClosed
+	}
+	if c.writeClosed || c.closeWrite {
+		return nil
+	}
+	// Defer it until the current callback returns, the data returned by which is to be written first.
+	c.closeWrite = true
+	return c.loop.poller.Trigger(c.current(func() error {
+		if !c.opened || !c.outboundBuffer.IsEmpty() {
+			return nil // it is done by loopWrite once the outbound buffer is drained.
+		}
+		return c.loop.loopCloseWrite(c)
+	}))
 }
 
 func (c *conn) Wake() error {
-	return c.loop.poller.Trigger(func() error {
+	return c.loop.poller.UrgentTrigger(c.current(func() error {
 		return c.loop.loopWake(c)
-	})
+	}))
 }
 
 func (c *conn) Close() error {
-	return c.loop.poller.Trigger(func() error {
+	return c.loop.poller.Trigger(c.current(func() error {
 		return c.loop.loopCloseConn(c, nil)
-	})
+	}))
+}
+
+func (c *conn) CloseNow() error {
+	return c.loop.poller.UrgentTrigger(c.current(func() error {
+		return c.loop.loopCloseConn(c, nil)
+	}))
+}
+
+func (c *conn) Detach() (fd int, buf, unsent []byte, err error) {
+	if !c.opened {
+		return -1, nil, nil, errors.ErrConnectionClosed
+	}
+
+	// Removing fd from the poller is the only step that may fail, the connection is left intact if it does.
+	el := c.loop
+	if err = el.poller.Delete(c.fd); err != nil {
+		return -1, nil, nil, err
+	}
+
+	fd = c.fd
+	if c.BufferLength() > 0 {
+		buf = append(buf, c.Read()...)
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		head, tail := c.outboundBuffer.LazyReadAll()
+		unsent = append(append(unsent, head...), tail...)
+	}
+	c.failFlushWaiters(errors.ErrConnectionClosed)
+	if c.pcap != nil {
+		c.pcap.close()
+	}
+	el.connections.del(c.fd)
+	el.addConn(-1)
+	c.releaseTCP()
+	el.recycleConn(c)
+	return
+}
+
+func (c *conn) Hijack() (net.Conn, error) {
+	fd, buf, unsent, err := c.Detach()
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "gnet-hijacked")
+	nc, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 && len(unsent) == 0 {
+		return nc, nil
+	}
+	hc := &hijackedConn{Conn: nc, buf: buf, unsent: unsent}
+	if len(unsent) > 0 {
+		// Send the bytes gnet hasn't written off the event-loop, the writes of the caller wait for them.
+		go func() {
+			hc.mu.Lock()
+			_ = hc.flushUnsent()
+			hc.mu.Unlock()
+		}()
+	}
+	return hc, nil
 }
 
 func (c *conn) Context() interface{}       { return c.ctx }
 func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
 func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+
+// hijackedConn serves the bytes left in gnet before reading from the underlying net.Conn,
+// and writes the ones gnet hasn't sent yet ahead of anything written to it.
+type hijackedConn struct {
+	net.Conn
+	buf    []byte
+	mu     sync.Mutex
+	unsent []byte
+}
+
+// flushUnsent writes the bytes gnet hasn't sent yet, hc.mu must be held.
+func (hc *hijackedConn) flushUnsent() error {
+	for len(hc.unsent) > 0 {
+		n, err := hc.Conn.Write(hc.unsent)
+		hc.unsent = hc.unsent[n:]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hc *hijackedConn) Write(p []byte) (int, error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if err := hc.flushUnsent(); err != nil {
+		return 0, err
+	}
+	return hc.Conn.Write(p)
+}
+
+func (hc *hijackedConn) Close() error {
+	hc.mu.Lock()
+	_ = hc.flushUnsent()
+	hc.mu.Unlock()
+	return hc.Conn.Close()
+}
+
+func (hc *hijackedConn) Read(p []byte) (n int, err error) {
+	if len(hc.buf) > 0 {
+		n = copy(p, hc.buf)
+		hc.buf = hc.buf[n:]
+		return
+	}
+	return hc.Conn.Read(p)
+}
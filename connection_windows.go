@@ -23,7 +23,9 @@ package gnet
 
 import (
 	"net"
+	"time"
 
+	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
 	"github.com/panjf2000/gnet/ringbuffer"
@@ -50,15 +52,49 @@ type udpConn struct {
 type stdConn struct {
 	ctx           interface{}            // user-defined context
 	conn          net.Conn               // original connection
+	pconn         net.PacketConn         // UDP socket that the datagram arrived on
 	loop          *eventloop             // owner event-loop
-	buffer        *bytebuffer.ByteBuffer // reuse memory of inbound data as a temporary buffer
+	buffer        *bytebuffer.ByteBuffer // datagram of UDP
 	codec         ICodec                 // codec for TCP
+	eventHandler  EventHandler           // event-handler of the connection
+	protocols     []Protocol             // protocols to sniff before the connection is opened
 	localAddr     net.Addr               // local server addr
 	remoteAddr    net.Addr               // remote peer addr
+	priority      Priority               // QoS class of the connection
+	tags          []connTag              // tags set by SetTag
+	openedAt      time.Time              // when the connection was opened, only set with Options.AccessLog
+	bytesIn       uint64                 // number of bytes read from the connection
+	bytesOut      uint64                 // number of bytes written to the connection
+	frames        uint64                 // number of inbound frames handed over to the event handler
+	pcap          *pcapFlow              // capture of the traffic, nil unless selected by Options.PcapFilter
 	byteBuffer    *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
 	inboundBuffer *ringbuffer.RingBuffer // buffer for data from client
 }
 
+// writeConn writes buf to the connection and counts it into the tags, it must be called on the event-loop.
+func (c *stdConn) writeConn(buf []byte) (n int, err error) {
+	n, err = c.conn.Write(buf)
+	c.bytesOut += uint64(n)
+	countTagged(c.tags, 0, n)
+	if c.pcap != nil {
+		c.pcap.outbound(buf[:n])
+	}
+	return
+}
+
+func (c *stdConn) accessLogEntry(err error) AccessLogEntry {
+	return AccessLogEntry{
+		LocalAddr:  c.localAddr,
+		RemoteAddr: c.remoteAddr,
+		Duration:   time.Since(c.openedAt),
+		BytesIn:    c.bytesIn,
+		BytesOut:   c.bytesOut,
+		Frames:     c.frames,
+		Tags:       tagMap(c.tags),
+		Err:        err,
+	}
+}
+
 func packTCPConn(c *stdConn, buf []byte) *tcpConn {
 	packet := &tcpConn{c: c}
 	packet.bb = bytebuffer.Get()
@@ -72,14 +108,15 @@ func packUDPConn(c *stdConn, buf []byte) *udpConn {
 	return packet
 }
 
-func newTCPConn(conn net.Conn, el *eventloop) (c *stdConn) {
+func newTCPConn(conn net.Conn, el *eventloop, localAddr net.Addr) (c *stdConn) {
 	c = &stdConn{
 		conn:          conn,
 		loop:          el,
-		codec:         el.svr.codec,
+		codec:         instantiateCodec(el.svr.codec),
+		eventHandler:  el.eventHandler,
 		inboundBuffer: prb.Get(),
 	}
-	c.localAddr = el.svr.ln.lnaddr
+	c.localAddr = localAddr
 	c.remoteAddr = c.conn.RemoteAddr()
 
 	var (
@@ -105,22 +142,63 @@ func newTCPConn(conn net.Conn, el *eventloop) (c *stdConn) {
 
 func (c *stdConn) releaseTCP() {
 	c.ctx = nil
+	c.priority = PriorityNormal
+	untag(c.tags)
+	c.tags = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
 	c.conn = nil
 	prb.Put(c.inboundBuffer)
 	c.inboundBuffer = ringbuffer.EmptyRingBuffer
-	bytebuffer.Put(c.buffer)
-	c.buffer = nil
+	bytebuffer.Put(c.byteBuffer)
+	c.byteBuffer = nil
 }
 
-func newUDPConn(el *eventloop, localAddr, remoteAddr net.Addr) *stdConn {
+func newUDPConn(el *eventloop, pconn net.PacketConn, localAddr, remoteAddr net.Addr) *stdConn {
 	return &stdConn{
-		loop:       el,
-		buffer:     bytebuffer.Get(),
-		localAddr:  localAddr,
-		remoteAddr: remoteAddr,
+		loop:          el,
+		pconn:         pconn,
+		eventHandler:  el.eventHandler,
+		buffer:        bytebuffer.Get(),
+		localAddr:     localAddr,
+		remoteAddr:    remoteAddr,
+		inboundBuffer: ringbuffer.EmptyRingBuffer,
+	}
+}
+
+// route binds the connection to the event-handler and codec of the listener it arrived on, if any.
+func (c *stdConn) route(ln *listener) {
+	if ln.eventHandler != nil {
+		c.eventHandler = ln.eventHandler
 	}
+	if ln.codec != nil {
+		c.codec = instantiateCodec(ln.codec)
+	}
+	c.protocols = ln.protocols
+}
+
+// sniffing reports whether the protocol of the connection is yet to be known.
+func (c *stdConn) sniffing() bool {
+	return len(c.protocols) > 0
+}
+
+// sniff peeks the inbound data to bind the connection to the protocol it speaks,
+// it reports false if more data is needed to tell.
+func (c *stdConn) sniff() bool {
+	p, done := sniff(c.protocols, c.Read())
+	if !done {
+		return false
+	}
+	c.protocols = nil
+	if p != nil {
+		if p.EventHandler != nil {
+			c.eventHandler = p.EventHandler
+		}
+		if p.Codec != nil {
+			c.codec = instantiateCodec(p.Codec)
+		}
+	}
+	return true
 }
 
 func (c *stdConn) releaseUDP() {
@@ -137,96 +215,191 @@ func (c *stdConn) read() ([]byte, error) {
 // ================================= Public APIs of gnet.Conn =================================
 
 func (c *stdConn) Read() []byte {
-	if c.inboundBuffer.IsEmpty() {
-		return c.buffer.Bytes()
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if tail == nil {
+		return head
 	}
-	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer.Bytes())
+	bytebuffer.Put(c.byteBuffer)
+	c.byteBuffer = c.inboundBuffer.ByteBuffer()
 	return c.byteBuffer.Bytes()
 }
 
 func (c *stdConn) ResetBuffer() {
-	c.buffer.Reset()
 	c.inboundBuffer.Reset()
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
 }
 
 func (c *stdConn) ReadN(n int) (size int, buf []byte) {
-	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := c.buffer.Len()
-	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
-		n = totalLen
+	if inBufferLen := c.inboundBuffer.Length(); inBufferLen < n || n <= 0 {
+		n = inBufferLen
 	}
 	size = n
-	if c.inboundBuffer.IsEmpty() {
-		buf = c.buffer.B[:n]
+	head, tail := c.inboundBuffer.LazyRead(n)
+	if tail == nil {
+		buf = head
 		return
 	}
-	head, tail := c.inboundBuffer.LazyRead(n)
+	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = bytebuffer.Get()
 	_, _ = c.byteBuffer.Write(head)
 	_, _ = c.byteBuffer.Write(tail)
-	if inBufferLen >= n {
-		buf = c.byteBuffer.Bytes()
-		return
-	}
-
-	restSize := n - inBufferLen
-	_, _ = c.byteBuffer.Write(c.buffer.B[:restSize])
 	buf = c.byteBuffer.Bytes()
 	return
 }
 
 func (c *stdConn) ShiftN(n int) (size int) {
 	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := c.buffer.Len()
-	if inBufferLen+tempBufferLen < n || n <= 0 {
+	if inBufferLen < n || n <= 0 {
 		c.ResetBuffer()
-		size = inBufferLen + tempBufferLen
+		size = inBufferLen
 		return
 	}
 	size = n
-	if c.inboundBuffer.IsEmpty() {
-		c.buffer.B = c.buffer.B[n:]
-		return
-	}
-
+	c.inboundBuffer.Shift(n)
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
+	return
+}
 
-	if inBufferLen >= n {
-		c.inboundBuffer.Shift(n)
+func (c *stdConn) BufferLength() int {
+	return c.inboundBuffer.Length()
+}
+
+func (c *stdConn) SetReadBufferCap(n int) {
+	if c.conn == nil {
 		return
 	}
-	c.inboundBuffer.Reset()
+	if n <= 0 || n > c.loop.svr.opts.ReadBufferCap {
+		n = c.loop.svr.opts.ReadBufferCap
+	}
+	c.inboundBuffer.Resize(n)
+}
 
-	restSize := n - inBufferLen
-	c.buffer.B = c.buffer.B[restSize:]
-	return
+func (c *stdConn) SetWriteBufferCap(_ int) {}
+
+func (c *stdConn) InboundBuffered() int {
+	return c.inboundBuffer.Length()
 }
 
-func (c *stdConn) BufferLength() int {
-	return c.inboundBuffer.Length() + c.buffer.Len()
+func (c *stdConn) OutboundBuffered() int {
+	return 0
 }
 
-func (c *stdConn) AsyncWrite(buf []byte) (err error) {
-	var encodedBuf []byte
-	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
-		c.loop.ch <- func() (err error) {
-			if c.conn != nil {
-				_, err = c.conn.Write(encodedBuf)
-			}
+func (c *stdConn) AsyncWrite(buf []byte, callbacks ...AsyncCallback) error {
+	// Encode buf on the event-loop as well, the codec may keep the state of the connection.
+	c.loop.ch <- func() (err error) {
+		if c.conn == nil {
+			invokeAsyncCallbacks(c, callbacks, errors.ErrConnectionClosed)
 			return
 		}
+		var encodedBuf []byte
+		if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+			_, err = c.writeConn(encodedBuf)
+		}
+		invokeAsyncCallbacks(c, callbacks, err)
+		return
 	}
-	return
+	return nil
+}
+
+func (c *stdConn) Flush() error {
+	return nil
+}
+
+func (c *stdConn) AsyncFlush() error {
+	return nil
 }
 
 func (c *stdConn) SendTo(buf []byte) (err error) {
-	_, err = c.loop.svr.ln.pconn.WriteTo(buf, c.remoteAddr)
+	_, err = c.pconn.WriteTo(buf, c.remoteAddr)
 	return
 }
 
+func (c *stdConn) QueueTo(buf []byte) error {
+	if c.pconn == nil {
+		return errors.ErrUnsupportedOp
+	}
+	return c.SendTo(buf)
+}
+
+func (c *stdConn) TCPInfo() (*TCPInfo, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) OriginalDst() (net.Addr, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) Priority() Priority {
+	return c.priority
+}
+
+func (c *stdConn) SetPriority(p Priority) {
+	c.priority = p
+}
+
+func (c *stdConn) Tag(key string) string {
+	return tagValue(c.tags, key)
+}
+
+func (c *stdConn) SetTag(key, value string) {
+	if _, ok := c.remoteAddr.(*net.UDPAddr); ok {
+		return
+	}
+	c.tags = c.loop.tags.setTag(c.tags, key, value)
+}
+
+func (c *stdConn) SetMark(_ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetNoDelay(noDelay bool) error {
+	if tc, ok := c.conn.(*net.TCPConn); ok {
+		return tc.SetNoDelay(noDelay)
+	}
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetLinger(sec int) error {
+	if tc, ok := c.conn.(*net.TCPConn); ok {
+		return tc.SetLinger(sec)
+	}
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetKeepAlivePeriod(period time.Duration) error {
+	if tc, ok := c.conn.(*net.TCPConn); ok {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		return tc.SetKeepAlivePeriod(period)
+	}
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetTOS(_ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) SetQuickAck(_ bool) error {
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) CloseRead() error {
+	if tc, ok := c.conn.(*net.TCPConn); ok {
+		return tc.CloseRead()
+	}
+	return errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) CloseWrite() error {
+	if tc, ok := c.conn.(*net.TCPConn); ok {
+		return tc.CloseWrite()
+	}
+	return errors.ErrUnsupportedOp
+}
+
 func (c *stdConn) Wake() error {
 	c.loop.ch <- wakeReq{c}
 	return nil
@@ -239,6 +412,18 @@ func (c *stdConn) Close() error {
 	return nil
 }
 
+func (c *stdConn) CloseNow() error {
+	return c.Close()
+}
+
+func (c *stdConn) Detach() (int, []byte, []byte, error) {
+	return -1, nil, nil, errors.ErrUnsupportedOp
+}
+
+func (c *stdConn) Hijack() (net.Conn, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
 func (c *stdConn) Context() interface{}       { return c.ctx }
 func (c *stdConn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *stdConn) LocalAddr() net.Addr        { return c.localAddr }
@@ -0,0 +1,78 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+// connTable holds the connections of an event-loop indexed by their file-descriptors, which are small integers
+// allocated from the lowest available one, so looking a connection up on every event takes no hashing and the
+// table is a single pointer slice for the GC to scan.
+type connTable struct {
+	conns []*conn
+	count int
+}
+
+// get returns the connection of fd, or nil if there is none.
+func (t *connTable) get(fd int) *conn {
+	if fd < 0 || fd >= len(t.conns) {
+		return nil
+	}
+	return t.conns[fd]
+}
+
+func (t *connTable) set(fd int, c *conn) {
+	if fd >= len(t.conns) {
+		n := 2 * len(t.conns)
+		if n <= fd {
+			n = fd + 1
+		}
+		conns := make([]*conn, n)
+		copy(conns, t.conns)
+		t.conns = conns
+	}
+	if t.conns[fd] == nil {
+		t.count++
+	}
+	t.conns[fd] = c
+}
+
+func (t *connTable) del(fd int) {
+	if fd < 0 || fd >= len(t.conns) || t.conns[fd] == nil {
+		return
+	}
+	t.conns[fd] = nil
+	t.count--
+}
+
+func (t *connTable) len() int {
+	return t.count
+}
+
+// iterate calls f with every connection in the table until it returns false,
+// f may remove connections from the table.
+func (t *connTable) iterate(f func(c *conn) bool) {
+	for _, c := range t.conns {
+		if c != nil && !f(c) {
+			return
+		}
+	}
+}
@@ -27,6 +27,9 @@ var (
 	ErrServerShutdown = errors.New("server is going to be shutdown")
 	// ErrServerInShutdown occurs when attempting to shut the server down more than once.
 	ErrServerInShutdown = errors.New("server is already in shutdown")
+	// ErrServerNotStarted occurs when operating the event-loops of a server that hasn't started serving yet,
+	// e.g. within OnInitComplete.
+	ErrServerNotStarted = errors.New("server is not started yet")
 	// ErrAcceptSocket occurs when acceptor does not accept the new connection properly.
 	ErrAcceptSocket = errors.New("accept a new connection error")
 	// ErrTooManyEventLoopThreads occurs when attempting to set up more than 10,000 event-loop goroutines under LockOSThread mode.
@@ -41,8 +44,38 @@ var (
 	ErrUnsupportedUDSProtocol = errors.New("only unix is supported")
 	// ErrUnsupportedPlatform occurs when running gnet on an unsupported platform.
 	ErrUnsupportedPlatform = errors.New("unsupported platform in gnet")
+	// ErrEmptyAddress occurs when no address is given to serve.
+	ErrEmptyAddress = errors.New("no address to serve")
 	// ErrConnectionClosed occurs when trying to operate a closed connection.
 	ErrConnectionClosed = errors.New("connection is already closed")
+	// ErrUnsupportedOp occurs when calling a method that is not supported on the current platform.
+	ErrUnsupportedOp = errors.New("unsupported operation")
+	// ErrInvalidPortRange occurs when the port range of an address is malformed, e.g. `:9100-9000`.
+	ErrInvalidPortRange = errors.New("invalid port range")
+	// ErrUnsupportedListener occurs when the socket of the listener handed over to gnet can't be taken.
+	ErrUnsupportedListener = errors.New("unsupported listener, its socket is not accessible")
+	// ErrNonLocalAdminAddr occurs when the admin listener is asked to listen on an address reachable from other hosts.
+	ErrNonLocalAdminAddr = errors.New("admin address must be a unix socket or a loopback TCP address")
+
+	// ================================================= close reasons ================================================
+
+	// ErrPeerClosed occurs when the peer closes the connection gracefully.
+	ErrPeerClosed = errors.New("connection closed by peer")
+	// ErrPeerReset occurs when the peer resets the connection, which is found by either reading or writing it,
+	// e.g. writing to a connection whose peer has gone away fails with EPIPE.
+	ErrPeerReset = errors.New("connection reset by peer")
+	// ErrPeerUnreachable occurs when the peer can't be reached any more, e.g. the keep-alive probes have failed
+	// or the TCP_USER_TIMEOUT has expired, which are network failures rather than protocol errors.
+	ErrPeerUnreachable = errors.New("connection peer unreachable")
+	// ErrWriteFailed occurs when the connection is closed due to a failure of writing data to it.
+	ErrWriteFailed = errors.New("failed to write data to connection")
+	// ErrIdleTimeout occurs when the connection is closed after being idle for too long.
+	ErrIdleTimeout = errors.New("connection idle timeout")
+	// ErrBufferOverflow occurs when the connection is closed for holding the largest buffers while the buffers of
+	// the server exceed the memory limit.
+	ErrBufferOverflow = errors.New("connection buffer overflow")
+	// ErrOverloaded occurs when the low-priority connection is dropped due to the overload of its event-loop.
+	ErrOverloaded = errors.New("event-loop overloaded")
 
 	// ================================================= codec errors =================================================
 
@@ -54,8 +87,18 @@ var (
 	ErrDelimiterNotFound = errors.New("there is no such a delimiter")
 	// ErrCRLFNotFound occurs when a CRLF is not found by codec.
 	ErrCRLFNotFound = errors.New("there is no CRLF")
+	// ErrLineTooLong occurs when a line is longer than the maximum length allowed by codec.
+	ErrLineTooLong = errors.New("line is too long")
 	// ErrUnsupportedLength occurs when unsupported lengthFieldLength is from input data.
 	ErrUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
 	// ErrTooLessLength occurs when adjusted frame length is less than zero.
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrChecksumMismatch occurs when the checksum of a frame doesn't match its content.
+	ErrChecksumMismatch = errors.New("frame checksum mismatch")
+	// ErrInvalidFragment occurs when a fragment is malformed or inconsistent with the other fragments of its message.
+	ErrInvalidFragment = errors.New("invalid fragment")
+	// ErrFragmentLimit occurs when the fragments of a message exceed the limits of the incomplete messages.
+	ErrFragmentLimit = errors.New("fragments exceed the limit")
+	// ErrDecompressedTooLarge occurs when a frame decompresses to more bytes than the limit of the compressor.
+	ErrDecompressedTooLarge = errors.New("decompressed frame is too large")
 )
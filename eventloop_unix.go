@@ -48,14 +48,24 @@ type eventloop struct {
 
 //nolint:structcheck
 type internalEventloop struct {
-	ln           *listener       // listener
-	idx          int             // loop index in the server loops list
-	svr          *server         // server in loop
-	poller       *netpoll.Poller // epoll or kqueue
-	buffer       []byte          // read packet buffer whose capacity is 64KB
-	connCount    int32           // number of active connections in event-loop
-	connections  map[int]*conn   // loop connections fd -> conn
-	eventHandler EventHandler    // user eventHandler
+	udpDropped   uint64                // number of dropped datagrams, keep it first for the 64-bit alignment
+	idleClosed   uint64                // number of connections closed for being idle, see idleReaper
+	listeners    map[int]*listener     // listeners bound to this event-loop, fd -> listener
+	idx          int                   // loop index in the server loops list
+	svr          *server               // server in loop
+	poller       *netpoll.Poller       // epoll or kqueue
+	buffer       []byte                // read packet buffer whose capacity is 64KB
+	metrics      *loopMetrics          // latency metrics, nil if disabled
+	udpQueues    map[int]*udpSendQueue // queued datagrams of UDP sockets, fd -> queue
+	udpBatches   map[int]*udpBatch     // datagrams to send in batch of UDP sockets, fd -> batch
+	connCount    int32                 // number of active connections in event-loop
+	connections  connTable             // loop connections indexed by fd
+	eventHandler EventHandler          // user eventHandler
+	restarts     int                   // number of times the event-loop has been restarted after failing
+	backoffs     map[int]time.Duration // backoffs of the listeners failing to accept, fd -> backoff
+	origDstSocks map[origDstKey]int    // sockets replying from the original destinations of UDP datagrams
+	tags         tagTable              // traffic of the tagged connections, see Conn.SetTag
+	recycled     []*conn               // connections released in this iteration, see recycleConn
 }
 
 func (el *eventloop) addConn(delta int32) {
@@ -66,11 +76,20 @@ func (el *eventloop) loadConn() int32 {
 	return atomic.LoadInt32(&el.connCount)
 }
 
+func (el *eventloop) countDroppedDatagrams() uint64 {
+	return atomic.LoadUint64(&el.udpDropped)
+}
+
+func (el *eventloop) countIdleClosed() uint64 {
+	return atomic.LoadUint64(&el.idleClosed)
+}
+
 func (el *eventloop) closeAllConns() {
 	// Close loops and all outstanding connections
-	for _, c := range el.connections {
-		_ = el.loopCloseConn(c, nil)
-	}
+	el.connections.iterate(func(c *conn) bool {
+		_ = el.loopCloseConn(c, gerrors.ErrServerShutdown)
+		return true
+	})
 }
 
 func (el *eventloop) loopRun(lockOSThread bool) {
@@ -81,18 +100,75 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllConns()
-		el.ln.close()
+		el.closeOrigDstSockets()
+		for _, ln := range el.listeners {
+			ln.close()
+		}
 		el.svr.signalShutdown()
 	}()
 
-	err := el.poller.Polling(el.handleEvent)
+	err := el.run(func() error { return el.poller.Polling(el.handleEvent) })
 	el.svr.logger.Infof("Event-loop(%d) is exiting due to error: %v", el.idx, err)
 }
 
+// maxLoopRestarts is how many times an event-loop is restarted after its poller failing before giving up on it,
+// which shuts down the server.
+const maxLoopRestarts = 8
+
+// run runs polling until the event-loop is shut down, the poller failing unexpectedly is reset and the event-loop
+// resumes serving its listeners and connections rather than taking them all down along with the server.
+func (el *eventloop) run(polling func() error) (err error) {
+	for {
+		err = polling()
+		if err == gerrors.ErrServerShutdown || err == gerrors.ErrAcceptSocket || el.restarts >= maxLoopRestarts {
+			return
+		}
+		el.restarts++
+		el.svr.logger.Errorf("Event-loop(%d) failed with error: %v, restarting it (%d/%d)",
+			el.idx, err, el.restarts, maxLoopRestarts)
+		if rerr := el.restart(); rerr != nil {
+			el.svr.logger.Errorf("Failed to restart event-loop(%d): %v", el.idx, rerr)
+			return
+		}
+	}
+}
+
+// restart resets the poller of the event-loop and registers the listeners and the connections to it again,
+// the connections that fail to be registered are closed.
+func (el *eventloop) restart() error {
+	if err := el.poller.Reset(); err != nil {
+		return err
+	}
+	for fd, ln := range el.listeners {
+		if ln.network != "udp" && el.svr.isAcceptPaused() {
+			continue
+		}
+		if err := el.poller.AddRead(fd); err != nil {
+			return err
+		}
+		if q := el.udpQueues[fd]; q != nil && len(q.packets) > 0 {
+			if err := el.poller.ModReadWrite(fd); err != nil {
+				return err
+			}
+		}
+	}
+	el.connections.iterate(func(c *conn) bool {
+		if err := c.rewatch(); err != nil {
+			_ = el.loopCloseConn(c, err)
+		}
+		return true
+	})
+	return nil
+}
+
 func (el *eventloop) loopAccept(fd int) error {
-	if fd == el.ln.fd {
-		if el.ln.network == "udp" {
-			return el.loopReadUDP(fd)
+	if ln, ok := el.listeners[fd]; ok {
+		if ln.network == "udp" {
+			return el.loopReadUDP(fd, ln)
+		}
+		// The listener is still in the poller if accepting has just been paused.
+		if el.svr.isAcceptPaused() {
+			return el.poller.ModNone(fd)
 		}
 
 		nfd, sa, err := unix.Accept(fd)
@@ -100,16 +176,37 @@ func (el *eventloop) loopAccept(fd int) error {
 			if err == unix.EAGAIN {
 				return nil
 			}
+			if err == unix.EBADF {
+				delete(el.listeners, fd)
+				return os.NewSyscallError("accept", err)
+			}
+			if isTransientAcceptError(err) {
+				return el.handleAcceptError(fd, err)
+			}
+			if el.rebindListener(fd, err) {
+				return nil
+			}
 			return os.NewSyscallError("accept", err)
 		}
+		delete(el.backoffs, fd)
+		if !el.svr.admitConn() {
+			_ = unix.Close(nfd)
+			return nil
+		}
 		if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
 			return err
 		}
 
 		netAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-		c := newTCPConn(nfd, el, sa, netAddr)
+		if err = ln.controlConn(nfd, netAddr); err != nil {
+			_ = unix.Close(nfd)
+			el.svr.logger.Warnf("SocketControl failed on the connection from %v: %v", netAddr, err)
+			return nil
+		}
+		c := newTCPConn(nfd, el, sa, ln.connLocalAddr(nfd), netAddr)
+		c.route(ln)
 		if err = el.poller.AddRead(c.fd); err == nil {
-			el.connections[c.fd] = c
+			el.connections.set(c.fd, c)
 			return el.loopOpen(c)
 		}
 		return err
@@ -121,8 +218,23 @@ func (el *eventloop) loopAccept(fd int) error {
 func (el *eventloop) loopOpen(c *conn) error {
 	c.opened = true
 	el.addConn(1)
+	if el.svr.opts.AccessLog != nil {
+		c.openedAt = time.Now()
+	}
+	c.pcap = el.svr.capture(c)
+
+	if c.sniffing() {
+		return nil // OnOpened is deferred until the protocol is known.
+	}
+	return el.fireOpened(c)
+}
 
-	out, action := el.eventHandler.OnOpened(c)
+// fireOpened fires the OnOpened event of the connection.
+func (el *eventloop) fireOpened(c *conn) error {
+	out, action := c.eventHandler.OnOpened(c)
+	if !c.opened {
+		return nil // the connection has been detached.
+	}
 	if out != nil {
 		c.open(out)
 	}
@@ -135,23 +247,84 @@ func (el *eventloop) loopOpen(c *conn) error {
 }
 
 func (el *eventloop) loopRead(c *conn) error {
-	n, err := unix.Read(c.fd, el.buffer)
+	buf := el.buffer
+	if c.readBufferCap > 0 {
+		buf = buf[:c.readBufferCap]
+	}
+	if c.priority == PriorityLow && len(buf) > el.svr.opts.LowPriorityReadBufferCap {
+		buf = buf[:el.svr.opts.LowPriorityReadBufferCap]
+	}
+	n, err := unix.Read(c.fd, buf)
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
-		return el.loopCloseConn(c, os.NewSyscallError("read", err))
+		if err == nil {
+			return el.loopReadClosed(c)
+		}
+		return el.loopCloseConn(c, readCloseReason(err))
+	}
+	c.countIn(el.buffer[:n])
+	if c.closeAfterFlush {
+		return nil
+	}
+	if c.faults != nil {
+		return el.injectInbound(c, el.buffer[:n])
+	}
+	return el.loopInbound(c, el.buffer[:n])
+}
+
+// loopInbound buffers the data read from the connection and hands it over to the event handler.
+func (el *eventloop) loopInbound(c *conn, data []byte) (err error) {
+	_, _ = c.inboundBuffer.Write(data)
+
+	if c.sniffing() {
+		if !c.sniff() {
+			return nil
+		}
+		if err = el.fireOpened(c); err != nil || !c.opened {
+			return err
+		}
+	}
+
+	if !el.svr.loadLiveOptions().CoalesceWrites {
+		return el.loopReact(c)
+	}
+	pending := !c.outboundBuffer.IsEmpty()
+	c.coalescing = true
+	err = el.loopReact(c)
+	c.coalescing = false
+	if err != nil || pending || !c.opened {
+		return err
+	}
+	return el.loopFlush(c)
+}
+
+// loopReact hands the inbound data over to the event handler.
+func (el *eventloop) loopReact(c *conn) (err error) {
+	if pr, ok := c.eventHandler.(PartialReactor); ok {
+		return el.loopReactN(c, pr)
 	}
-	c.buffer = el.buffer[:n]
 
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
-		out, action := el.eventHandler.React(inFrame, c)
+	budget := el.svr.opts.MaxFramesPerIteration
+	var inFrame []byte
+	for inFrame, err = el.decode(c); inFrame != nil; inFrame, err = el.decode(c) {
+		start := el.metrics.now()
+		out, action := c.eventHandler.React(inFrame, c)
+		el.metrics.observeReact(c, start)
+		c.frames++
+		if !c.opened {
+			return nil // the connection has been detached.
+		}
 		if out != nil {
-			el.eventHandler.PreWrite()
+			c.eventHandler.PreWrite()
 			// Encode data and try to write it back to the client, this attempt is based on a fact:
 			// a client socket waits for the response data after sending request data to the server,
 			// which makes the client socket writable.
-			if err = c.write(out); err != nil {
+			start = el.metrics.now()
+			err = c.write(out)
+			el.metrics.observeWrite(start)
+			if err != nil {
 				return err
 			}
 		}
@@ -159,6 +332,8 @@ func (el *eventloop) loopRead(c *conn) error {
 		case None:
 		case Close:
 			return el.loopCloseConn(c, nil)
+		case CloseAfterFlush:
+			return el.loopCloseAfterFlush(c)
 		case Shutdown:
 			return gerrors.ErrServerShutdown
 		}
@@ -168,45 +343,257 @@ func (el *eventloop) loopRead(c *conn) error {
 		if !c.opened {
 			return nil
 		}
+
+		if budget--; budget == 0 && c.BufferLength() > 0 {
+			return el.requeueReact(c)
+		}
+	}
+
+	return err
+}
+
+// decode decodes the next inbound frame of c, the malformed input reported by the codec with a *CodecError is
+// handled by Options.CodecErrorPolicy and c is closed if the policy says so.
+func (el *eventloop) decode(c *conn) ([]byte, error) {
+	for c.opened {
+		frame, err := c.read()
+		cerr, ok := err.(*CodecError)
+		if frame != nil || !ok {
+			return frame, nil
+		}
+		out, raw, closing := applyCodecError(el.svr.opts, c, cerr)
+		if out != nil {
+			c.eventHandler.PreWrite()
+			if err = c.write(out); err != nil {
+				return nil, err
+			}
+		}
+		if closing {
+			return nil, el.loopCloseConn(c, cerr)
+		}
+		if raw != nil {
+			return raw, nil
+		}
+	}
+	return nil, nil
+}
+
+func (el *eventloop) loopReactN(c *conn, pr PartialReactor) (err error) {
+	budget := el.svr.opts.MaxFramesPerIteration
+	for c.BufferLength() > 0 {
+		start := el.metrics.now()
+		n, out, action := pr.ReactN(c.Read(), c)
+		el.metrics.observeReact(c, start)
+		if !c.opened {
+			return nil // the connection has been detached.
+		}
+		if n > 0 {
+			c.ShiftN(n)
+			c.frames++
+		}
+		if out != nil {
+			c.eventHandler.PreWrite()
+			start = el.metrics.now()
+			err = c.write(out)
+			el.metrics.observeWrite(start)
+			if err != nil {
+				return err
+			}
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopCloseConn(c, nil)
+		case CloseAfterFlush:
+			return el.loopCloseAfterFlush(c)
+		case Shutdown:
+			return gerrors.ErrServerShutdown
+		}
+		if !c.opened || n <= 0 {
+			break
+		}
+		if budget--; budget == 0 && c.BufferLength() > 0 {
+			return el.requeueReact(c)
+		}
 	}
-	_, _ = c.inboundBuffer.Write(c.buffer)
 
 	return nil
 }
 
+// requeueReact hands the rest of the inbound data of the connection over to the event handler in a later iteration,
+// after the events of the other connections fetched in this iteration are processed.
+func (el *eventloop) requeueReact(c *conn) error {
+	return el.poller.Trigger(c.current(func() error { return el.loopReact(c) }))
+}
+
 func (el *eventloop) loopWrite(c *conn) error {
-	el.eventHandler.PreWrite()
+	c.eventHandler.PreWrite()
+
+	defer el.metrics.observeWrite(el.metrics.now())
 
 	head, tail := c.outboundBuffer.LazyReadAll()
-	n, err := unix.Write(c.fd, head)
+	// Write no more than a slice of the outbound data on every writable event when it's limited,
+	// the rest is flushed on the next rounds after the other writable connections are served.
+	if limit := el.svr.opts.MaxWriteBytesPerIteration; limit > 0 {
+		if len(head) >= limit {
+			head, tail = head[:limit], nil
+		} else if len(head)+len(tail) > limit {
+			tail = tail[:limit-len(head)]
+		}
+	}
+	n, err := c.writeOut(head)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
-		return el.loopCloseConn(c, os.NewSyscallError("write", err))
+		return el.loopCloseConn(c, writeCloseReason(err))
 	}
-	c.outboundBuffer.Shift(n)
+	c.shiftOutbound(n)
 
 	if n == len(head) && tail != nil {
-		n, err = unix.Write(c.fd, tail)
+		n, err = c.writeOut(tail)
 		if err != nil {
 			if err == unix.EAGAIN {
 				return nil
 			}
-			return el.loopCloseConn(c, os.NewSyscallError("write", err))
+			return el.loopCloseConn(c, writeCloseReason(err))
 		}
-		c.outboundBuffer.Shift(n)
+		c.shiftOutbound(n)
 	}
 
 	// All data have been drained, it's no need to monitor the writable events,
 	// remove the writable event from poller to help the future event-loops.
 	if c.outboundBuffer.IsEmpty() {
-		_ = el.poller.ModRead(c.fd)
+		if err = el.loopDrained(c); err != nil || !c.opened {
+			return err
+		}
+		_ = c.unwatchWrite()
+	}
+
+	return nil
+}
+
+// loopFlush writes the outbound data coalesced while reacting to the inbound data in one go.
+func (el *eventloop) loopFlush(c *conn) error {
+	if c.outboundBuffer.IsEmpty() {
+		return nil
+	}
+
+	start := el.metrics.now()
+	head, tail := c.outboundBuffer.LazyReadAll()
+	n, err := c.writeOut(head)
+	if err == nil {
+		c.shiftOutbound(n)
+		if n == len(head) && tail != nil {
+			if n, err = c.writeOut(tail); err == nil {
+				c.shiftOutbound(n)
+			}
+		}
+	}
+	el.metrics.observeWrite(start)
+	if err != nil && err != unix.EAGAIN {
+		return el.loopCloseConn(c, writeCloseReason(err))
 	}
 
+	// Fail to send all data back to client, write the leftover data when the connection becomes writable.
+	if !c.outboundBuffer.IsEmpty() {
+		return c.watchWrite()
+	}
+	return el.loopDrained(c)
+}
+
+// loopDrained runs the operations on the connection that are deferred until its outbound buffer is drained.
+func (el *eventloop) loopDrained(c *conn) error {
+	switch {
+	case c.closeAfterFlush:
+		return el.loopCloseConn(c, nil)
+	case c.closeWrite:
+		return el.loopCloseWrite(c)
+	}
 	return nil
 }
 
+// loopCloseWrite shuts down the writing side of the connection, or closes it if the reading side has been shut
+// down as well.
+func (el *eventloop) loopCloseWrite(c *conn) error {
+	c.closeWrite = false
+	if c.readClosed {
+		return el.loopCloseConn(c, nil)
+	}
+	c.writeClosed = true
+	return os.NewSyscallError("shutdown", unix.Shutdown(c.fd, unix.SHUT_WR))
+}
+
+// loopStopReading stops the poller watching the readable events of the connection whose reading side has been
+// shut down, or closes it if the writing side has been shut down as well.
+func (el *eventloop) loopStopReading(c *conn) error {
+	c.readClosed = true
+	if c.writeClosed {
+		return el.loopCloseConn(c, nil)
+	}
+	if c.outboundBuffer.IsEmpty() {
+		return el.poller.ModNone(c.fd)
+	}
+	return el.poller.ModWrite(c.fd)
+}
+
+// loopReadClosed handles the half-close of the peer, the connection is closed unless the event handler
+// implements HalfCloseHandler.
+func (el *eventloop) loopReadClosed(c *conn) error {
+	hc, ok := c.eventHandler.(HalfCloseHandler)
+	if !ok || c.sniffing() || c.readClosed {
+		return el.loopCloseConn(c, gerrors.ErrPeerClosed)
+	}
+	if err := el.loopStopReading(c); err != nil || !c.opened {
+		return err
+	}
+	out, action := hc.OnReadClosed(c)
+	if out != nil {
+		if err := c.write(out); err != nil || !c.opened {
+			return err
+		}
+	}
+	return el.handleAction(c, action)
+}
+
+// loopCloseAfterFlush closes the connection right away if there is no pending outbound data,
+// otherwise it's closed by loopWrite once the outbound buffer is drained.
+func (el *eventloop) loopCloseAfterFlush(c *conn) error {
+	if c.outboundBuffer.IsEmpty() {
+		return el.loopCloseConn(c, nil)
+	}
+	c.closeAfterFlush = true
+	return nil
+}
+
+// readCloseReason translates the error of reading from a connection into the reason of closing it,
+// a nil error means that the peer has sent EOF.
+func readCloseReason(err error) error {
+	switch err {
+	case nil:
+		return gerrors.ErrPeerClosed
+	case unix.ECONNRESET:
+		return gerrors.ErrPeerReset
+	case unix.ETIMEDOUT, unix.EHOSTUNREACH, unix.ENETUNREACH:
+		return gerrors.ErrPeerUnreachable
+	default:
+		return os.NewSyscallError("read", err)
+	}
+}
+
+// writeCloseReason translates the error of writing to a connection into the reason of closing it,
+// the peer has aborted the connection if it is EPIPE or ECONNRESET.
+func writeCloseReason(err error) error {
+	switch err {
+	case unix.EPIPE, unix.ECONNRESET:
+		return gerrors.ErrPeerReset
+	case unix.ETIMEDOUT, unix.EHOSTUNREACH, unix.ENETUNREACH:
+		return gerrors.ErrPeerUnreachable
+	default:
+		return gerrors.ErrWriteFailed
+	}
+}
+
 func (el *eventloop) loopCloseConn(c *conn, err error) (rerr error) {
 	if !c.opened {
 		return nil
@@ -214,24 +601,40 @@ func (el *eventloop) loopCloseConn(c *conn, err error) (rerr error) {
 
 	// Send residual data in buffer back to client before actually closing the connection.
 	if !c.outboundBuffer.IsEmpty() {
-		el.eventHandler.PreWrite()
+		c.eventHandler.PreWrite()
 
 		head, tail := c.outboundBuffer.LazyReadAll()
-		if n, err := unix.Write(c.fd, head); err == nil {
+		if n, err := c.writeOut(head); err == nil {
+			c.shiftOutbound(n)
 			if n == len(head) && tail != nil {
-				_, _ = unix.Write(c.fd, tail)
+				if n, err = c.writeOut(tail); err == nil {
+					c.shiftOutbound(n)
+				}
 			}
 		}
 	}
+	c.failFlushWaiters(gerrors.ErrConnectionClosed)
 
-	if err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd); err0 == nil && err1 == nil {
-		delete(el.connections, c.fd)
+	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
+	// The fd closed behind our back can be neither deleted nor closed, which must not keep the connection around.
+	if stale := err1 == unix.EBADF && (err0 == nil || errors.Is(err0, unix.EBADF)); stale || err0 == nil && err1 == nil {
+		if stale {
+			el.svr.logger.Warnf("Dropping connection of stale fd=%d in event-loop(%d)", c.fd, el.idx)
+		}
+		el.connections.del(c.fd)
 		el.addConn(-1)
 
-		if el.eventHandler.OnClosed(c, err) == Shutdown {
+		if c.pcap != nil {
+			c.pcap.close()
+		}
+		if accessLog := el.svr.opts.AccessLog; accessLog != nil {
+			accessLog(c.accessLogEntry(err))
+		}
+		if !c.sniffing() && c.eventHandler.OnClosed(c, err) == Shutdown {
 			return gerrors.ErrServerShutdown
 		}
 		c.releaseTCP()
+		el.recycleConn(c)
 	} else {
 		if err0 != nil {
 			rerr = fmt.Errorf("failed to delete fd=%d from poller in event-loop(%d): %v", c.fd, el.idx, err0)
@@ -250,11 +653,16 @@ func (el *eventloop) loopCloseConn(c *conn, err error) (rerr error) {
 }
 
 func (el *eventloop) loopWake(c *conn) error {
-	if co, ok := el.connections[c.fd]; !ok || co != c {
+	if el.connections.get(c.fd) != c {
 		return nil // ignore stale wakes.
 	}
 
-	out, action := el.eventHandler.React(nil, c)
+	start := el.metrics.now()
+	out, action := c.eventHandler.React(nil, c)
+	el.metrics.observeReact(c, start)
+	if !c.opened {
+		return nil // the connection has been detached.
+	}
 	if out != nil {
 		if err := c.write(out); err != nil {
 			return err
@@ -264,41 +672,14 @@ func (el *eventloop) loopWake(c *conn) error {
 	return el.handleAction(c, action)
 }
 
-func (el *eventloop) loopTicker() {
-	var (
-		delay time.Duration
-		open  bool
-		err   error
-	)
-	for {
-		err = el.poller.Trigger(func() (err error) {
-			delay, action := el.eventHandler.Tick()
-			el.svr.ticktock <- delay
-			switch action {
-			case None:
-			case Shutdown:
-				err = gerrors.ErrServerShutdown
-			}
-			return
-		})
-		if err != nil {
-			el.svr.logger.Errorf("Failed to awake poller in event-loop(%d), error:%v, stopping ticker", el.idx, err)
-			break
-		}
-		if delay, open = <-el.svr.ticktock; open {
-			time.Sleep(delay)
-		} else {
-			break
-		}
-	}
-}
-
 func (el *eventloop) handleAction(c *conn, action Action) error {
 	switch action {
 	case None:
 		return nil
 	case Close:
 		return el.loopCloseConn(c, nil)
+	case CloseAfterFlush:
+		return el.loopCloseAfterFlush(c)
 	case Shutdown:
 		return gerrors.ErrServerShutdown
 	default:
@@ -306,26 +687,242 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 	}
 }
 
-func (el *eventloop) loopReadUDP(fd int) error {
-	n, sa, err := unix.Recvfrom(fd, el.buffer, 0)
-	if err != nil {
-		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
-			return nil
+func (el *eventloop) loopReadUDP(fd int, ln *listener) error {
+	defer el.flushUDPBatch(fd)
+
+	var oob []byte
+	if ln.transparent {
+		oob = make([]byte, 128)
+	}
+	for i := 0; i < udpReadBatch; i++ {
+		var (
+			n       int
+			sa, dst unix.Sockaddr
+			err     error
+		)
+		if ln.transparent {
+			n, sa, dst, err = socket.RecvfromOrigDst(fd, el.buffer, oob)
+		} else {
+			n, sa, err = unix.Recvfrom(fd, el.buffer, 0)
+		}
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				return nil
+			}
+			return fmt.Errorf("failed to read UDP packet from fd=%d in event-loop(%d), %v",
+				fd, el.idx, os.NewSyscallError("recvfrom", err))
+		}
+
+		c := newUDPConn(fd, el, ln.lnaddr, sa)
+		if dst != nil {
+			c.localAddr, c.origDst = socket.SockaddrToUDPAddr(dst), dst
+		}
+		c.route(ln)
+		start := el.metrics.now()
+		out, action := c.eventHandler.React(el.buffer[:n], c)
+		el.metrics.observeReact(c, start)
+		if out != nil {
+			c.eventHandler.PreWrite()
+			_ = c.sendTo(out)
+		}
+		if action == Shutdown {
+			return gerrors.ErrServerShutdown
 		}
-		return fmt.Errorf("failed to read UDP packet from fd=%d in event-loop(%d), %v",
-			fd, el.idx, os.NewSyscallError("recvfrom", err))
+		c.releaseUDP()
 	}
 
-	c := newUDPConn(fd, el, sa)
-	out, action := el.eventHandler.React(el.buffer[:n], c)
-	if out != nil {
-		el.eventHandler.PreWrite()
-		_ = c.sendTo(out)
+	return nil
+}
+
+// maxOrigDstSockets is the maximum number of sockets cached by an event-loop for replying from
+// the original destinations of datagrams.
+const maxOrigDstSockets = 256
+
+// sendFromOrigDst sends buf to sa from the original destination dst of the datagram sa has sent, through
+// a transparent socket bound to dst, which is cached for the following replies. Unlike sendToUDP, the datagram
+// is dropped rather than queued if the socket send buffer is full. It must be called on the event-loop.
+func (el *eventloop) sendFromOrigDst(dst, sa unix.Sockaddr, buf []byte) error {
+	_, v6 := sa.(*unix.SockaddrInet6)
+	key := origDstKey{addr: socket.SockaddrToUDPAddr(dst).String(), v6: v6}
+	fd, ok := el.origDstSocks[key]
+	if !ok {
+		var err error
+		if fd, err = socket.TransparentUDPSocket(dst, sa); err != nil {
+			return err
+		}
+		if el.origDstSocks == nil {
+			el.origDstSocks = make(map[origDstKey]int)
+		}
+		if len(el.origDstSocks) >= maxOrigDstSockets {
+			for k, sfd := range el.origDstSocks {
+				_ = unix.Close(sfd)
+				delete(el.origDstSocks, k)
+				break
+			}
+		}
+		el.origDstSocks[key] = fd
 	}
-	if action == Shutdown {
-		return gerrors.ErrServerShutdown
+	return os.NewSyscallError("sendto", unix.Sendto(fd, buf, 0, sa))
+}
+
+// origDstKey identifies a socket replying from an original destination to the peers of a family.
+type origDstKey struct {
+	addr string
+	v6   bool
+}
+
+func (el *eventloop) closeOrigDstSockets() {
+	for k, fd := range el.origDstSocks {
+		_ = unix.Close(fd)
+		delete(el.origDstSocks, k)
+	}
+}
+
+// defaultUDPSendQueueSize is the default maximum number of queued datagrams per UDP socket.
+const defaultUDPSendQueueSize = 1024
+
+type udpPacket struct {
+	buf []byte
+	sa  unix.Sockaddr
+}
+
+// udpSendQueue holds the datagrams that can't be sent right away due to a full socket send buffer.
+type udpSendQueue struct {
+	packets []udpPacket
+}
+
+// sendToUDP sends buf to sa, it queues buf up if the socket send buffer is full or there are datagrams queued
+// before it, the queue is flushed by loopWriteUDP when the socket becomes writable. It must be called on
+// the event-loop, Conn.SendTo hands the datagrams over to it when they can't be sent right away.
+func (el *eventloop) sendToUDP(fd int, sa unix.Sockaddr, buf []byte) error {
+	q := el.udpQueues[fd]
+	if q == nil || len(q.packets) == 0 {
+		err := unix.Sendto(fd, buf, 0, sa)
+		if err != unix.EAGAIN {
+			return err
+		}
+		if q == nil {
+			if el.udpQueues == nil {
+				el.udpQueues = make(map[int]*udpSendQueue)
+			}
+			q = new(udpSendQueue)
+			el.udpQueues[fd] = q
+		}
+		if err = el.poller.ModReadWrite(fd); err != nil {
+			return err
+		}
 	}
-	c.releaseUDP()
 
+	opts := el.svr.loadLiveOptions()
+	size := opts.UDPSendQueueSize
+	if size <= 0 {
+		size = defaultUDPSendQueueSize
+	}
+	if len(q.packets) >= size {
+		atomic.AddUint64(&el.udpDropped, 1)
+		if opts.UDPDropPolicy != DropOldest {
+			return nil
+		}
+		q.packets[0] = udpPacket{}
+		q.packets = q.packets[1:]
+	}
+	q.packets = append(q.packets, udpPacket{append([]byte(nil), buf...), sa})
 	return nil
 }
+
+// loopWriteUDP flushes the queued datagrams of the UDP socket.
+func (el *eventloop) loopWriteUDP(fd int) error {
+	q := el.udpQueues[fd]
+	if q == nil {
+		return nil
+	}
+	for len(q.packets) > 0 {
+		p := q.packets[0]
+		if err := unix.Sendto(fd, p.buf, 0, p.sa); err == unix.EAGAIN {
+			return nil
+		} else if err != nil {
+			atomic.AddUint64(&el.udpDropped, 1)
+		}
+		q.packets[0] = udpPacket{}
+		q.packets = q.packets[1:]
+	}
+	q.packets = nil
+	return el.poller.ModRead(fd)
+}
+
+// udpReadBatch is the maximum number of datagrams read from a UDP socket on a readable event,
+// the replies queued by Conn.QueueTo for them are sent in one batch.
+const udpReadBatch = 32
+
+type udpBatch struct {
+	bufs [][]byte
+	sas  []unix.Sockaddr
+}
+
+func (el *eventloop) queueToUDP(fd int, sa unix.Sockaddr, buf []byte) {
+	b := el.udpBatches[fd]
+	if b == nil {
+		if el.udpBatches == nil {
+			el.udpBatches = make(map[int]*udpBatch)
+		}
+		b = new(udpBatch)
+		el.udpBatches[fd] = b
+	}
+	b.bufs = append(b.bufs, append([]byte(nil), buf...))
+	b.sas = append(b.sas, sa)
+}
+
+// flushUDPBatch sends the batch of datagrams queued by Conn.QueueTo, the ones that can't be sent
+// right away fall back to the send queue of the socket.
+func (el *eventloop) flushUDPBatch(fd int) {
+	b := el.udpBatches[fd]
+	if b == nil || len(b.bufs) == 0 {
+		return
+	}
+
+	bufs, sas := b.bufs, b.sas
+	for len(bufs) > 0 {
+		if q := el.udpQueues[fd]; q != nil && len(q.packets) > 0 {
+			break // keep the datagrams in order behind the queued ones.
+		}
+		n, err := socket.SendMsgs(fd, bufs, sas)
+		if err != nil || n == 0 {
+			break
+		}
+		bufs, sas = bufs[n:], sas[n:]
+	}
+	for i := range bufs {
+		_ = el.sendToUDP(fd, sas[i], bufs[i])
+	}
+
+	for i := range b.bufs {
+		b.bufs[i], b.sas[i] = nil, nil
+	}
+	b.bufs, b.sas = b.bufs[:0], b.sas[:0]
+}
+
+// execute runs f on the event-loop asynchronously.
+func (el *eventloop) execute(f func() error) error {
+	return el.poller.Trigger(f)
+}
+
+// connInfos returns the information of the connections on the event-loop, it must be called on the event-loop.
+func (el *eventloop) connInfos() []adminConnInfo {
+	infos := make([]adminConnInfo, 0, el.connections.len())
+	el.connections.iterate(func(c *conn) bool {
+		infos = append(infos, adminConnInfo{Loop: el.idx, Local: c.localAddr.String(), Remote: c.remoteAddr.String()})
+		return true
+	})
+	return infos
+}
+
+// memoryStats sums up the buffers of the connections on the event-loop, it must be called on the event-loop.
+func (el *eventloop) memoryStats() LoopMemoryStats {
+	st := LoopMemoryStats{Loop: el.idx, Connections: el.connections.len()}
+	el.connections.iterate(func(c *conn) bool {
+		st.Inbound += uint64(c.inboundBuffer.Cap())
+		st.Outbound += uint64(c.outboundBuffer.Cap())
+		return true
+	})
+	return st
+}
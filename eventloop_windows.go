@@ -47,6 +47,8 @@ type internalEventloop struct {
 	svr          *server               // server in loop
 	connCount    int32                 // number of active connections in event-loop
 	connections  map[*stdConn]struct{} // track all the sockets bound to this loop
+	metrics      *loopMetrics          // latency metrics, nil if disabled
+	tags         tagTable              // traffic of the tagged connections, see Conn.SetTag
 	eventHandler EventHandler          // user eventHandler
 }
 
@@ -58,6 +60,14 @@ func (el *eventloop) loadConn() int32 {
 	return atomic.LoadInt32(&el.connCount)
 }
 
+func (el *eventloop) countDroppedDatagrams() uint64 {
+	return 0 // datagrams are written in blocking mode on Windows.
+}
+
+func (el *eventloop) countIdleClosed() uint64 {
+	return 0 // IdleTimeout is not supported on Windows.
+}
+
 func (el *eventloop) loopRun(lockOSThread bool) {
 	if lockOSThread {
 		runtime.LockOSThread()
@@ -79,7 +89,13 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 		case *stdConn:
 			err = el.loopAccept(v)
 		case *tcpConn:
-			v.c.buffer = v.bb
+			v.c.bytesIn += uint64(v.bb.Len())
+			countTagged(v.c.tags, v.bb.Len(), 0)
+			if v.c.pcap != nil {
+				v.c.pcap.inbound(v.bb.Bytes())
+			}
+			_, _ = v.c.inboundBuffer.Write(v.bb.Bytes())
+			bytebuffer.Put(v.bb)
 			err = el.loopRead(v.c)
 		case *udpConn:
 			err = el.loopReadUDP(v.c)
@@ -102,37 +118,126 @@ func (el *eventloop) loopRun(lockOSThread bool) {
 func (el *eventloop) loopAccept(c *stdConn) error {
 	el.connections[c] = struct{}{}
 	el.addConn(1)
+	if el.svr.opts.AccessLog != nil {
+		c.openedAt = time.Now()
+	}
+	c.pcap = el.svr.capture(c)
+
+	if c.sniffing() {
+		return nil // OnOpened is deferred until the protocol is known.
+	}
+	return el.fireOpened(c)
+}
 
-	out, action := el.eventHandler.OnOpened(c)
+// fireOpened fires the OnOpened event of the connection.
+func (el *eventloop) fireOpened(c *stdConn) error {
+	out, action := c.eventHandler.OnOpened(c)
 	if out != nil {
-		el.eventHandler.PreWrite()
-		_, _ = c.conn.Write(out)
+		c.eventHandler.PreWrite()
+		_, _ = c.writeConn(out)
 	}
 
 	return el.handleAction(c, action)
 }
 
 func (el *eventloop) loopRead(c *stdConn) error {
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
-		out, action := el.eventHandler.React(inFrame, c)
+	if c.sniffing() {
+		if !c.sniff() {
+			return nil
+		}
+		if err := el.fireOpened(c); err != nil {
+			return err
+		}
+	}
+
+	if pr, ok := c.eventHandler.(PartialReactor); ok {
+		return el.loopReactN(c, pr)
+	}
+
+	inFrame, err := el.decode(c)
+	for ; inFrame != nil; inFrame, err = el.decode(c) {
+		start := el.metrics.now()
+		out, action := c.eventHandler.React(inFrame, c)
+		el.metrics.observeReact(c, start)
+		c.frames++
+		if out != nil {
+			outFrame, _ := c.codec.Encode(c, out)
+			c.eventHandler.PreWrite()
+			start = el.metrics.now()
+			_, err := c.writeConn(outFrame)
+			el.metrics.observeWrite(start)
+			if err != nil {
+				return el.loopError(c, writeCloseReason(err))
+			}
+		}
+		switch action {
+		case None:
+		case Close, CloseAfterFlush:
+			return el.loopCloseConn(c)
+		case Shutdown:
+			return errors.ErrServerShutdown
+		}
+	}
+
+	return err
+}
+
+// decode decodes the next inbound frame of c, the malformed input reported by the codec with a *CodecError is
+// handled by Options.CodecErrorPolicy and c is closed if the policy says so.
+func (el *eventloop) decode(c *stdConn) ([]byte, error) {
+	for {
+		frame, err := c.read()
+		cerr, ok := err.(*CodecError)
+		if frame != nil || !ok {
+			return frame, nil
+		}
+		out, raw, closing := applyCodecError(el.svr.opts, c, cerr)
+		if out != nil {
+			outFrame, _ := c.codec.Encode(c, out)
+			c.eventHandler.PreWrite()
+			if _, err = c.writeConn(outFrame); err != nil {
+				return nil, el.loopError(c, writeCloseReason(err))
+			}
+		}
+		if closing {
+			return nil, el.loopError(c, cerr)
+		}
+		if raw != nil {
+			return raw, nil
+		}
+	}
+}
+
+func (el *eventloop) loopReactN(c *stdConn, pr PartialReactor) error {
+	for c.BufferLength() > 0 {
+		start := el.metrics.now()
+		n, out, action := pr.ReactN(c.Read(), c)
+		el.metrics.observeReact(c, start)
+		if n > 0 {
+			c.ShiftN(n)
+			c.frames++
+		}
 		if out != nil {
 			outFrame, _ := c.codec.Encode(c, out)
-			el.eventHandler.PreWrite()
-			if _, err := c.conn.Write(outFrame); err != nil {
-				return el.loopError(c, err)
+			c.eventHandler.PreWrite()
+			start = el.metrics.now()
+			_, err := c.writeConn(outFrame)
+			el.metrics.observeWrite(start)
+			if err != nil {
+				return el.loopError(c, writeCloseReason(err))
 			}
 		}
 		switch action {
 		case None:
-		case Close:
+		case Close, CloseAfterFlush:
 			return el.loopCloseConn(c)
 		case Shutdown:
 			return errors.ErrServerShutdown
 		}
+		if n <= 0 {
+			break
+		}
 	}
-	_, _ = c.inboundBuffer.Write(c.buffer.Bytes())
-	bytebuffer.Put(c.buffer)
-	c.buffer = nil
 
 	return nil
 }
@@ -166,6 +271,7 @@ func (el *eventloop) loopEgress() {
 
 func (el *eventloop) loopTicker() {
 	var (
+		sched = newTickSchedule(el.svr.opts)
 		delay time.Duration
 		open  bool
 	)
@@ -179,7 +285,31 @@ func (el *eventloop) loopTicker() {
 			return
 		}
 		if delay, open = <-el.svr.ticktock; open {
-			time.Sleep(delay)
+			time.Sleep(sched.next(delay))
+		} else {
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopNamedTicker(idx int, t NamedTicker, h NamedTickHandler) {
+	var (
+		sched    = newTickSchedule(el.svr.opts)
+		ticktock = el.svr.tickers[idx]
+		delay    time.Duration
+		open     bool
+	)
+	for {
+		el.ch <- func() (err error) {
+			delay, action := h.NamedTick(t.ID)
+			ticktock <- intervalOf(t, delay)
+			if action == Shutdown {
+				err = errors.ErrServerShutdown
+			}
+			return
+		}
+		if delay, open = <-ticktock; open {
+			time.Sleep(sched.next(delay))
 		} else {
 			break
 		}
@@ -204,7 +334,13 @@ func (el *eventloop) loopError(c *stdConn, err error) (e error) {
 		c.releaseTCP()
 	}()
 
-	if el.eventHandler.OnClosed(c, err) == Shutdown {
+	if c.pcap != nil {
+		c.pcap.close()
+	}
+	if accessLog := el.svr.opts.AccessLog; accessLog != nil {
+		accessLog(c.accessLogEntry(err))
+	}
+	if !c.sniffing() && c.eventHandler.OnClosed(c, err) == Shutdown {
 		return errors.ErrServerShutdown
 	}
 
@@ -216,11 +352,13 @@ func (el *eventloop) loopWake(c *stdConn) error {
 		return nil // ignore stale wakes.
 	}
 
-	out, action := el.eventHandler.React(nil, c)
+	start := el.metrics.now()
+	out, action := c.eventHandler.React(nil, c)
+	el.metrics.observeReact(c, start)
 	if out != nil {
 		if frame, err := c.codec.Encode(c, out); err != nil {
 			return err
-		} else if _, err = c.conn.Write(frame); err != nil {
+		} else if _, err = c.writeConn(frame); err != nil {
 			return err
 		}
 	}
@@ -232,7 +370,7 @@ func (el *eventloop) handleAction(c *stdConn, action Action) error {
 	switch action {
 	case None:
 		return nil
-	case Close:
+	case Close, CloseAfterFlush:
 		return el.loopCloseConn(c)
 	case Shutdown:
 		return errors.ErrServerShutdown
@@ -242,10 +380,12 @@ func (el *eventloop) handleAction(c *stdConn, action Action) error {
 }
 
 func (el *eventloop) loopReadUDP(c *stdConn) error {
-	out, action := el.eventHandler.React(c.buffer.Bytes(), c)
+	start := el.metrics.now()
+	out, action := c.eventHandler.React(c.buffer.Bytes(), c)
+	el.metrics.observeReact(c, start)
 	if out != nil {
-		el.eventHandler.PreWrite()
-		_, _ = el.svr.ln.pconn.WriteTo(out, c.remoteAddr)
+		c.eventHandler.PreWrite()
+		_, _ = c.pconn.WriteTo(out, c.remoteAddr)
 	}
 	if action == Shutdown {
 		return errors.ErrServerShutdown
@@ -254,3 +394,28 @@ func (el *eventloop) loopReadUDP(c *stdConn) error {
 
 	return nil
 }
+
+// execute runs f on the event-loop asynchronously.
+func (el *eventloop) execute(f func() error) error {
+	el.ch <- f
+	return nil
+}
+
+// connInfos returns the information of the connections on the event-loop, it must be called on the event-loop.
+func (el *eventloop) connInfos() []adminConnInfo {
+	infos := make([]adminConnInfo, 0, len(el.connections))
+	for c := range el.connections {
+		infos = append(infos, adminConnInfo{Loop: el.idx, Local: c.localAddr.String(), Remote: c.remoteAddr.String()})
+	}
+	return infos
+}
+
+// memoryStats sums up the buffers of the connections on the event-loop, it must be called on the event-loop.
+// The outbound data is written to the sockets directly on Windows, so there are only inbound buffers.
+func (el *eventloop) memoryStats() LoopMemoryStats {
+	st := LoopMemoryStats{Loop: el.idx, Connections: len(el.connections)}
+	for c := range el.connections {
+		st.Inbound += uint64(c.inboundBuffer.Cap())
+	}
+	return st
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"math/rand"
+	"time"
+)
+
+// faultState is the fault injection of a connection, see Options.Faults.
+type faultState struct {
+	cfg     *FaultInjection
+	rng     *rand.Rand
+	heldIn  [][]byte // inbound data held back by a delay, in order
+	heldOut [][]byte // outbound data held back by a delay, in order
+}
+
+func newFaultState(cfg *FaultInjection, fd int) *faultState {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultState{cfg: cfg, rng: rand.New(rand.NewSource(seed + int64(fd)))}
+}
+
+func (f *faultState) hit(p float64) bool {
+	return p > 0 && f.rng.Float64() < p
+}
+
+// mangle returns the chunks that data turns into, which may be dropped, truncated or duplicated.
+func (f *faultState) mangle(data []byte) [][]byte {
+	if f.hit(f.cfg.Drop) {
+		return nil
+	}
+	if len(data) > 1 && f.hit(f.cfg.Truncate) {
+		data = data[:1+f.rng.Intn(len(data)-1)]
+	}
+	if f.hit(f.cfg.Duplicate) {
+		return [][]byte{data, data}
+	}
+	return [][]byte{data}
+}
+
+// delay returns how long to hold the data back, zero if it goes through right away.
+func (f *faultState) delay() time.Duration {
+	if f.cfg.MaxDelay <= 0 || !f.hit(f.cfg.Delay) {
+		return 0
+	}
+	return 1 + time.Duration(f.rng.Int63n(int64(f.cfg.MaxDelay)))
+}
+
+// hold appends the copies of chunks to held, reporting whether held was empty.
+func hold(held *[][]byte, chunks [][]byte) bool {
+	first := len(*held) == 0
+	for _, chunk := range chunks {
+		*held = append(*held, append([]byte(nil), chunk...))
+	}
+	return first
+}
+
+// afterConn runs f with c on the event-loop after d, unless c has been closed by then.
+func (el *eventloop) afterConn(c *conn, d time.Duration, f func(c *conn) error) {
+	task := c.current(func() error {
+		if !c.opened {
+			return nil
+		}
+		return f(c)
+	})
+	time.AfterFunc(d, func() { _ = el.execute(task) })
+}
+
+// injectInbound hands data read from c over to the event handler through the fault injection, the data held
+// back delays the data read after it as well, so that the stream is never reordered.
+func (el *eventloop) injectInbound(c *conn, data []byte) (err error) {
+	f := c.faults
+	chunks := f.mangle(data)
+	if len(f.heldIn) > 0 {
+		hold(&f.heldIn, chunks)
+		return
+	}
+	if d := f.delay(); d > 0 && len(chunks) > 0 {
+		hold(&f.heldIn, chunks)
+		el.afterConn(c, d, el.releaseInbound)
+		return
+	}
+	for _, chunk := range chunks {
+		if err = el.loopInbound(c, chunk); err != nil || !c.opened {
+			return
+		}
+	}
+	return
+}
+
+func (el *eventloop) releaseInbound(c *conn) (err error) {
+	held := c.faults.heldIn
+	c.faults.heldIn = nil
+	for _, chunk := range held {
+		if err = el.loopInbound(c, chunk); err != nil || !c.opened {
+			return
+		}
+	}
+	return
+}
+
+// injectOutbound writes the encoded frame to c through the fault injection.
+func (c *conn) injectOutbound(frame []byte) (err error) {
+	f := c.faults
+	chunks := f.mangle(frame)
+	if len(f.heldOut) > 0 {
+		hold(&f.heldOut, chunks)
+		return
+	}
+	if d := f.delay(); d > 0 && len(chunks) > 0 {
+		hold(&f.heldOut, chunks)
+		c.loop.afterConn(c, d, (*conn).releaseOutbound)
+		return
+	}
+	for _, chunk := range chunks {
+		if err = c.writeFrame(chunk); err != nil || !c.opened {
+			return
+		}
+	}
+	return
+}
+
+func (c *conn) releaseOutbound() (err error) {
+	held := c.faults.heldOut
+	c.faults.heldOut = nil
+	for _, chunk := range held {
+		if err = c.writeFrame(chunk); err != nil || !c.opened {
+			return
+		}
+	}
+	return
+}
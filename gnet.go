@@ -25,6 +25,7 @@ import (
 	"context"
 	"net"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -46,6 +47,35 @@ const (
 
 	// Shutdown shutdowns the server.
 	Shutdown
+
+	// CloseAfterFlush closes the connection after the data pending in its outbound buffer has been written to the
+	// kernel, while Close only makes one attempt at writing it, the inbound data arriving in the meantime is
+	// discarded.
+	CloseAfterFlush
+)
+
+// AsyncCallback is called back on the event-loop when the data of Conn.AsyncWrite has been flushed to the kernel,
+// err is nil on success, otherwise it tells why the data won't be flushed, e.g. errors.ErrConnectionClosed.
+type AsyncCallback func(c Conn, err error)
+
+func invokeAsyncCallbacks(c Conn, callbacks []AsyncCallback, err error) {
+	for _, cb := range callbacks {
+		cb(c, err)
+	}
+}
+
+// Priority is the QoS class of a connection.
+type Priority int
+
+const (
+	// PriorityNormal is the class that connections start with.
+	PriorityNormal Priority = iota
+
+	// PriorityHigh connections have their events serviced ahead of the others on the same event-loop.
+	PriorityHigh
+
+	// PriorityLow connections read at most Options.LowPriorityReadBufferCap bytes per readable event.
+	PriorityLow
 )
 
 // Server represents a server context which provides information about the
@@ -60,7 +90,8 @@ type Server struct {
 	Multicore bool
 
 	// The Addr parameter is the listening address that align
-	// with the addr string passed to the Serve function.
+	// with the addr string passed to the Serve function,
+	// it is the first listening address when serving multiple addresses.
 	Addr net.Addr
 
 	// NumEventLoop is the number of event-loops that the server is using.
@@ -75,6 +106,9 @@ type Server struct {
 
 // CountConnections counts the number of currently active connections and returns it.
 func (s Server) CountConnections() (count int) {
+	if !s.svr.isStarted() {
+		return
+	}
 	s.svr.lb.iterate(func(i int, el *eventloop) bool {
 		count += int(el.loadConn())
 		return true
@@ -82,11 +116,62 @@ func (s Server) CountConnections() (count int) {
 	return
 }
 
-// DupFd returns a copy of the underlying file descriptor of listener.
+// ImportFD registers a connected stream socket that was accepted or dialed elsewhere onto one of the event-loops,
+// after which it receives the normal events like connections accepted by the server itself.
+// The server takes over the ownership of fd on success, otherwise fd is left untouched for the caller.
+// It returns ErrServerNotStarted until the server has started serving, which is not the case in OnInitComplete,
+// and it is not supported on Windows.
+func (s Server) ImportFD(fd int) error {
+	return s.svr.importFD(fd)
+}
+
+// ImportConn is like ImportFD but takes a net.Conn, the caller must not use c after it is imported successfully.
+func (s Server) ImportConn(c net.Conn) error {
+	return s.svr.importConn(c)
+}
+
+// CountDroppedDatagrams counts the number of UDP datagrams that have been dropped due to the full send queues.
+func (s Server) CountDroppedDatagrams() (count uint64) {
+	if !s.svr.isStarted() {
+		return
+	}
+	s.svr.lb.iterate(func(i int, el *eventloop) bool {
+		count += el.countDroppedDatagrams()
+		return true
+	})
+	return
+}
+
+// UpdateOptions changes the options of the running server, only the following ones take effect and the others
+// are ignored: LogLevel, CoalesceWrites, UDPSendQueueSize, UDPDropPolicy, IdleTimeout, MaxConns and AcceptRate.
+// The updated options are published at once and the event-loops pick them up the next time they read them, so an
+// iteration in progress may finish with the previous ones. It returns ErrServerNotStarted until the server has
+// started serving, which is not the case in OnInitComplete.
+func (s Server) UpdateOptions(opts ...Option) error {
+	return s.svr.updateOptions(opts...)
+}
+
+// PauseAccept stops the server from accepting new connections by removing the listeners from the pollers,
+// which is handy for admission control during overload or maintenance. The connections arriving meanwhile stay
+// in the backlogs of the listeners and the UDP listeners are left untouched. The event-loops stop accepting once
+// it returns, except for an accept already underway, and it doesn't wait for them, so it can be called from the
+// event handlers. It returns ErrServerNotStarted until the server has started serving and it is not supported
+// on Windows.
+func (s Server) PauseAccept() error {
+	return s.svr.pauseAccept()
+}
+
+// ResumeAccept puts the listeners removed by PauseAccept back to the pollers.
+func (s Server) ResumeAccept() error {
+	return s.svr.resumeAccept()
+}
+
+// DupFd returns a copy of the underlying file descriptor of listener,
+// it is the first listener when serving multiple addresses.
 // It is the caller's responsibility to close dupFD when finished.
 // Closing listener does not affect dupFD, and closing dupFD does not affect listener.
 func (s Server) DupFd() (dupFD int, err error) {
-	dupFD, sc, err := s.svr.ln.Dup()
+	dupFD, sc, err := s.svr.lns[0].Dup()
 	if err != nil {
 		logging.DefaultLogger.Warnf("%s failed when duplicating new fd\n", sc)
 	}
@@ -101,7 +186,8 @@ type Conn interface {
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
-	// LocalAddr is the connection's local socket address.
+	// LocalAddr is the connection's local socket address, which is the address of the listener
+	// that the connection arrived on, its Network() reports the transport: "tcp", "udp" or "unix".
 	LocalAddr() (addr net.Addr)
 
 	// RemoteAddr is the connection's remote peer address.
@@ -128,21 +214,140 @@ type Conn interface {
 	// BufferLength returns the length of available data in the internal buffers.
 	BufferLength() (size int)
 
+	// SetReadBufferCap limits the number of bytes read from the connection on every readable event to n,
+	// which can't exceed Options.ReadBufferCap, and resizes the inbound ring-buffer to it, a non-positive n
+	// restores the limit of Options.ReadBufferCap. Only the ring-buffer is resized on Windows.
+	SetReadBufferCap(n int)
+
+	// SetWriteBufferCap resizes the outbound ring-buffer to hold n bytes without growing, the buffered data is
+	// kept and the ring-buffer still grows when more data is pending, it is a no-op on Windows.
+	// Both setters must be called within the event callbacks of this connection.
+	SetWriteBufferCap(n int)
+
+	// InboundBuffered returns the number of bytes received from the peer and not consumed yet, which is the same
+	// as BufferLength.
+	InboundBuffered() (size int)
+
+	// OutboundBuffered returns the number of bytes waiting in the outbound buffer to be written to the kernel,
+	// it makes a good signal of backpressure and is always zero on Windows where the writes are blocking.
+	// Both accessors must be called within the event callbacks of this connection.
+	OutboundBuffered() (size int)
+
 	// InboundBuffer returns the inbound ring-buffer.
 	// InboundBuffer() *ringbuffer.RingBuffer
 
+	// TCPInfo returns the statistics of a TCP connection like RTT, retransmissions, congestion window and
+	// delivery rate, it is only available on Linux.
+	TCPInfo() (*TCPInfo, error)
+
+	// OriginalDst returns the destination address that the client intended to connect to before the connection
+	// was redirected by netfilter, e.g. by the iptables REDIRECT target, it is only available on Linux.
+	OriginalDst() (net.Addr, error)
+
+	// SetMark sets the SO_MARK option of the connection, overriding the one inherited from the listener,
+	// it is only available on Linux.
+	SetMark(mark int) error
+
+	// SetNoDelay controls whether the Nagle's algorithm is disabled on a TCP connection, which is disabled by
+	// default, enabling it during the bulk transfers saves packets.
+	SetNoDelay(noDelay bool) error
+
+	// SetLinger sets the behavior of Close on a connection with unsent data, see net.TCPConn.SetLinger.
+	SetLinger(sec int) error
+
+	// SetKeepAlivePeriod enables TCP keep-alive on a connection and sets the period between keep-alives,
+	// overriding Options.TCPKeepAlive, note that the period is rounded down to seconds except on Windows.
+	SetKeepAlivePeriod(period time.Duration) error
+
+	// SetTOS sets the IP TOS field, or the traffic class for IPv6, of the outgoing packets of a connection,
+	// it is not supported on Windows.
+	//
+	// All the socket option setters must be called within the event callbacks of this connection and they are
+	// not supported on UDP connections.
+	SetTOS(tos int) error
+
+	// SetQuickAck enables or disables the TCP_QUICKACK option of a TCP connection, which sends the ACKs right away
+	// instead of delaying them, note that the kernel may turn it off again later, so it's common to enable it
+	// every time after reading data. It is only available on Linux.
+	SetQuickAck(quickAck bool) error
+
+	// Priority returns the QoS class of the connection.
+	Priority() Priority
+
+	// SetPriority puts the connection into the given QoS class, which is useful for serving the control-plane
+	// and the bulk traffic on one server. It must be called within the event callbacks of this connection and
+	// it takes no effect on UDP connections and on Windows.
+	SetPriority(p Priority)
+
+	// Tag returns the value of the tag key set by SetTag, which is empty if the connection isn't tagged with key.
+	Tag(key string) string
+
+	// SetTag tags the connection with value by key, e.g. the tenant or the protocol version, the traffic of
+	// the connections is then broken down by the tag values in Server.TagStats. An empty value removes the tag.
+	// It must be called within the event callbacks of this connection and it takes no effect on UDP connections.
+	SetTag(key, value string)
+
 	// SendTo writes data for UDP sockets, it allows you to send data back to UDP socket in individual goroutines.
+	// Like AsyncWrite, the datagram is sent on the event-loop, where it is queued up if the socket send buffer
+	// is full and sent once the socket becomes writable, see Options.UDPSendQueueSize.
 	SendTo(buf []byte) error
 
+	// QueueTo queues buf up as a datagram to the remote peer of a UDP connection, the datagrams queued while
+	// reacting to the inbound datagrams of a UDP socket are sent in batch (by sendmmsg on Linux) to their peers
+	// afterwards, which saves a lot of system calls for servers answering many peers at once.
+	// It must be called within React, and returns ErrUnsupportedOp for the connections other than UDP.
+	QueueTo(buf []byte) error
+
 	// AsyncWrite writes data to client/connection asynchronously, usually you would call it in individual goroutines
-	// instead of the event-loop goroutines.
-	AsyncWrite(buf []byte) error
+	// instead of the event-loop goroutines. Like the output of React, buf is encoded by the codec of the connection,
+	// which is done on the event-loop. The callbacks are invoked on the event-loop once the data has been flushed
+	// to the kernel, or with the reason why it won't be, e.g. the error of encoding it.
+	AsyncWrite(buf []byte, callbacks ...AsyncCallback) error
+
+	// Flush tries to write the data buffered in the outbound buffer to the kernel right away, which is handy when
+	// the writes are coalesced and the frames written so far should be pushed out as one batch now. The leftover
+	// that the kernel doesn't take is written when the connection becomes writable as usual.
+	// It must be called within the event callbacks of this connection, it is a no-op on Windows.
+	Flush() error
+
+	// AsyncFlush is like Flush but it is scheduled onto the event-loop, so it can be called from any goroutine.
+	AsyncFlush() error
+
+	// CloseRead shuts down the reading side of a TCP connection, no more data is read from it.
+	CloseRead() error
+
+	// CloseWrite shuts down the writing side of a TCP connection after the data pending in the outbound buffer,
+	// including the data returned by the current callback, has been written to the kernel, which makes the peer
+	// read EOF, it takes effect right away on Windows. The connection is closed once both sides have been shut down.
+	// Both methods must be called within the event callbacks of this connection.
+	CloseWrite() error
 
 	// Wake triggers a React event for this connection.
 	Wake() error
 
-	// Close closes the current connection.
+	// Close closes the current connection after the pending AsyncWrite calls.
 	Close() error
+
+	// CloseNow is like Close but it is queued ahead of the pending AsyncWrite calls on Linux and BSD,
+	// so the data of the writes which have not run yet will be discarded.
+	CloseNow() error
+
+	// Detach removes the connection from its event-loop and hands the underlying file descriptor over to the caller
+	// along with the inbound bytes that have not been consumed yet in buf and the outbound bytes that have not been
+	// written yet in unsent, which the caller should write to fd ahead of anything else. The caller takes over the
+	// ownership of fd, which is left in non-blocking mode, and is responsible for closing it. The callbacks of the
+	// AsyncWrite calls waiting for unsent to be written get ErrConnectionClosed, OnClosed won't fire for a detached
+	// connection, and the connection is left untouched if Detach fails.
+	//
+	// Note that Detach must be called within the event callbacks of this connection and the bytes returned by
+	// that callback will be discarded, it is not supported on Windows.
+	Detach() (fd int, buf, unsent []byte, err error)
+
+	// Hijack is like Detach but converts the connection into a standard blocking net.Conn backed by its fd,
+	// the inbound bytes that have not been consumed yet will be returned by the first reads of it and the outbound
+	// bytes that have not been written yet are sent ahead of the writes to it in the background.
+	// The same restrictions as Detach apply, it is not supported on Windows.
+	Hijack() (net.Conn, error)
 }
 
 type (
@@ -151,7 +356,9 @@ type (
 	// of the connection and server.
 	EventHandler interface {
 		// OnInitComplete fires when the server is ready for accepting connections.
-		// The parameter:server has information and various utilities.
+		// The parameter:server has information and various utilities, note that the event-loops are started
+		// after it returns, until then the methods of server operating them return ErrServerNotStarted and
+		// the statistics are empty.
 		OnInitComplete(server Server) (action Action)
 
 		// OnShutdown fires when the server is being shut down, it is called right after
@@ -167,7 +374,11 @@ type (
 		OnOpened(c Conn) (out []byte, action Action)
 
 		// OnClosed fires when a connection has been closed.
-		// The parameter:err is the last known connection error.
+		// The parameter:err is the last known connection error, it is one of the close reasons defined in package
+		// errors: ErrPeerClosed, ErrPeerReset, ErrPeerUnreachable, ErrWriteFailed, ErrIdleTimeout, ErrOverloaded,
+		// ErrBufferOverflow or ErrServerShutdown when the connection ends for one of those reasons, or the
+		// *CodecError of the malformed input closing it. It is nil when the connection is closed on purpose by
+		// Close action or Conn.Close(), otherwise it is the underlying error that closes the connection.
 		OnClosed(c Conn, err error) (action Action)
 
 		// PreWrite fires just before any data is written to any client socket, this event function is usually used to
@@ -184,6 +395,33 @@ type (
 		Tick() (delay time.Duration, action Action)
 	}
 
+	// PartialReactor is an optional interface that an EventHandler can implement to parse the TCP stream by itself,
+	// in which case ReactN takes the place of the codec decoding and React for the inbound data of connections.
+	PartialReactor interface {
+		// ReactN fires with all the buffered inbound bytes of c when new data arrives, the parameter:n is
+		// the number of bytes consumed by the handler, the rest stays in the inbound buffer and will be handed over
+		// again along with the subsequent data, ReactN keeps firing as long as it consumes bytes and there is
+		// any left. Parameter:out is encoded by the codec and sent back to the client.
+		ReactN(buf []byte, c Conn) (n int, out []byte, action Action)
+	}
+
+	// HalfCloseHandler is an optional interface that an EventHandler can implement to keep the connections open
+	// after the peers have shut down the writing side of them, which are closed with errors.ErrPeerClosed otherwise.
+	// It is not supported on Windows.
+	HalfCloseHandler interface {
+		// OnReadClosed fires when the peer of c has half-closed the connection, no more data will arrive but c
+		// stays writable until it is closed or both sides are shut down. Parameter:out is sent back to the client.
+		OnReadClosed(c Conn) (out []byte, action Action)
+	}
+
+	// NamedTickHandler is an optional interface that an EventHandler can implement to receive the ticks
+	// of the tickers registered by WithNamedTicker, each of which runs independently of the others and of Tick.
+	NamedTickHandler interface {
+		// NamedTick fires when the ticker of id is due, on the same event-loop as Tick. The delay return value
+		// replaces the interval of the ticker until the next tick if it's positive.
+		NamedTick(id string) (delay time.Duration, action Action)
+	}
+
 	// EventServer is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
@@ -244,13 +482,95 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 //  udp4  - IPv4
 //  udp6  - IPv6
 //  unix  - Unix Domain Socket
+//  fd    - an inherited socket, e.g. `fd://3`, which is bound already, Unix only
+//  pipe  - Windows named pipe, e.g. `pipe://gnet` for `\\.\pipe\gnet`, Windows only
+//
+// The port of a TCP or UDP address can be a range like `tcp://:9000-9100`, in which case one listener is bound
+// per port in the range, all of them share the event-handler and the event-loops, and the address as a whole
+// is what to pass to Stop.
+//
+// The socket passed by "fd" belongs to gnet afterwards and its network is derived from the socket type,
+// which serves the descriptors inherited from a supervisor process without knowing their addresses.
 //
 // The "tcp" network scheme is assumed when one is not specified.
 func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err error) {
+	return ServeMulti(eventHandler, []string{protoAddr}, opts...)
+}
+
+// ServeMulti starts handling events for multiple addresses at once, all of them share the same event-handler
+// and the same set of event-loops, addresses with different network schemes can be mixed up in one call,
+// e.g. `[]string{"udp://:53", "tcp://:53"}` for serving DNS over both UDP and TCP.
+//
+// Every address follows the format described in Serve, use Conn.LocalAddr().Network() to tell which
+// transport a connection arrived on.
+func ServeMulti(eventHandler EventHandler, protoAddrs []string, opts ...Option) (err error) {
+	routes := make([]Route, len(protoAddrs))
+	for i, protoAddr := range protoAddrs {
+		routes[i].ProtoAddr = protoAddr
+	}
+	return ServeRoutes(eventHandler, routes, opts...)
+}
+
+// ServeListener works like Serve except that it serves the connections accepted from ln, which lets the caller
+// bind the socket however it likes, e.g. with exotic socket options or by inheriting it from a parent process.
+//
+// On Unix the socket of ln is duplicated and ln stays owned by the caller, only the listeners of the standard
+// library like *net.TCPListener and *net.UnixListener are supported. On Windows ln is served directly and closed
+// when the server shuts down. The listener is shared by all event-loops under ReusePort since it can't be bound
+// again, and the address to pass to Stop is ln.Addr() prefixed by its network, e.g. "tcp://127.0.0.1:9000".
+func ServeListener(eventHandler EventHandler, ln net.Listener, opts ...Option) (err error) {
+	return ServeRoutes(eventHandler, []Route{{Listener: ln}}, opts...)
+}
+
+// ServePacketConn is the counterpart of ServeListener for UDP, it serves the datagrams arriving on pc, which lets
+// the caller bind the socket on its own, e.g. joining multicast groups on specific interfaces.
+//
+// pc is handled the way ServeListener handles its listener, only *net.UDPConn is supported on Unix.
+func ServePacketConn(eventHandler EventHandler, pc net.PacketConn, opts ...Option) (err error) {
+	return ServeRoutes(eventHandler, []Route{{PacketConn: pc}}, opts...)
+}
+
+// Route binds an address to the event-handler and codec dedicated to the connections arriving on it.
+type Route struct {
+	// ProtoAddr is the address to listen on, it follows the format described in Serve.
+	ProtoAddr string
+
+	// Listener is an existing listener to serve instead of binding ProtoAddr, see ServeListener.
+	Listener net.Listener
+
+	// PacketConn is an existing datagram socket to serve instead of binding ProtoAddr, see ServePacketConn.
+	PacketConn net.PacketConn
+
+	// EventHandler handles the connection events of this address, the one passed to ServeRoutes is used when it's nil.
+	EventHandler EventHandler
+
+	// Codec encodes and decodes the frames of this address, the codec set up by WithCodec is used when it's nil.
+	Codec ICodec
+
+	// Protocols multiplexes several protocols on this address, the first bytes of every stream connection
+	// are peeked to pick the first protocol in order that matches, falling back to the EventHandler and Codec
+	// of the route when none of them does. OnOpened is deferred until the protocol is known, and the connections
+	// closed before that are dropped silently. It takes no effect on UDP.
+	Protocols []Protocol
+
+	// BusyPollBudget overrides Options.SocketBusyPollBudget for the listeners of this address.
+	BusyPollBudget int
+}
+
+// ServeRoutes works like ServeMulti except that every address can be bound to its own event-handler and codec,
+// e.g. one process can host an admin port and a data port with separate logic while sharing the event-loops.
+//
+// OnOpened, OnClosed, PreWrite and React of a connection are dispatched to the event-handler of its route,
+// whereas the server-level events OnInitComplete, OnShutdown and Tick always go to eventHandler.
+func ServeRoutes(eventHandler EventHandler, routes []Route, opts ...Option) (err error) {
 	options := loadOptions(opts...)
 
 	if options.Logger != nil {
 		logging.DefaultLogger = options.Logger
+	} else if options.LogLevel != "" {
+		if err = logging.SetLevel(options.LogLevel); err != nil {
+			return
+		}
 	}
 	defer logging.Cleanup()
 
@@ -267,16 +587,45 @@ func Serve(eventHandler EventHandler, protoAddr string, opts ...Option) (err err
 	} else {
 		options.ReadBufferCap = internal.CeilToPowerOfTwo(rbc)
 	}
+	if lrbc := options.LowPriorityReadBufferCap; lrbc <= 0 || lrbc > options.ReadBufferCap {
+		options.LowPriorityReadBufferCap = options.ReadBufferCap >> 2
+	}
 
-	network, addr := parseProtoAddr(protoAddr)
+	if len(routes) == 0 {
+		return errors.ErrEmptyAddress
+	}
+	if options.HealthCheckAddr != "" {
+		routes = append(routes[:len(routes):len(routes)], healthCheckRoute(options))
+	}
+	if options.AdminAddr != "" {
+		if !isLocalProtoAddr(options.AdminAddr) {
+			return errors.ErrNonLocalAdminAddr
+		}
+		routes = append(routes[:len(routes):len(routes)], adminRoute(options))
+	}
 
-	var ln *listener
-	if ln, err = initListener(network, addr, options); err != nil {
-		return
+	listeners := make([]*listener, 0, len(routes))
+	protoAddrs := make([]string, 0, len(routes))
+	defer func() {
+		for _, ln := range listeners {
+			ln.close()
+		}
+	}()
+	for _, route := range routes {
+		var lns []*listener
+		lns, err = initRouteListeners(route, options)
+		listeners = append(listeners, lns...)
+		if err != nil {
+			return
+		}
+		protoAddr := route.ProtoAddr
+		if protoAddr == "" {
+			protoAddr = lns[0].network + "://" + lns[0].addr
+		}
+		protoAddrs = append(protoAddrs, protoAddr)
 	}
-	defer ln.close()
 
-	return serve(eventHandler, ln, options, protoAddr)
+	return serve(eventHandler, listeners, options, protoAddrs)
 }
 
 var (
@@ -316,6 +665,65 @@ func Stop(ctx context.Context, protoAddr string) error {
 	}
 }
 
+// initRouteListeners sets up the listeners of route, which are more than one when the address spans a port range,
+// the listeners set up before an error occurs are returned along with it.
+func initRouteListeners(route Route, options *Options) (lns []*listener, err error) {
+	var ln *listener
+	switch {
+	case route.Listener != nil:
+		ln, err = initListenerFrom(route.Listener, options)
+	case route.PacketConn != nil:
+		ln, err = initListenerFrom(route.PacketConn, options)
+	default:
+		network, addr := parseProtoAddr(route.ProtoAddr)
+		if route.BusyPollBudget > 0 {
+			opts := *options
+			opts.SocketBusyPollBudget = route.BusyPollBudget
+			options = &opts
+		}
+		var addrs []string
+		if addrs, err = expandPortRange(network, addr); err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			if ln, err = initListener(network, addr, options); err != nil {
+				return
+			}
+			ln.eventHandler, ln.codec, ln.protocols = route.EventHandler, route.Codec, route.Protocols
+			lns = append(lns, ln)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	ln.eventHandler, ln.codec, ln.protocols = route.EventHandler, route.Codec, route.Protocols
+	return []*listener{ln}, nil
+}
+
+// expandPortRange expands an address like `:9000-9100` into one address per port in the range,
+// addresses without a port range and the ones of Unix Domain Socket are returned as they are.
+func expandPortRange(network, addr string) ([]string, error) {
+	if network == "unix" || network == "fd" || network == "pipe" {
+		return []string{addr}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.Contains(port, "-") {
+		return []string{addr}, nil
+	}
+	bounds := strings.SplitN(port, "-", 2)
+	first, err1 := strconv.Atoi(bounds[0])
+	last, err2 := strconv.Atoi(bounds[1])
+	if err1 != nil || err2 != nil || first <= 0 || first > last || last > 65535 {
+		return nil, errors.ErrInvalidPortRange
+	}
+	addrs := make([]string, 0, last-first+1)
+	for p := first; p <= last; p++ {
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(p)))
+	}
+	return addrs, nil
+}
+
 func parseProtoAddr(addr string) (network, address string) {
 	network = "tcp"
 	address = strings.ToLower(addr)
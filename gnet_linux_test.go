@@ -0,0 +1,549 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"github.com/panjf2000/gnet/internal/socket"
+	"golang.org/x/sys/unix"
+)
+
+type testSocketBusyPollServer struct {
+	*EventServer
+	opts chan int
+	done chan error
+}
+
+func (t *testSocketBusyPollServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9939")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			select {
+			case usecs := <-t.opts:
+				if usecs != 50 {
+					return fmt.Errorf("expect SO_BUSY_POLL 50, but got %d", usecs)
+				}
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the connection is not opened")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testSocketBusyPollServer) OnOpened(c Conn) (out []byte, action Action) {
+	// The accepted connections inherit the option of the listener, SO_BUSY_POLL_BUDGET can't be read back though.
+	usecs, _ := unix.GetsockoptInt(c.(*conn).fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL)
+	t.opts <- usecs
+	return
+}
+
+func (t *testSocketBusyPollServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestSocketBusyPoll(t *testing.T) {
+	events := &testSocketBusyPollServer{EventServer: &EventServer{}, opts: make(chan int, 1), done: make(chan error, 1)}
+	err := ServeRoutes(events, []Route{{ProtoAddr: "tcp://:9939", BusyPollBudget: 8}},
+		WithTicker(true), WithSocketBusyPoll(50*time.Microsecond, 0))
+	if os.IsPermission(err) {
+		t.Skip("raising SO_BUSY_POLL requires the CAP_NET_ADMIN capability")
+	}
+	must(err)
+}
+
+type testTransparentUDPServer struct {
+	*EventServer
+	dsts chan string
+	done chan error
+}
+
+func (t *testTransparentUDPServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("udp", "127.0.0.1:9938")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("ping")); err != nil {
+				return err
+			}
+			select {
+			case dst := <-t.dsts:
+				if dst != "127.0.0.1:9938" {
+					return fmt.Errorf("expect the original destination 127.0.0.1:9938, but got %s", dst)
+				}
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the datagram is not received")
+			}
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 16)
+			n, err := c.Read(buf)
+			if err != nil {
+				return err
+			}
+			if string(buf[:n]) != "ping" {
+				return fmt.Errorf("expect the reply ping, but got %q", buf[:n])
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testTransparentUDPServer) React(packet []byte, c Conn) (out []byte, action Action) {
+	dst, err := c.OriginalDst()
+	if err != nil {
+		t.dsts <- err.Error()
+		return
+	}
+	t.dsts <- dst.String()
+	out = packet
+	return
+}
+
+func (t *testTransparentUDPServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestTransparentUDP(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("IP_TRANSPARENT requires the CAP_NET_ADMIN capability")
+	}
+	events := &testTransparentUDPServer{EventServer: &EventServer{}, dsts: make(chan string, 1), done: make(chan error, 1)}
+	// The replying socket is bound to the address of the listener here, which needs SO_REUSEADDR on both sides.
+	must(Serve(events, "udp://127.0.0.1:9938", WithTicker(true), WithTransparent(true), WithReusePort(true)))
+}
+
+func TestUDPSendQueue(t *testing.T) {
+	// The sender of a connected unix datagram socket gets EAGAIN once the receive queue of its peer is full.
+	var fds [2]int
+	for i := range fds {
+		fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0)
+		must(err)
+		defer unix.Close(fd)
+		fds[i] = fd
+	}
+	sa := &unix.SockaddrUnix{Name: fmt.Sprintf("@gnet-udp-send-queue-%d", os.Getpid())}
+	must(unix.Bind(fds[1], sa))
+	must(unix.Connect(fds[0], sa))
+	fill := func() {
+		for unix.Sendto(fds[0], []byte("fill"), 0, sa) == nil {
+		}
+	}
+	drain := func() (packets [][]byte) {
+		buf := make([]byte, 16)
+		for {
+			n, _, err := unix.Recvfrom(fds[1], buf, 0)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) != "fill" {
+				packets = append(packets, append([]byte(nil), buf[:n]...))
+			}
+		}
+	}
+
+	p, err := netpoll.OpenPoller()
+	must(err)
+	defer p.Close()
+	must(p.AddRead(fds[0]))
+	el := new(eventloop)
+	el.poller, el.svr = p, new(server)
+	el.svr.liveOpts.Store(&Options{UDPSendQueueSize: 4})
+
+	// The datagrams beyond the queue size are dropped, the queued ones are sent in order once it's writable.
+	fill()
+	for i := byte(0); i < 6; i++ {
+		must(el.sendToUDP(fds[0], sa, []byte{i}))
+	}
+	if n := el.countDroppedDatagrams(); n != 2 {
+		t.Fatalf("expect 2 dropped datagrams, but got %d", n)
+	}
+	drain()
+	must(el.loopWriteUDP(fds[0]))
+	if packets := drain(); !bytes.Equal(bytes.Join(packets, nil), []byte{0, 1, 2, 3}) {
+		t.Fatalf("expect the queued datagrams 0-3, but got %v", packets)
+	}
+
+	// SendTo called by other goroutines leaves the datagrams to the polling goroutine.
+	el.svr.liveOpts.Store(&Options{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- p.Polling(func(fd int, ev uint32) error {
+			if ev&unix.EPOLLOUT != 0 {
+				return el.loopWriteUDP(fd)
+			}
+			return nil
+		})
+	}()
+	fill()
+	c := &conn{fd: fds[0], sa: sa, loop: el}
+	var wg sync.WaitGroup
+	for i := byte(0); i < 4; i++ {
+		wg.Add(1)
+		go func(i byte) {
+			defer wg.Done()
+			for j := byte(0); j < 5; j++ {
+				must(c.SendTo([]byte{i, j}))
+			}
+		}(i)
+	}
+	wg.Wait()
+	var packets [][]byte
+	for deadline := time.Now().Add(5 * time.Second); len(packets) < 20 && time.Now().Before(deadline); {
+		packets = append(packets, drain()...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	next := make([]byte, 4)
+	for _, packet := range packets {
+		if packet[1] != next[packet[0]] {
+			t.Fatalf("expect the datagrams of every goroutine in order, but got %v", packets)
+		}
+		next[packet[0]]++
+	}
+	if len(packets) != 20 {
+		t.Fatalf("expect 20 datagrams, but got %d", len(packets))
+	}
+	must(p.Trigger(func() error { return errors.ErrServerShutdown }))
+	<-stopped
+}
+
+type testCPUSteeringServer struct {
+	*EventServer
+	loops int
+	done  chan error
+}
+
+func (t *testCPUSteeringServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			var cpus unix.CPUSet
+			if err := unix.SchedGetaffinity(0, &cpus); err != nil {
+				return err
+			}
+			defer func() { _ = unix.SchedSetaffinity(0, &cpus) }()
+
+			// The datagrams sent over the loopback are received on the sending CPU, so all the datagrams sent
+			// from a CPU go to the same event-loop, though their source ports spread them over the group otherwise.
+			for cpu := 0; cpu < len(cpus)*64; cpu++ {
+				if !cpus.IsSet(cpu) {
+					continue
+				}
+				if err := pinToCPU(cpu); err != nil {
+					return err
+				}
+				for i := 0; i < 16; i++ {
+					if err := t.expectLoop(cpu % t.loops); err != nil {
+						return fmt.Errorf("datagram %d from CPU %d: %v", i, cpu, err)
+					}
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+// expectLoop sends a datagram from a new socket and expects it to be handled by the event-loop idx.
+func (t *testCPUSteeringServer) expectLoop(idx int) error {
+	c, err := net.Dial("udp", "127.0.0.1:9990")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("ping")); err != nil {
+		return err
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1)
+	if _, err = c.Read(buf); err != nil {
+		return err
+	}
+	if int(buf[0]) != idx {
+		return fmt.Errorf("expect event-loop %d, but got %d", idx, buf[0])
+	}
+	return nil
+}
+
+func (t *testCPUSteeringServer) React(packet []byte, c Conn) (out []byte, action Action) {
+	out = []byte{byte(c.(*conn).loop.idx)}
+	return
+}
+
+func (t *testCPUSteeringServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestReusePortCPUSteering(t *testing.T) {
+	events := &testCPUSteeringServer{EventServer: &EventServer{}, loops: 4, done: make(chan error, 1)}
+	must(Serve(events, "udp://127.0.0.1:9990", WithTicker(true), WithNumEventLoop(events.loops),
+		WithReusePort(true), WithReusePortCPUSteering(true)))
+}
+
+type testCoalesceWritesServer struct {
+	*EventServer
+	addr string
+	fds  chan int
+	done chan error
+}
+
+func (t *testCoalesceWritesServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			fd := <-t.fds
+			if _, err = c.Write([]byte("foo\nbar\nbaz\n")); err != nil {
+				return err
+			}
+			buf := make([]byte, 12)
+			if _, err = io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			if string(buf) != "FOO\nBAR\nBAZ\n" {
+				return fmt.Errorf("unexpected reply: %q", buf)
+			}
+			// Every write goes out in a segment of its own with TCP_NODELAY.
+			info, err := socket.GetTCPInfo(fd)
+			if err != nil {
+				return err
+			}
+			want := uint32(3)
+			if svr.svr.opts.CoalesceWrites {
+				want = 1
+			}
+			if info.DataSegsOut != want {
+				return fmt.Errorf("expect the replies written in %d segments, but got %d", want, info.DataSegsOut)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testCoalesceWritesServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.fds <- c.(*conn).fd
+	return
+}
+
+func (t *testCoalesceWritesServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = bytes.ToUpper(frame)
+	return
+}
+
+func (t *testCoalesceWritesServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestCoalesceWrites(t *testing.T) {
+	for addr, coalesce := range map[string]bool{"127.0.0.1:9979": true, "127.0.0.1:9977": false} {
+		events := &testCoalesceWritesServer{
+			EventServer: &EventServer{},
+			addr:        addr,
+			fds:         make(chan int, 1),
+			done:        make(chan error, 1),
+		}
+		must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithCoalesceWrites(coalesce),
+			WithCodec(NewLineBasedFrameCodec(0, false))))
+	}
+}
+
+// testSockoptServer reads back the socket options set up by the options from the listener and the connection
+// accepted from it with check.
+type testSockoptServer struct {
+	*EventServer
+	addr  string
+	svr   Server
+	check func(lnFd int, c *conn) error
+	err   error
+	done  chan error
+}
+
+func (t *testSockoptServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		c, err := net.Dial("tcp", t.addr)
+		if err != nil {
+			t.done <- err
+			return
+		}
+		// The client closes the connection once it has been checked, so that the server address is not left
+		// in TIME_WAIT and the tests can be run again right away.
+		_, _ = c.Read(make([]byte, 1))
+		_ = c.Close()
+	}()
+	return
+}
+
+func (t *testSockoptServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.err = t.check(t.svr.svr.lns[0].fd, c.(*conn))
+	out = []byte{'.'}
+	return
+}
+
+func (t *testSockoptServer) OnClosed(c Conn, err error) (action Action) {
+	t.done <- t.err
+	return
+}
+
+func (t *testSockoptServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+// expectSockopt returns an error unless the integer socket option of fd is want.
+func expectSockopt(fd, level, opt, want int, name string) error {
+	got, err := unix.GetsockoptInt(fd, level, opt)
+	if err != nil {
+		return fmt.Errorf("getsockopt %s: %v", name, err)
+	}
+	if got != want {
+		return fmt.Errorf("expect %s to be %d, but got %d", name, want, got)
+	}
+	return nil
+}
+
+func TestQuickAck(t *testing.T) {
+	events := &testSockoptServer{EventServer: &EventServer{}, addr: "127.0.0.1:9980", done: make(chan error, 1)}
+	events.check = func(_ int, c *conn) error {
+		for _, quickAck := range []bool{false, true} {
+			if err := c.SetQuickAck(quickAck); err != nil {
+				return err
+			}
+			want := 0
+			if quickAck {
+				want = 1
+			}
+			if err := expectSockopt(c.fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK, want, "TCP_QUICKACK"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true)))
+}
+
+func TestTCPUserTimeout(t *testing.T) {
+	events := &testSockoptServer{EventServer: &EventServer{}, addr: "127.0.0.1:9976", done: make(chan error, 1)}
+	events.check = func(_ int, c *conn) error {
+		return expectSockopt(c.fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, 1000, "TCP_USER_TIMEOUT")
+	}
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithTCPUserTimeout(time.Second)))
+}
+
+func TestMultipathTCP(t *testing.T) {
+	// The listener falls back to TCP on the kernels without MPTCP support.
+	want := unix.IPPROTO_TCP
+	if fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0x106); err == nil {
+		_ = unix.Close(fd)
+		want = 0x106 // IPPROTO_MPTCP
+	}
+	events := &testSockoptServer{EventServer: &EventServer{}, addr: "127.0.0.1:9975", done: make(chan error, 1)}
+	events.check = func(lnFd int, _ *conn) error {
+		return expectSockopt(lnFd, unix.SOL_SOCKET, unix.SO_PROTOCOL, want, "SO_PROTOCOL")
+	}
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithMultipathTCP(true)))
+}
+
+func TestSocketMark(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("SO_MARK requires the CAP_NET_ADMIN capability")
+	}
+	events := &testSockoptServer{EventServer: &EventServer{}, addr: "127.0.0.1:9974", done: make(chan error, 1)}
+	events.check = func(_ int, c *conn) error {
+		return expectSockopt(c.fd, unix.SOL_SOCKET, unix.SO_MARK, 1, "SO_MARK")
+	}
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithSocketMark(1)))
+}
+
+func TestTransparent(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("IP_TRANSPARENT requires the CAP_NET_ADMIN capability")
+	}
+	events := &testSockoptServer{EventServer: &EventServer{}, addr: "127.0.0.1:9973", done: make(chan error, 1)}
+	events.check = func(lnFd int, c *conn) error {
+		if err := expectSockopt(lnFd, unix.SOL_IP, unix.IP_TRANSPARENT, 1, "IP_TRANSPARENT of the listener"); err != nil {
+			return err
+		}
+		return expectSockopt(c.fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1, "IP_TRANSPARENT")
+	}
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithTransparent(true)))
+}
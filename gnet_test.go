@@ -28,9 +28,15 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -634,6 +640,64 @@ func testTick(network, addr string, t *testing.T) {
 	}
 }
 
+type testFixedRateTickServer struct {
+	*EventServer
+	count int
+	work  time.Duration
+	delay time.Duration
+}
+
+func (t *testFixedRateTickServer) Tick() (delay time.Duration, action Action) {
+	if t.count == 10 {
+		action = Shutdown
+		return
+	}
+	t.count++
+	time.Sleep(t.work)
+	delay = t.delay
+	return
+}
+
+func TestTickerFixedRate(t *testing.T) {
+	// The ticks would take 10*(20+30)ms without the fixed rate.
+	events := &testFixedRateTickServer{EventServer: &EventServer{}, work: 20 * time.Millisecond, delay: 30 * time.Millisecond}
+	start := time.Now()
+	must(Serve(events, "tcp://:9937", WithTicker(true), WithTickerFixedRate(true)))
+	if dur := time.Since(start); dur > 450*time.Millisecond {
+		t.Fatalf("expect the fixed-rate ticks to take about 300ms, but took %v", dur)
+	}
+
+	// The delays shorter than the resolution are raised to it.
+	events = &testFixedRateTickServer{EventServer: &EventServer{}}
+	start = time.Now()
+	must(Serve(events, "tcp://:9937", WithTicker(true), WithTickerResolution(20*time.Millisecond)))
+	if dur := time.Since(start); dur < 200*time.Millisecond {
+		t.Fatalf("expect the ticks to take at least 200ms, but took %v", dur)
+	}
+}
+
+type testNamedTickServer struct {
+	*EventServer
+	counts map[string]int
+}
+
+func (t *testNamedTickServer) NamedTick(id string) (delay time.Duration, action Action) {
+	t.counts[id]++
+	if t.counts["fast"] == 20 {
+		action = Shutdown
+	}
+	return
+}
+
+func TestNamedTickers(t *testing.T) {
+	events := &testNamedTickServer{EventServer: &EventServer{}, counts: make(map[string]int)}
+	must(Serve(events, "tcp://:9936", WithNamedTicker("fast", 10*time.Millisecond),
+		WithNamedTicker("slow", 50*time.Millisecond)))
+	if n := events.counts["slow"]; n < 2 || n > 6 {
+		t.Fatalf("expect the slow ticker to fire about 4 times, but fired %d times", n)
+	}
+}
+
 func TestWakeConn(t *testing.T) {
 	testWakeConn("tcp", ":9990")
 }
@@ -1163,3 +1227,1894 @@ func TestClosedWakeUp(t *testing.T) {
 
 	must(Serve(events, events.protoAddr))
 }
+
+type testServeMultiServer struct {
+	*EventServer
+	tcpAddr, udpAddr string
+	networks         chan string
+}
+
+func (t *testServeMultiServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		for _, network := range []string{"tcp", "udp"} {
+			addr := t.tcpAddr
+			if network == "udp" {
+				addr = t.udpAddr
+			}
+			c, err := net.Dial(network, addr)
+			must(err)
+			data := []byte("Hello " + network)
+			_, err = c.Write(data)
+			must(err)
+			_, err = c.Read(data)
+			must(err)
+			must(c.Close())
+		}
+		fmt.Println("stop server...", Stop(context.TODO(), "tcp://"+t.tcpAddr))
+	}()
+	return
+}
+
+func (t *testServeMultiServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.networks <- c.LocalAddr().Network()
+	out = frame
+	return
+}
+
+func TestServeMulti(t *testing.T) {
+	events := &testServeMultiServer{
+		EventServer: &EventServer{}, tcpAddr: ":9981", udpAddr: ":9982",
+		networks: make(chan string, 2),
+	}
+	must(ServeMulti(events, []string{"tcp://" + events.tcpAddr, "udp://" + events.udpAddr}))
+	if network := <-events.networks; network != "tcp" {
+		t.Fatalf("expected tcp, got %s", network)
+	}
+	if network := <-events.networks; network != "udp" {
+		t.Fatalf("expected udp, got %s", network)
+	}
+}
+
+type testCloseReasonServer struct {
+	*EventServer
+	network, addr string
+	reason        error
+}
+
+func (t *testCloseReasonServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		must(err)
+		_, err = c.Write([]byte("Hello World!"))
+		must(err)
+		must(c.Close())
+	}()
+	return
+}
+
+func (t *testCloseReasonServer) OnClosed(c Conn, err error) (action Action) {
+	t.reason = err
+	action = Shutdown
+	return
+}
+
+func TestCloseReason(t *testing.T) {
+	events := &testCloseReasonServer{EventServer: &EventServer{}, network: "tcp", addr: ":9983"}
+	must(Serve(events, events.network+"://"+events.addr))
+	if events.reason != errors.ErrPeerClosed {
+		t.Fatalf("expected %v, got %v", errors.ErrPeerClosed, events.reason)
+	}
+}
+
+type testImportServer struct {
+	*EventServer
+	network, addr string
+	imported      chan error
+}
+
+// waitForStart blocks until svr has started serving, the goroutines started within OnInitComplete have to wait
+// for it before operating the event-loops.
+func waitForStart(svr Server) {
+	for !svr.svr.isStarted() {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (t *testImportServer) OnInitComplete(svr Server) (action Action) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	c, err := net.Dial("tcp", ln.Addr().String())
+	must(err)
+	sc, err := ln.Accept()
+	must(err)
+	if err = svr.ImportConn(sc); err != errors.ErrServerNotStarted {
+		panic(fmt.Sprintf("expect %v before the server starts, but got %v", errors.ErrServerNotStarted, err))
+	}
+	go func() {
+		defer ln.Close()
+		defer c.Close()
+		waitForStart(svr)
+		must(svr.ImportConn(sc))
+		_, err = c.Write([]byte("Hello World!"))
+		must(err)
+		buf := make([]byte, 12)
+		_, err = io.ReadFull(c, buf)
+		if err == nil && string(buf) != "Hello World!" {
+			err = fmt.Errorf("unexpected echo: %q", buf)
+		}
+		t.imported <- err
+	}()
+	return
+}
+
+func (t *testImportServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if runtime.GOOS == "linux" {
+		must(c.SetQuickAck(true))
+		info, err := c.TCPInfo()
+		must(err)
+		if info.State != 1 || info.SndMSS == 0 {
+			panic(fmt.Sprintf("unexpected TCP info: %+v", info))
+		}
+		// The connection is not redirected, so the original destination is the local address if conntrack knows it.
+		if dst, err := c.OriginalDst(); err == nil && dst.String() != c.LocalAddr().String() {
+			panic(fmt.Sprintf("unexpected original destination: %s", dst))
+		}
+	}
+	out = frame
+	return
+}
+
+func (t *testImportServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.imported:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestImportConn(t *testing.T) {
+	events := &testImportServer{EventServer: &EventServer{}, network: "tcp", addr: ":9985", imported: make(chan error, 1)}
+	must(Serve(events, events.network+"://"+events.addr, WithTicker(true), WithMulticore(true)))
+}
+
+func TestRoundRobinConcurrentNext(t *testing.T) {
+	lb := new(roundRobinLoadBalancer)
+	for i := 0; i < 3; i++ {
+		lb.register(new(eventloop))
+	}
+	var (
+		wg     sync.WaitGroup
+		counts [3]int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				atomic.AddInt32(&counts[lb.next(nil).idx], 1)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, n := range counts {
+		if n != 400 {
+			t.Fatalf("expect 400 connections on event-loop(%d), but got %d", i, n)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	m := newLoopMetrics(&server{opts: &Options{LatencyMetrics: true}})
+	for _, d := range []time.Duration{500 * time.Nanosecond, 3 * time.Microsecond, 3 * time.Microsecond, time.Second} {
+		m.react.observe(d)
+	}
+	h := newHistogram()
+	h.merge(&m.react)
+	if h.Count != 4 {
+		t.Fatalf("expected 4 observations, got %d", h.Count)
+	}
+	if q := h.Quantile(0.5); q != 4*time.Microsecond {
+		t.Fatalf("expected median bound %v, got %v", 4*time.Microsecond, q)
+	}
+	if q := h.Quantile(1); q < time.Second {
+		t.Fatalf("expected max bound >= 1s, got %v", q)
+	}
+	if newLoopMetrics(&server{opts: &Options{}}) != nil {
+		t.Fatal("expected nil metrics when disabled")
+	}
+}
+
+type testSlowReactServer struct {
+	*EventServer
+	network, addr string
+	slow          chan time.Duration
+}
+
+func (t *testSlowReactServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("Hello World!"))
+		must(err)
+	}()
+	return
+}
+
+func (t *testSlowReactServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	time.Sleep(20 * time.Millisecond)
+	return
+}
+
+func (t *testSlowReactServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case <-t.slow:
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestSlowReact(t *testing.T) {
+	events := &testSlowReactServer{EventServer: &EventServer{}, network: "tcp", addr: ":9987", slow: make(chan time.Duration, 1)}
+	must(Serve(events, events.network+"://"+events.addr, WithTicker(true),
+		WithSlowReactThreshold(10*time.Millisecond),
+		WithSlowReactHandler(func(c Conn, elapsed time.Duration) {
+			if elapsed < 10*time.Millisecond {
+				t.Errorf("unexpected elapsed time of slow React: %v", elapsed)
+			}
+			events.slow <- elapsed
+		})))
+}
+
+type testPartialReactServer struct {
+	*EventServer
+	network, addr string
+	done          chan error
+}
+
+func (t *testPartialReactServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("foo\nba"))
+		must(err)
+		time.Sleep(50 * time.Millisecond)
+		_, err = c.Write([]byte("r\n"))
+		must(err)
+		buf := make([]byte, 8)
+		_, err = io.ReadFull(c, buf)
+		if err == nil && string(buf) != "FOO\nBAR\n" {
+			err = fmt.Errorf("unexpected reply: %q", buf)
+		}
+		t.done <- err
+	}()
+	return
+}
+
+func (t *testPartialReactServer) ReactN(buf []byte, c Conn) (n int, out []byte, action Action) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		n, out = i+1, bytes.ToUpper(buf[:i+1])
+	}
+	return
+}
+
+func (t *testPartialReactServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestPartialReactor(t *testing.T) {
+	events := &testPartialReactServer{EventServer: &EventServer{}, network: "tcp", addr: ":9988", done: make(chan error, 1)}
+	must(Serve(events, events.network+"://"+events.addr, WithTicker(true)))
+}
+
+type testQueueToServer struct {
+	*EventServer
+	network, addr string
+	done          chan error
+}
+
+func (t *testQueueToServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial(t.network, t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			for i := 0; i < 10; i++ {
+				if _, err = c.Write([]byte{byte(i)}); err != nil {
+					return err
+				}
+			}
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 2)
+			for i := 0; i < 10; i++ {
+				n, err := io.ReadFull(c, buf)
+				if err != nil {
+					return err
+				}
+				if n != 2 || buf[0] != byte(i) || buf[1] != byte(i) {
+					return fmt.Errorf("unexpected datagram %v", buf[:n])
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testQueueToServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if t.network == "tcp" {
+		// QueueTo is only meant for UDP, the replies of a TCP connection are written as usual.
+		if err := c.QueueTo(frame); err != errors.ErrUnsupportedOp {
+			panic(fmt.Sprintf("expected ErrUnsupportedOp, got %v", err))
+		}
+		for _, b := range frame {
+			out = append(out, b, b)
+		}
+		return
+	}
+	must(c.QueueTo([]byte{frame[0], frame[0]}))
+	return
+}
+
+func (t *testQueueToServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestQueueTo(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		events := &testQueueToServer{EventServer: &EventServer{}, network: "udp", addr: "127.0.0.1:9989", done: make(chan error, 1)}
+		must(Serve(events, events.network+"://"+events.addr, WithTicker(true)))
+	})
+	t.Run("tcp", func(t *testing.T) {
+		events := &testQueueToServer{EventServer: &EventServer{}, network: "tcp", addr: "127.0.0.1:9978", done: make(chan error, 1)}
+		must(Serve(events, events.network+"://"+events.addr, WithTicker(true)))
+	})
+}
+
+type testRouteServer struct {
+	*EventServer
+	cases []testRouteCase
+	done  chan error
+}
+
+// testRouteCase sends the chunks one by one to addr and expects want in reply.
+type testRouteCase struct {
+	addr   string
+	chunks []string
+	want   string
+}
+
+func (t *testRouteServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			for _, tc := range t.cases {
+				c, err := net.Dial("tcp", tc.addr)
+				if err != nil {
+					return err
+				}
+				for _, chunk := range tc.chunks {
+					if _, err = c.Write([]byte(chunk)); err != nil {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				buf := make([]byte, len(tc.want))
+				if err == nil {
+					_, err = io.ReadFull(c, buf)
+				}
+				_ = c.Close()
+				if err != nil {
+					return err
+				}
+				if string(buf) != tc.want {
+					return fmt.Errorf("unexpected reply from %s: %q", tc.addr, buf)
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testRouteServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testRouteServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+type testAdminHandler struct {
+	*EventServer
+}
+
+func (t *testAdminHandler) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = append([]byte("admin:"), frame...)
+	return
+}
+
+func TestServeRoutes(t *testing.T) {
+	events := &testRouteServer{EventServer: &EventServer{}, done: make(chan error, 1), cases: []testRouteCase{
+		{"127.0.0.1:9972", []string{"hello"}, "hello"},
+		{"127.0.0.1:9971", []string{"ping\n"}, "admin:ping\n"},
+	}}
+	must(ServeRoutes(events, []Route{
+		{ProtoAddr: "tcp://:9972"},
+		{ProtoAddr: "tcp://:9971", EventHandler: &testAdminHandler{&EventServer{}}, Codec: new(LineBasedFrameCodec)},
+	}, WithTicker(true)))
+}
+
+func TestSniffProtocols(t *testing.T) {
+	events := &testRouteServer{EventServer: &EventServer{}, done: make(chan error, 1), cases: []testRouteCase{
+		{"127.0.0.1:9970", []string{"hello"}, "hello"},
+		{"127.0.0.1:9970", []string{"ADMIN ping\n"}, "admin:ADMIN ping\n"},
+		{"127.0.0.1:9970", []string{"AD", "MIN ping\n"}, "admin:ADMIN ping\n"},
+		{"127.0.0.1:9970", []string{"AB", "C"}, "ABC"},
+	}}
+	must(ServeRoutes(events, []Route{{ProtoAddr: "tcp://:9970", Protocols: []Protocol{
+		{Match: MatchTLS()},
+		{Match: MatchPrefix("ADMIN "), EventHandler: &testAdminHandler{&EventServer{}}, Codec: new(LineBasedFrameCodec)},
+	}}}, WithTicker(true)))
+}
+
+func TestHealthCheck(t *testing.T) {
+	events := &testRouteServer{EventServer: &EventServer{}, done: make(chan error, 1), cases: []testRouteCase{
+		{"127.0.0.1:9968", []string{"hello"}, "hello"},
+		{"127.0.0.1:9969", []string{"GET /healthz HTTP/1.1\r\n\r\n"}, string(healthCheckResponse)},
+	}}
+	must(Serve(events, "tcp://:9968", WithTicker(true), WithHealthCheck("tcp://:9969", true)))
+}
+
+type testAdminServer struct {
+	*EventServer
+	addr string
+	done chan error
+}
+
+func (t *testAdminServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("unix", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for _, tc := range []struct{ cmd, prefix string }{
+				{"stats", `{"connections":1,"event_loops":1,`},
+				{"conns", `[{"loop":0,`},
+				{"memory", `{"loops":[{"loop":0,"connections":1,"inbound":`},
+				{"loglevel info", "OK"},
+				{"loglevel nonsense", "ERR "},
+				{"bogus", "ERR unknown command"},
+				{"drain", "OK"},
+			} {
+				if _, err = c.Write([]byte(tc.cmd + "\r\n")); err != nil {
+					return err
+				}
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(line, tc.prefix) || !strings.HasSuffix(line, "\r\n") {
+					return fmt.Errorf("unexpected reply to %q: %q", tc.cmd, line)
+				}
+			}
+			// The admin connection itself doesn't hold back draining.
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err = r.ReadString('\n'); err == nil {
+				return fmt.Errorf("unexpected reply after draining")
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("the server is not shut down with the admin connection open")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func TestAdmin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("draining is not supported on Windows")
+	}
+	for protoAddr, local := range map[string]bool{
+		"unix:///run/gnet.sock": true,
+		"tcp://127.0.0.1:9967":  true,
+		"tcp://localhost:9967":  true,
+		"tcp6://[::1]:9967":     true,
+		"tcp://:9967":           false,
+		"tcp://0.0.0.0:9967":    false,
+		"tcp://10.0.0.1:9967":   false,
+		"udp://127.0.0.1:9967":  false,
+	} {
+		if isLocalProtoAddr(protoAddr) != local {
+			t.Fatalf("expect isLocalProtoAddr(%q) to be %t", protoAddr, local)
+		}
+	}
+	if err := Serve(&EventServer{}, "tcp://:9967", WithAdmin("tcp://:9968")); err != errors.ErrNonLocalAdminAddr {
+		t.Fatalf("expect %v for a non-local admin address, but got %v", errors.ErrNonLocalAdminAddr, err)
+	}
+
+	addr := filepath.Join(os.TempDir(), "gnet-admin.sock")
+	events := &testAdminServer{EventServer: &EventServer{}, addr: addr, done: make(chan error, 1)}
+	// The server shuts down by itself after being drained even though the admin connection is open.
+	must(Serve(events, "tcp://:9967", WithAdmin("unix://"+addr)))
+	if err := <-events.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testUpdateOptionsServer struct {
+	*EventServer
+	svr  Server
+	once sync.Once
+	done chan error
+}
+
+func (t *testUpdateOptionsServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	if err := svr.UpdateOptions(WithCoalesceWrites(true)); err != errors.ErrServerNotStarted {
+		panic(fmt.Sprintf("expect %v before the server starts, but got %v", errors.ErrServerNotStarted, err))
+	}
+	return
+}
+
+func (t *testUpdateOptionsServer) run() {
+	svr := t.svr
+	go func() {
+		waitForStart(svr)
+		t.done <- func() error {
+			dial := func() (net.Conn, error) {
+				c, err := net.Dial("tcp", "127.0.0.1:9966")
+				if err != nil {
+					return nil, err
+				}
+				if _, err = c.Write([]byte("hello")); err != nil {
+					c.Close()
+					return nil, err
+				}
+				buf := make([]byte, 5)
+				if _, err = io.ReadFull(c, buf); err == nil && string(buf) != "hello" {
+					err = fmt.Errorf("unexpected echo: %q", buf)
+				}
+				if err != nil {
+					c.Close()
+					return nil, err
+				}
+				return c, nil
+			}
+			echo := func() error {
+				c, err := dial()
+				if err == nil {
+					c.Close()
+				}
+				return err
+			}
+			rejected := func() error {
+				c, err := net.Dial("tcp", "127.0.0.1:9966")
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+				if _, err = c.Read(make([]byte, 1)); err == nil {
+					return fmt.Errorf("expect the connection to be rejected, but read data from it")
+				}
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return fmt.Errorf("expect the connection to be rejected, but it's kept open")
+				}
+				return nil
+			}
+			if err := echo(); err != nil {
+				return err
+			}
+			if err := svr.UpdateOptions(WithLogLevel("nonsense")); err == nil {
+				return fmt.Errorf("invalid log level should be rejected")
+			}
+			if err := svr.UpdateOptions(WithCoalesceWrites(true), WithLogLevel("info"), WithReusePort(true)); err != nil {
+				return err
+			}
+			if opts := svr.svr.loadLiveOptions(); !opts.CoalesceWrites || opts.LogLevel != "info" || opts.ReusePort {
+				return fmt.Errorf("unexpected options after updating: %+v", opts)
+			}
+			if err := echo(); err != nil {
+				return err
+			}
+
+			// The connections beyond MaxConns are closed right after being accepted, once the ones closed by
+			// the client above are gone from the server as well.
+			for deadline := time.Now().Add(time.Second); svr.CountConnections() > 0; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					return fmt.Errorf("%d connections are left open", svr.CountConnections())
+				}
+			}
+			if err := svr.UpdateOptions(WithMaxConns(1)); err != nil {
+				return err
+			}
+			held, err := dial()
+			if err != nil {
+				return err
+			}
+			if err = rejected(); err != nil {
+				held.Close()
+				return err
+			}
+			held.Close()
+			if err = svr.UpdateOptions(WithMaxConns(0)); err != nil {
+				return err
+			}
+
+			// So are the ones beyond AcceptRate, the bucket holds a single token at the rate of 1.
+			if err = svr.UpdateOptions(WithAcceptRate(1)); err != nil {
+				return err
+			}
+			if err = echo(); err != nil {
+				return err
+			}
+			if err = rejected(); err != nil {
+				return err
+			}
+			if err = svr.UpdateOptions(WithAcceptRate(0)); err != nil {
+				return err
+			}
+			return echo()
+		}()
+	}()
+}
+
+func (t *testUpdateOptionsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testUpdateOptionsServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	t.once.Do(t.run)
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestUpdateOptions(t *testing.T) {
+	events := &testUpdateOptionsServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9966", WithTicker(true)))
+}
+
+type testFrameBudgetServer struct {
+	*EventServer
+	done   chan error
+	reacts int32
+}
+
+func (t *testFrameBudgetServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9965")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			var req bytes.Buffer
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(&req, "line-%d\n", i)
+			}
+			if _, err = c.Write(req.Bytes()); err != nil {
+				return err
+			}
+			rd := bufio.NewReader(c)
+			for i := 0; i < 10; i++ {
+				line, err := rd.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if want := fmt.Sprintf("line-%d\n", i); line != want {
+					return fmt.Errorf("unexpected line: %q, want %q", line, want)
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testFrameBudgetServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.reacts, 1)
+	out = frame
+	return
+}
+
+func (t *testFrameBudgetServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestMaxFramesPerIteration(t *testing.T) {
+	events := &testFrameBudgetServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9965", WithTicker(true), WithMaxFramesPerIteration(2),
+		WithCodec(NewLineBasedFrameCodec(64, false))))
+	if n := atomic.LoadInt32(&events.reacts); n != 10 {
+		t.Fatalf("unexpected number of reacts: %d", n)
+	}
+}
+
+type testPriorityServer struct {
+	*EventServer
+	done     chan error
+	maxFrame int32
+}
+
+func (t *testPriorityServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			for _, class := range []string{"high", "low"} {
+				c, err := net.Dial("tcp", "127.0.0.1:9964")
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				reply := make([]byte, 2)
+				if _, err = c.Write([]byte(class)); err != nil {
+					return err
+				}
+				if _, err = io.ReadFull(c, reply); err != nil || string(reply) != "ok" {
+					return fmt.Errorf("unexpected reply to %q: %q, %v", class, reply, err)
+				}
+				data := bytes.Repeat([]byte{'x'}, 1000)
+				if _, err = c.Write(data); err != nil {
+					return err
+				}
+				echo := make([]byte, len(data))
+				if _, err = io.ReadFull(c, echo); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testPriorityServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "high":
+		c.SetPriority(PriorityHigh)
+		return []byte("ok"), None
+	case "low":
+		c.SetPriority(PriorityLow)
+		// Tune the socket of the bulk connection as well.
+		for _, err := range []error{
+			c.SetNoDelay(false), c.SetLinger(-1), c.SetKeepAlivePeriod(time.Minute), c.SetTOS(0x08),
+		} {
+			if err != nil && err != errors.ErrUnsupportedOp {
+				return []byte("ko"), None
+			}
+		}
+		return []byte("ok"), None
+	}
+	if c.Priority() == PriorityLow && int32(len(frame)) > atomic.LoadInt32(&t.maxFrame) {
+		atomic.StoreInt32(&t.maxFrame, int32(len(frame)))
+	}
+	out = frame
+	return
+}
+
+func (t *testPriorityServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestConnPriority(t *testing.T) {
+	events := &testPriorityServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9964", WithTicker(true), WithReadBufferCap(1024)))
+	if runtime.GOOS != "windows" {
+		if n := atomic.LoadInt32(&events.maxFrame); n == 0 || n > 256 {
+			t.Fatalf("unexpected size of the frames read from a low-priority connection: %d", n)
+		}
+	}
+}
+
+type testWriteSliceServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testWriteSliceServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9963")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("go")); err != nil {
+				return err
+			}
+			data := make([]byte, 4<<20)
+			if _, err = io.ReadFull(c, data); err != nil {
+				return err
+			}
+			for i, b := range data {
+				if b != byte(i) {
+					return fmt.Errorf("unexpected byte at %d: %d", i, b)
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testWriteSliceServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = make([]byte, 4<<20)
+	for i := range out {
+		out[i] = byte(i)
+	}
+	return
+}
+
+func (t *testWriteSliceServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestMaxWriteBytesPerIteration(t *testing.T) {
+	events := &testWriteSliceServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9963", WithTicker(true), WithMaxWriteBytesPerIteration(4096)))
+}
+
+type testAsyncCallbackServer struct {
+	*EventServer
+	results chan error
+	done    chan error
+}
+
+func (t *testAsyncCallbackServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9962")
+			if err != nil {
+				return err
+			}
+			if _, err = c.Write([]byte("go")); err != nil {
+				return err
+			}
+			if _, err = io.ReadFull(c, make([]byte, 4<<20)); err != nil {
+				return err
+			}
+			if err = <-t.results; err != nil {
+				return fmt.Errorf("flushed data should be called back with nil error: %v", err)
+			}
+			_ = c.Close()
+			if err = <-t.results; err != errors.ErrConnectionClosed {
+				return fmt.Errorf("unexpected error of writing to a closed connection: %v", err)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testAsyncCallbackServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	go func() {
+		_ = c.AsyncWrite(make([]byte, 4<<20), func(c Conn, err error) {
+			if n := c.OutboundBuffered(); err == nil && n != 0 {
+				err = fmt.Errorf("%d bytes are still buffered after being flushed", n)
+			}
+			t.results <- err
+		})
+	}()
+	return
+}
+
+func (t *testAsyncCallbackServer) OnClosed(c Conn, err error) (action Action) {
+	go func() {
+		_ = c.AsyncWrite([]byte("late"), func(_ Conn, err error) {
+			t.results <- err
+		})
+	}()
+	return
+}
+
+func (t *testAsyncCallbackServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestAsyncWriteCallback(t *testing.T) {
+	events := &testAsyncCallbackServer{EventServer: &EventServer{}, results: make(chan error, 2), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9962", WithTicker(true)))
+}
+
+type testCloseAfterFlushServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testCloseAfterFlushServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9961")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("bye")); err != nil {
+				return err
+			}
+			// Wait for the server to start flushing before reading, so that the reply gets buffered.
+			time.Sleep(100 * time.Millisecond)
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				return err
+			}
+			if len(data) != 4<<20 {
+				return fmt.Errorf("unexpected length of the reply: %d", len(data))
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testCloseAfterFlushServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return make([]byte, 4<<20), CloseAfterFlush
+}
+
+func (t *testCloseAfterFlushServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestCloseAfterFlush(t *testing.T) {
+	events := &testCloseAfterFlushServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9961", WithTicker(true)))
+}
+
+type testCloseOrderServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testCloseOrderServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9927")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("bye")); err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				return err
+			}
+			if len(data) != 100<<12 {
+				return fmt.Errorf("unexpected length of the reply: %d", len(data))
+			}
+			for i := 0; i < 100; i++ {
+				if data[i<<12] != byte(i) {
+					return fmt.Errorf("unexpected chunk %d: %d", i, data[i<<12])
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testCloseOrderServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	go func() {
+		// Close must not overtake the writes queued before it.
+		for i := 0; i < 100; i++ {
+			chunk := make([]byte, 1<<12)
+			chunk[0] = byte(i)
+			_ = c.AsyncWrite(chunk)
+		}
+		_ = c.Close()
+	}()
+	return
+}
+
+func (t *testCloseOrderServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestCloseAfterAsyncWrite(t *testing.T) {
+	events := &testCloseOrderServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9927", WithTicker(true)))
+}
+
+type testHalfCloseServer struct {
+	*EventServer
+	done   chan error
+	closed chan error
+}
+
+func (t *testHalfCloseServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9960")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			// Signal the end of the request with FIN.
+			if err = c.(*net.TCPConn).CloseWrite(); err != nil {
+				return err
+			}
+			reply, err := ioutil.ReadAll(c)
+			if err != nil {
+				return err
+			}
+			if string(reply) != "HELLO" {
+				return fmt.Errorf("unexpected reply: %q", reply)
+			}
+			return <-t.closed
+		}()
+	}()
+	return
+}
+
+func (t *testHalfCloseServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	req, _ := c.Context().([]byte)
+	c.SetContext(append(req, frame...))
+	return
+}
+
+func (t *testHalfCloseServer) OnReadClosed(c Conn) (out []byte, action Action) {
+	req, _ := c.Context().([]byte)
+	out = bytes.ToUpper(req)
+	if err := c.CloseWrite(); err != nil {
+		t.closed <- err
+	}
+	return
+}
+
+func (t *testHalfCloseServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testHalfCloseServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestHalfClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("half-close events are not supported on Windows")
+	}
+	events := &testHalfCloseServer{EventServer: &EventServer{}, done: make(chan error, 1), closed: make(chan error, 2)}
+	must(Serve(events, "tcp://:9960", WithTicker(true)))
+}
+
+type testSocketControlServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testSocketControlServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9959")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			_, err = io.ReadFull(c, make([]byte, 5))
+			return err
+		}()
+	}()
+	return
+}
+
+func (t *testSocketControlServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testSocketControlServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestSocketControl(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		addresses []string
+	)
+	control := func(network, address string, fd int) error {
+		if fd <= 0 {
+			return fmt.Errorf("invalid socket: %d", fd)
+		}
+		mu.Lock()
+		addresses = append(addresses, address)
+		mu.Unlock()
+		return nil
+	}
+	events := &testSocketControlServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9959", WithTicker(true), WithSocketControl(control)))
+	if len(addresses) != 2 || !strings.HasSuffix(addresses[0], ":9959") || !strings.HasPrefix(addresses[1], "127.0.0.1:") {
+		t.Fatalf("unexpected addresses passed to SocketControl: %v", addresses)
+	}
+}
+
+type testServeListenerServer struct {
+	*EventServer
+	addr string
+	ln   net.Listener
+	done chan error
+}
+
+func (t *testServeListenerServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			// The socket is duplicated on Unix, so the caller's listener can go away once the server is up.
+			if t.ln != nil && runtime.GOOS != "windows" {
+				if err := t.ln.Close(); err != nil {
+					return err
+				}
+			}
+			c, err := net.Dial("tcp", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			buf := make([]byte, 5)
+			if _, err = io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			if string(buf) != "hello" {
+				return fmt.Errorf("unexpected echo: %q", buf)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testServeListenerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.LocalAddr().String() != t.addr {
+		action = Close
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testServeListenerServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestServeListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9958")
+	must(err)
+	defer ln.Close()
+	events := &testServeListenerServer{EventServer: &EventServer{}, addr: ln.Addr().String(), ln: ln,
+		done: make(chan error, 1)}
+	must(ServeListener(events, ln, WithTicker(true), WithMulticore(true), WithReusePort(true)))
+}
+
+func TestServeListenerUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("any net.Listener is served directly on Windows")
+	}
+	ln := &struct{ net.Listener }{}
+	if err := ServeListener(&EventServer{}, ln); err != errors.ErrUnsupportedListener {
+		t.Fatalf("expected ErrUnsupportedListener, got %v", err)
+	}
+}
+
+type testServePacketConnServer struct {
+	*EventServer
+	addr string
+	done chan error
+}
+
+func (t *testServePacketConnServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("udp", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 16)
+			n, err := c.Read(buf)
+			if err != nil {
+				return err
+			}
+			if string(buf[:n]) != "hello" {
+				return fmt.Errorf("unexpected echo: %q", buf[:n])
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testServePacketConnServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testServePacketConnServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestServePacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:9956")
+	must(err)
+	defer pc.Close()
+	events := &testServePacketConnServer{EventServer: &EventServer{}, addr: pc.LocalAddr().String(), done: make(chan error, 1)}
+	must(ServePacketConn(events, pc, WithTicker(true), WithMulticore(true)))
+}
+
+type testPortRangeServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testPortRangeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			for port := 9953; port <= 9955; port++ {
+				c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+				if err != nil {
+					return err
+				}
+				if _, err = c.Write([]byte("ping")); err != nil {
+					_ = c.Close()
+					return err
+				}
+				buf := make([]byte, 16)
+				n, err := c.Read(buf)
+				_ = c.Close()
+				if err != nil {
+					return err
+				}
+				if want := strconv.Itoa(port); string(buf[:n]) != want {
+					return fmt.Errorf("connection to port %d landed on port %s", port, buf[:n])
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testPortRangeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = []byte(strconv.Itoa(c.LocalAddr().(*net.TCPAddr).Port))
+	return
+}
+
+func (t *testPortRangeServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestPortRange(t *testing.T) {
+	events := &testPortRangeServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://127.0.0.1:9953-9955", WithTicker(true)))
+
+	for _, addr := range []string{"tcp://:9955-9953", "tcp://:0-10", "tcp://:9000-70000", "tcp://:a-b"} {
+		if err := Serve(&EventServer{}, addr); err != errors.ErrInvalidPortRange {
+			t.Fatalf("expected ErrInvalidPortRange for %s, got %v", addr, err)
+		}
+	}
+}
+
+type testNamedPipeServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testNamedPipeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			f, err := os.OpenFile(`\\.\pipe\gnet-test`, os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err = f.Write([]byte("hello")); err != nil {
+				return err
+			}
+			buf := make([]byte, 5)
+			if _, err = io.ReadFull(f, buf); err != nil {
+				return err
+			}
+			if string(buf) != "hello" {
+				return fmt.Errorf("unexpected echo: %q", buf)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testNamedPipeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.LocalAddr().Network() != "pipe" {
+		action = Close
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testNamedPipeServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestNamedPipe(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		if err := Serve(&EventServer{}, "pipe://gnet-test"); err != errors.ErrUnsupportedProtocol {
+			t.Fatalf("expected ErrUnsupportedProtocol, got %v", err)
+		}
+		return
+	}
+	events := &testNamedPipeServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "pipe://gnet-test", WithTicker(true)))
+}
+
+func TestAcceptFilterUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "freebsd" {
+		t.Skipf("accept filters are not rejected on %s", runtime.GOOS)
+	}
+	if err := Serve(&EventServer{}, "tcp://:9952", WithAcceptFilter("dataready")); err != errors.ErrUnsupportedOp {
+		t.Fatalf("expected ErrUnsupportedOp, got %v", err)
+	}
+}
+
+type testTagServer struct {
+	*EventServer
+	svr    Server
+	closed chan struct{}
+	done   chan error
+}
+
+func (t *testTagServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		t.done <- func() error {
+			waitForStart(svr)
+			buf := make([]byte, 4)
+			dial := func(tenant string) (net.Conn, error) {
+				c, err := net.Dial("tcp", "127.0.0.1:9934")
+				if err != nil {
+					return nil, err
+				}
+				// The first message tags the connection, only the traffic after it counts into the tag.
+				for _, msg := range []string{tenant, "ping"} {
+					if _, err = c.Write([]byte(msg)); err != nil {
+						break
+					}
+					if _, err = io.ReadFull(c, buf); err != nil {
+						break
+					}
+				}
+				return c, err
+			}
+			a, err := dial("acme")
+			if err != nil {
+				return err
+			}
+			b, err := dial("beta")
+			if err != nil {
+				return err
+			}
+			defer b.Close()
+
+			stats := t.svr.TagStats("tenant")
+			for _, tenant := range []string{"acme", "beta"} {
+				if st := stats[tenant]; st.Connections != 1 || st.BytesIn != 4 || st.BytesOut != 8 {
+					return fmt.Errorf("unexpected stats of tenant %s: %+v", tenant, st)
+				}
+			}
+
+			// The traffic of a tag outlives its connections.
+			_ = a.Close()
+			select {
+			case <-t.closed:
+			case <-time.After(time.Second):
+				return fmt.Errorf("the connection is not closed")
+			}
+			if st := t.svr.TagStats("tenant")["acme"]; st.Connections != 0 || st.BytesIn != 4 {
+				return fmt.Errorf("unexpected stats of tenant acme after closing: %+v", st)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testTagServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.Tag("tenant") == "" {
+		c.SetTag("tenant", string(frame))
+		c.SetTag("version", "1")
+	}
+	out = frame
+	return
+}
+
+func (t *testTagServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func (t *testTagServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestConnTags(t *testing.T) {
+	events := &testTagServer{EventServer: &EventServer{}, closed: make(chan struct{}, 2), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9934", WithTicker(true)))
+}
+
+type testAccessLogServer struct {
+	*EventServer
+	addr    string
+	entries chan AccessLogEntry
+	closed  chan error
+	done    chan error
+}
+
+func (t *testAccessLogServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", t.addr)
+			if err != nil {
+				return err
+			}
+			for _, msg := range []string{"hello", "world"} {
+				if _, err = c.Write([]byte(msg)); err != nil {
+					return err
+				}
+				if _, err = io.ReadFull(c, make([]byte, len(msg))); err != nil {
+					return err
+				}
+			}
+			local := c.LocalAddr().String()
+			time.Sleep(50 * time.Millisecond)
+			_ = c.Close()
+
+			var entry AccessLogEntry
+			select {
+			case entry = <-t.entries:
+			case <-time.After(time.Second):
+				return fmt.Errorf("the access log entry is not produced")
+			}
+			if entry.RemoteAddr.String() != local || entry.BytesIn != 10 || entry.BytesOut != 10 || entry.Frames != 2 ||
+				entry.Duration < 50*time.Millisecond || entry.Tags["greeting"] != "yes" {
+				return fmt.Errorf("unexpected access log entry: %+v", entry)
+			}
+			if err = <-t.closed; entry.Err != err {
+				return fmt.Errorf("expect the close reason %v in the access log, but got %v", err, entry.Err)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testAccessLogServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	c.SetTag("greeting", "yes")
+	out = frame
+	return
+}
+
+func (t *testAccessLogServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testAccessLogServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Run("write", func(t *testing.T) {
+		testAccessLog(t, "127.0.0.1:9933", false)
+	})
+	// The coalesced writes are flushed in a different way, which must be accounted for all the same.
+	t.Run("coalesce", func(t *testing.T) {
+		testAccessLog(t, "127.0.0.1:9926", true)
+	})
+}
+
+func testAccessLog(t *testing.T, addr string, coalesce bool) {
+	events := &testAccessLogServer{EventServer: &EventServer{}, addr: addr, entries: make(chan AccessLogEntry, 1),
+		closed: make(chan error, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://"+addr, WithTicker(true), WithCoalesceWrites(coalesce),
+		WithAccessLog(func(entry AccessLogEntry) {
+			events.entries <- entry
+		})))
+}
+
+type testPcapServer struct {
+	*EventServer
+	closed chan struct{}
+	done   chan error
+}
+
+func (t *testPcapServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9932")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			if _, err = io.ReadFull(c, make([]byte, 5)); err != nil {
+				return err
+			}
+			select {
+			case <-t.closed:
+			case <-time.After(time.Second):
+				return fmt.Errorf("the connection is not closed")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testPcapServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out, action = frame, CloseAfterFlush
+	return
+}
+
+func (t *testPcapServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func (t *testPcapServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnet-pcap")
+	must(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.pcap")
+
+	events := &testPcapServer{EventServer: &EventServer{}, closed: make(chan struct{}, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9932", WithTicker(true), WithPcap(path, func(c Conn) bool {
+		return c.RemoteAddr().(*net.TCPAddr).IP.IsLoopback()
+	})))
+
+	data, err := ioutil.ReadFile(path)
+	must(err)
+	if len(data) < 24 || binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 {
+		t.Fatalf("bad pcap header: %x", data)
+	}
+	// The handshake, the data in both directions and the closing handshake.
+	var payloads []string
+	for rec := data[24:]; len(rec) > 0; {
+		n := int(binary.LittleEndian.Uint32(rec[8:]))
+		pkt := rec[16 : 16+n]
+		payloads = append(payloads, string(pkt[14+20+20:]))
+		rec = rec[16+n:]
+	}
+	if want := []string{"", "", "", "hello", "hello", "", "", ""}; fmt.Sprint(payloads) != fmt.Sprint(want) {
+		t.Fatalf("expect the payloads %q, but got %q", want, payloads)
+	}
+}
+
+// seqCodec numbers the frames it encodes, which relies on a codec per connection.
+type seqCodec struct {
+	BuiltInFrameCodec
+	seq int
+}
+
+func (cc *seqCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	cc.seq++
+	return append([]byte(strconv.Itoa(cc.seq)), buf...), nil
+}
+
+type testCodecFactoryServer struct {
+	*EventServer
+	async bool
+	done  chan error
+}
+
+func (t *testCodecFactoryServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			var conns []net.Conn
+			for i := 0; i < 2; i++ {
+				c, err := net.Dial("tcp", "127.0.0.1:9929")
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				conns = append(conns, c)
+			}
+			buf := make([]byte, 2)
+			for _, want := range []string{"1x", "2x"} {
+				for _, c := range conns {
+					if _, err := c.Write([]byte("x")); err != nil {
+						return err
+					}
+					if _, err := io.ReadFull(c, buf); err != nil {
+						return err
+					}
+					if string(buf) != want {
+						return fmt.Errorf("expect %q, but got %q", want, buf)
+					}
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testCodecFactoryServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if !t.async {
+		out = frame
+		return
+	}
+	data := append([]byte{}, frame...)
+	go func() { _ = c.AsyncWrite(data) }()
+	return
+}
+
+func (t *testCodecFactoryServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestCodecFactory(t *testing.T) {
+	events := &testCodecFactoryServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9929", WithTicker(true),
+		WithCodec(CodecFactoryFunc(func() ICodec { return new(seqCodec) }))))
+}
+
+func TestCodecFactoryAsCodec(t *testing.T) {
+	f := CodecFactoryFunc(func() ICodec { return new(seqCodec) })
+	if _, err := f.Encode(nil, []byte("x")); err != errors.ErrUnsupportedOp {
+		t.Fatalf("expected %v from Encode, got %v", errors.ErrUnsupportedOp, err)
+	}
+	_, err := f.Decode(nil)
+	if ce, ok := err.(*CodecError); !ok || ce.Err != errors.ErrUnsupportedOp {
+		t.Fatalf("expected a *CodecError of %v from Decode, got %v", errors.ErrUnsupportedOp, err)
+	}
+}
+
+func TestAsyncWriteEncode(t *testing.T) {
+	events := &testCodecFactoryServer{EventServer: &EventServer{}, async: true, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9929", WithTicker(true),
+		WithCodec(CodecFactoryFunc(func() ICodec { return new(seqCodec) }))))
+}
+
+// digitCodec decodes lines of digits and reports the other lines as malformed.
+type digitCodec struct {
+	LineBasedFrameCodec
+}
+
+func (cc *digitCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return nil, errors.ErrCRLFNotFound
+	}
+	for _, b := range buf[:i] {
+		if b < '0' || b > '9' {
+			return nil, &CodecError{Err: fmt.Errorf("not a digit: %q", b), N: i + 1}
+		}
+	}
+	c.ShiftN(i + 1)
+	return buf[:i], nil
+}
+
+type testCodecErrorServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testCodecErrorServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9928")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("1\nx\n2\n")); err != nil {
+				return err
+			}
+			want := "1\nERR\n2\n"
+			buf := make([]byte, len(want))
+			if _, err = io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			if string(buf) != want {
+				return fmt.Errorf("expect %q, but got %q", want, buf)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testCodecErrorServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testCodecErrorServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestCodecError(t *testing.T) {
+	events := &testCodecErrorServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9928", WithTicker(true), WithCodec(&digitCodec{}),
+		WithCodecErrorPolicy(CodecCallback),
+		WithCodecErrorHandler(func(c Conn, err *CodecError) ([]byte, CodecErrorPolicy) {
+			return []byte("ERR"), CodecSkip
+		})))
+}
@@ -0,0 +1,1142 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+func TestServeInheritedFd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9957")
+	must(err)
+	f, err := ln.(*net.TCPListener).File()
+	must(err)
+	fd, err := unix.Dup(int(f.Fd()))
+	must(err)
+	must(f.Close())
+	must(ln.Close())
+	events := &testServeListenerServer{EventServer: &EventServer{}, addr: "127.0.0.1:9957", done: make(chan error, 1)}
+	must(Serve(events, fmt.Sprintf("fd://%d", fd), WithTicker(true), WithMulticore(true)))
+}
+
+type testStaleFdServer struct {
+	*EventServer
+	svr    Server
+	closed chan error
+	done   chan error
+}
+
+func (t *testStaleFdServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9951")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			select {
+			case err = <-t.closed:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the connection of the stale fd is not closed")
+			}
+			return err
+		}()
+	}()
+	return
+}
+
+func (t *testStaleFdServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// Close the socket behind gnet's back, the connection must be dropped all the same.
+	_ = unix.Close(c.(*conn).fd)
+	action = Close
+	return
+}
+
+func (t *testStaleFdServer) OnClosed(c Conn, err error) (action Action) {
+	if n := t.svr.CountConnections(); n != 0 {
+		t.closed <- fmt.Errorf("%d connections left after closing the stale one", n)
+		return
+	}
+	t.closed <- nil
+	return
+}
+
+func (t *testStaleFdServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestStaleFd(t *testing.T) {
+	events := &testStaleFdServer{EventServer: &EventServer{}, closed: make(chan error, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9951", WithTicker(true)))
+}
+
+type testLoopRestartServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testLoopRestartServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9950")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			buf := make([]byte, 5)
+			for _, msg := range []string{"first", "again"} {
+				if _, err = c.Write([]byte(msg)); err != nil {
+					return err
+				}
+				_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, err = io.ReadFull(c, buf); err != nil {
+					return err
+				}
+				if string(buf) != msg {
+					return fmt.Errorf("unexpected echo: %q", buf)
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testLoopRestartServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// Recover the event-loop the way it's done after its poller failing,
+	// the connection must keep being served by the fresh poller.
+	if string(frame) == "first" {
+		must(c.(*conn).loop.restart())
+	}
+	out = frame
+	return
+}
+
+func (t *testLoopRestartServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestLoopRestart(t *testing.T) {
+	events := &testLoopRestartServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9950", WithTicker(true)))
+}
+
+type testWatchdogServer struct {
+	*EventServer
+	stalls chan []byte
+	done   chan error
+}
+
+func (t *testWatchdogServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9949")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("block")); err != nil {
+				return err
+			}
+			select {
+			case stacks := <-t.stalls:
+				if !bytes.Contains(stacks, []byte("testWatchdogServer).React")) {
+					return fmt.Errorf("the blocked React is missing in the stack dump:\n%s", stacks)
+				}
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the blocked event-loop is not reported")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testWatchdogServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	time.Sleep(500 * time.Millisecond)
+	return
+}
+
+func (t *testWatchdogServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestWatchdog(t *testing.T) {
+	events := &testWatchdogServer{EventServer: &EventServer{}, stalls: make(chan []byte, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9949", WithTicker(true), WithWatchdog(100*time.Millisecond),
+		WithStallHandler(func(idx int, stacks []byte) {
+			select {
+			case events.stalls <- stacks:
+			default:
+			}
+		})))
+}
+
+type testAcceptErrorServer struct {
+	*EventServer
+	svr    Server
+	errs   chan error
+	opened chan struct{}
+	done   chan error
+}
+
+func (t *testAcceptErrorServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		t.done <- func() error {
+			waitForStart(svr)
+			// Fail the main reactor on accepting, the listener is put back to the poller after the backoff.
+			el := svr.svr.mainLoop
+			if err := el.poller.Trigger(func() error {
+				for fd := range el.listeners {
+					return el.handleAcceptError(fd, unix.EMFILE)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			select {
+			case err := <-t.errs:
+				if err != unix.EMFILE {
+					return fmt.Errorf("unexpected accept error: %v", err)
+				}
+			case <-time.After(time.Second):
+				return fmt.Errorf("the accept error handler is not invoked")
+			}
+			c, err := net.Dial("tcp", "127.0.0.1:9948")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			select {
+			case <-t.opened:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the listener is not watched again after the backoff")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testAcceptErrorServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func (t *testAcceptErrorServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestAcceptErrorPolicy(t *testing.T) {
+	events := &testAcceptErrorServer{
+		EventServer: &EventServer{},
+		errs:        make(chan error, 1),
+		opened:      make(chan struct{}, 1),
+		done:        make(chan error, 1),
+	}
+	must(Serve(events, "tcp://:9948", WithTicker(true),
+		WithAcceptErrorPolicy(AcceptCallback),
+		WithAcceptErrorHandler(func(err error) Action {
+			events.errs <- err
+			return None
+		})))
+}
+
+type testSpareFdServer struct {
+	*EventServer
+	opened chan struct{}
+	done   chan error
+}
+
+func (t *testSpareFdServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			waitForStart(svr)
+			el := svr.svr.mainLoop
+			lnfds := make(chan int, 1)
+			// Keep the connection pending in the backlog, then run out of file-descriptors on accepting it.
+			if err := el.poller.Trigger(func() error {
+				for fd := range el.listeners {
+					err := el.poller.ModNone(fd)
+					lnfds <- fd
+					return err
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			lnfd := <-lnfds
+			c, err := net.Dial("tcp", "127.0.0.1:9947")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if err = el.poller.Trigger(func() error {
+				return el.handleAcceptError(lnfd, unix.EMFILE)
+			}); err != nil {
+				return err
+			}
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+				return fmt.Errorf("the pending connection is not shed: %v", err)
+			}
+			select {
+			case <-t.opened:
+				return fmt.Errorf("the shed connection is opened")
+			default:
+			}
+			svr.svr.spareMu.Lock()
+			defer svr.svr.spareMu.Unlock()
+			if svr.svr.spareFd < 0 {
+				return fmt.Errorf("the spare file-descriptor is not reserved again")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testSpareFdServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func (t *testSpareFdServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestSpareFd(t *testing.T) {
+	events := &testSpareFdServer{EventServer: &EventServer{}, opened: make(chan struct{}, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9947", WithTicker(true), WithSpareFd(true)))
+}
+
+type testPauseAcceptServer struct {
+	*EventServer
+	svr         Server
+	fromHandler bool // whether accepting is paused and resumed by the event handlers
+	pause       sync.Once
+	opened      chan struct{}
+	done        chan error
+}
+
+func (t *testPauseAcceptServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	if err := svr.PauseAccept(); err != errors.ErrServerNotStarted {
+		panic(fmt.Sprintf("expect %v before the server starts, but got %v", errors.ErrServerNotStarted, err))
+	}
+	go func() {
+		t.done <- func() error {
+			waitForStart(svr)
+			var trigger net.Conn
+			if t.fromHandler {
+				// OnOpened of the first connection pauses accepting.
+				c, err := net.Dial("tcp", "127.0.0.1:9946")
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				select {
+				case <-t.opened:
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("the connection pausing accepting is not accepted")
+				}
+				trigger = c
+			} else if err := svr.PauseAccept(); err != nil {
+				return err
+			}
+			c, err := net.Dial("tcp", "127.0.0.1:9946")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			select {
+			case <-t.opened:
+				return fmt.Errorf("the connection is accepted while accepting is paused")
+			case <-time.After(200 * time.Millisecond):
+			}
+			if trigger != nil {
+				// React resumes accepting.
+				_, err = trigger.Write([]byte("resume"))
+			} else {
+				err = svr.ResumeAccept()
+			}
+			if err != nil {
+				return err
+			}
+			select {
+			case <-t.opened:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the connection is not accepted after accepting is resumed")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testPauseAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	if t.fromHandler {
+		t.pause.Do(func() { must(t.svr.PauseAccept()) })
+	}
+	t.opened <- struct{}{}
+	return
+}
+
+func (t *testPauseAcceptServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	must(t.svr.ResumeAccept())
+	return
+}
+
+func (t *testPauseAcceptServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestPauseAccept(t *testing.T) {
+	for _, reusePort := range []bool{false, true} {
+		for _, fromHandler := range []bool{false, true} {
+			events := &testPauseAcceptServer{
+				EventServer: &EventServer{},
+				fromHandler: fromHandler,
+				opened:      make(chan struct{}, 1),
+				done:        make(chan error, 1),
+			}
+			must(Serve(events, "tcp://:9946", WithTicker(true), WithReusePort(reusePort), WithNumEventLoop(2)))
+		}
+	}
+}
+
+type testRebindServer struct {
+	*EventServer
+	opened chan struct{}
+	done   chan error
+}
+
+func (t *testRebindServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			waitForStart(svr)
+			el := svr.svr.mainLoop
+			// Shutting down the listening socket makes it fail on accepting, just like its address is gone.
+			if err := el.poller.Trigger(func() error {
+				for fd := range el.listeners {
+					return unix.Shutdown(fd, unix.SHUT_RDWR)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				c, err := net.Dial("tcp", "127.0.0.1:9945")
+				if err == nil {
+					defer c.Close()
+					break
+				}
+				if time.Now().After(deadline) {
+					return fmt.Errorf("the listener is not rebound: %v", err)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			select {
+			case <-t.opened:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the connection is not accepted by the rebound listener")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testRebindServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func (t *testRebindServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestRebindListeners(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shutting down a listening socket makes it fail on accepting only on Linux")
+	}
+	events := &testRebindServer{EventServer: &EventServer{}, opened: make(chan struct{}, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9945", WithTicker(true), WithRebindListeners(true)))
+}
+
+type testPoolConnsServer struct {
+	*EventServer
+	done chan error
+}
+
+func (t *testPoolConnsServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			// Every round gets a recycled connection object which must carry nothing from the previous one.
+			for i := 0; i < 20; i++ {
+				c, err := net.Dial("tcp", "127.0.0.1:9944")
+				if err != nil {
+					return err
+				}
+				msg := []byte(fmt.Sprintf("hello-%d", i))
+				if _, err = c.Write(msg); err != nil {
+					c.Close()
+					return err
+				}
+				buf := make([]byte, len(msg))
+				_, err = io.ReadFull(c, buf)
+				c.Close()
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(buf, msg) {
+					return fmt.Errorf("unexpected echo: %q", buf)
+				}
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testPoolConnsServer) OnOpened(c Conn) (out []byte, action Action) {
+	if c.Context() != nil {
+		action = Close
+		return
+	}
+	c.SetContext(c.RemoteAddr().String())
+	return
+}
+
+func (t *testPoolConnsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.Context() != c.RemoteAddr().String() {
+		action = Close
+		return
+	}
+	out = frame
+	return
+}
+
+func (t *testPoolConnsServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestPoolConns(t *testing.T) {
+	events := &testPoolConnsServer{EventServer: &EventServer{}, done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9944", WithTicker(true), WithPoolConns(true)))
+}
+
+type testMemoryLimitServer struct {
+	*EventServer
+	addr   string
+	policy MemoryPolicy
+	closed chan error
+	done   chan error
+}
+
+func (t *testMemoryLimitServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			// An incomplete line keeps growing the inbound buffer beyond the limit.
+			if _, err = c.Write(bytes.Repeat([]byte("x"), 8192)); err != nil {
+				return err
+			}
+			if t.policy == MemoryCloseHungry {
+				select {
+				case err = <-t.closed:
+					if err != errors.ErrBufferOverflow {
+						return fmt.Errorf("unexpected error of closing the connection: %v", err)
+					}
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("the connection over the memory limit is not closed")
+				}
+				return nil
+			}
+
+			// The partial line is rejected while the connection stays open, so the next line is echoed alone.
+			select {
+			case err = <-t.closed:
+				return fmt.Errorf("the connection over the memory limit is closed: %v", err)
+			case <-time.After(500 * time.Millisecond):
+			}
+			if _, err = c.Write([]byte("\nping\n")); err != nil {
+				return err
+			}
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			line, err := bufio.NewReader(c).ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if line != "ping\n" {
+				return fmt.Errorf("expect the rejected frame to be discarded, but got a line of %d bytes", len(line))
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testMemoryLimitServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if len(frame) > 0 {
+		out = frame
+	}
+	return
+}
+
+func (t *testMemoryLimitServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testMemoryLimitServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for i, policy := range []MemoryPolicy{MemoryRejectFrames, MemoryCloseHungry} {
+		events := &testMemoryLimitServer{
+			EventServer: &EventServer{},
+			addr:        fmt.Sprintf("127.0.0.1:%d", 9943-i),
+			policy:      policy,
+			closed:      make(chan error, 1),
+			done:        make(chan error, 1),
+		}
+		must(Serve(events, "tcp://"+events.addr, WithTicker(true), WithMemoryLimit(1024, policy),
+			WithCodec(NewLineBasedFrameCodec(1<<20, false))))
+	}
+}
+
+type testOverloadServer struct {
+	*EventServer
+	reports chan bool
+	closed  chan error
+	done    chan error
+}
+
+func (t *testOverloadServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			low, err := net.Dial("tcp", "127.0.0.1:9941")
+			if err != nil {
+				return err
+			}
+			defer low.Close()
+			if _, err = low.Write([]byte("low")); err != nil {
+				return err
+			}
+			time.Sleep(100 * time.Millisecond)
+			c, err := net.Dial("tcp", "127.0.0.1:9941")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("block")); err != nil {
+				return err
+			}
+			for _, want := range []bool{true, false} {
+				select {
+				case overloaded := <-t.reports:
+					if overloaded != want {
+						return fmt.Errorf("expect overloaded=%t, but got %t", want, overloaded)
+					}
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("the overload is not reported")
+				}
+			}
+			select {
+			case err = <-t.closed:
+				if err != errors.ErrOverloaded {
+					return fmt.Errorf("unexpected error of closing the low-priority connection: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the low-priority connection is not dropped")
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testOverloadServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "low":
+		c.SetPriority(PriorityLow)
+	case "block":
+		time.Sleep(500 * time.Millisecond)
+	}
+	return
+}
+
+func (t *testOverloadServer) OnClosed(c Conn, err error) (action Action) {
+	if c.Priority() == PriorityLow {
+		t.closed <- err
+	}
+	return
+}
+
+func (t *testOverloadServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestOverloadProtection(t *testing.T) {
+	events := &testOverloadServer{
+		EventServer: &EventServer{},
+		reports:     make(chan bool, 2),
+		closed:      make(chan error, 1),
+		done:        make(chan error, 1),
+	}
+	must(Serve(events, "tcp://:9941", WithTicker(true), WithNumEventLoop(1), WithOverloadProtection(100*time.Millisecond),
+		WithOverloadHandler(func(idx int, latency time.Duration, overloaded bool) {
+			events.reports <- overloaded
+		})))
+}
+
+type testBusyPollServer struct {
+	*EventServer
+	loops chan *eventloop
+	done  chan error
+}
+
+func (t *testBusyPollServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", "127.0.0.1:9940")
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello")); err != nil {
+				return err
+			}
+			if _, err = c.Read(make([]byte, 5)); err != nil {
+				return err
+			}
+			el := <-t.loops
+			// The poller keeps polling for a while after the last events, then it blocks again.
+			r0 := el.poller.Rounds()
+			time.Sleep(300 * time.Millisecond)
+			r1 := el.poller.Rounds()
+			time.Sleep(100 * time.Millisecond)
+			r2 := el.poller.Rounds()
+			if r1-r0 < 10 {
+				return fmt.Errorf("the poller is not busy polling after the last events: %d rounds", r1-r0)
+			}
+			if r2 != r1 {
+				return fmt.Errorf("the poller keeps busy polling after the busy-poll window: %d rounds", r2-r1)
+			}
+			return nil
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = Stop(ctx, "tcp://:9940")
+	}()
+	return
+}
+
+func (t *testBusyPollServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.loops <- c.(*conn).loop
+	out = frame
+	return
+}
+
+func TestBusyPoll(t *testing.T) {
+	events := &testBusyPollServer{EventServer: &EventServer{}, loops: make(chan *eventloop, 1), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9940", WithBusyPoll(100*time.Millisecond)))
+	if err := <-events.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testIdleTimeoutServer struct {
+	*EventServer
+	svr    Server
+	closed chan error
+	done   chan error
+}
+
+func (t *testIdleTimeoutServer) OnInitComplete(svr Server) (action Action) {
+	t.svr = svr
+	go func() {
+		waitForStart(svr)
+		t.done <- func() error {
+			idle, err := net.Dial("tcp", "127.0.0.1:9935")
+			if err != nil {
+				return err
+			}
+			defer idle.Close()
+			busy, err := net.Dial("tcp", "127.0.0.1:9935")
+			if err != nil {
+				return err
+			}
+			defer busy.Close()
+			buf := make([]byte, 4)
+			ping := func() error {
+				if _, err := busy.Write([]byte("ping")); err != nil {
+					return err
+				}
+				_ = busy.SetReadDeadline(time.Now().Add(time.Second))
+				_, err := io.ReadFull(busy, buf)
+				return err
+			}
+
+			deadline := time.After(2 * time.Second)
+		wait:
+			for {
+				select {
+				case err := <-t.closed:
+					if err != errors.ErrIdleTimeout {
+						return fmt.Errorf("expect the idle connection closed with ErrIdleTimeout, but got %v", err)
+					}
+					break wait
+				case <-deadline:
+					return fmt.Errorf("the idle connection is not closed")
+				case <-time.After(50 * time.Millisecond):
+					if err := ping(); err != nil {
+						return fmt.Errorf("the busy connection is broken: %v", err)
+					}
+				}
+			}
+			if n := t.svr.Metrics().IdleClosed; n != 1 {
+				return fmt.Errorf("expect 1 connection closed for being idle, but got %d", n)
+			}
+
+			// The busy connection stays open while idle once IdleTimeout is disabled.
+			if err := t.svr.UpdateOptions(WithIdleTimeout(0)); err != nil {
+				return err
+			}
+			time.Sleep(500 * time.Millisecond)
+			return ping()
+		}()
+	}()
+	return
+}
+
+func (t *testIdleTimeoutServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testIdleTimeoutServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testIdleTimeoutServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestIdleTimeout(t *testing.T) {
+	events := &testIdleTimeoutServer{EventServer: &EventServer{}, closed: make(chan error, 2), done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9935", WithTicker(true), WithIdleTimeout(200*time.Millisecond)))
+}
+
+type testFaultServer struct {
+	*EventServer
+	addr string
+	want string
+	done chan error
+}
+
+func (t *testFaultServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		t.done <- func() error {
+			c, err := net.Dial("tcp", t.addr)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("ab")); err != nil {
+				return err
+			}
+			_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, len(t.want))
+			if _, err = io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			if string(buf) != t.want {
+				return fmt.Errorf("expect %q, but got %q", t.want, buf)
+			}
+			return nil
+		}()
+	}()
+	return
+}
+
+func (t *testFaultServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testFaultServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.done:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestFaultInjection(t *testing.T) {
+	// Both the inbound and the echoed data are duplicated.
+	events := &testFaultServer{EventServer: &EventServer{}, addr: "127.0.0.1:9931", want: "abababab", done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9931", WithTicker(true), WithFaultInjection(FaultInjection{Duplicate: 1})))
+
+	// Both the inbound and the echoed data are held back and come through intact.
+	events = &testFaultServer{EventServer: &EventServer{}, addr: "127.0.0.1:9930", want: "ab", done: make(chan error, 1)}
+	must(Serve(events, "tcp://:9930", WithTicker(true),
+		WithFaultInjection(FaultInjection{Delay: 1, MaxDelay: 100 * time.Millisecond, Seed: 1})))
+}
+
+func TestPoolConnsStaleTasks(t *testing.T) {
+	el := new(eventloop)
+	el.svr = &server{opts: &Options{PoolConns: true}, codec: new(BuiltInFrameCodec)}
+	el.eventHandler = &EventServer{}
+
+	// The tasks queued for a connection must not run once it has been released, whether or not its object
+	// has been recycled for another connection in the meantime.
+	c := newTCPConn(-1, el, nil, nil, nil)
+	var ran bool
+	task := c.current(func() error {
+		ran = true
+		return nil
+	})
+	c.releaseTCP()
+	_ = newTCPConn(-1, el, nil, nil, nil)
+	if err := task(); err != nil || ran {
+		t.Fatalf("stale task ran: %v", err)
+	}
+	c = newTCPConn(-1, el, nil, nil, nil)
+	if err := c.current(func() error {
+		ran = true
+		return nil
+	})(); err != nil || !ran {
+		t.Fatalf("current task did not run: %v", err)
+	}
+
+	// The released connection stays intact until the event-loop is done with the current iteration.
+	p, err := netpoll.OpenPoller()
+	must(err)
+	defer p.Close()
+	el.poller = p
+	c.releaseTCP()
+	el.recycleConn(c)
+	if c.eventHandler == nil || len(el.recycled) != 1 {
+		t.Fatalf("connection recycled before the end of the iteration")
+	}
+	must(el.flushRecycled())
+	if c.eventHandler != nil || len(el.recycled) != 0 {
+		t.Fatalf("connection not recycled at the end of the iteration")
+	}
+}
+
+type testDetachServer struct {
+	*EventServer
+	network, addr string
+	hijack        bool
+	detached      chan error
+	closed        int32
+}
+
+func (t *testDetachServer) OnInitComplete(_ Server) (action Action) {
+	go func() {
+		c, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("Hello World!"))
+		must(err)
+		buf := make([]byte, 12)
+		_, err = io.ReadFull(c, buf)
+		if err == nil && string(buf) != "Hello World!" {
+			err = fmt.Errorf("unexpected echo: %q", buf)
+		}
+		t.detached <- err
+	}()
+	return
+}
+
+func (t *testDetachServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// Leave the head of the echo in outbound buffer as if it couldn't be written yet,
+	// which must be handed over and sent ahead of the rest.
+	_, _ = c.(*conn).outboundBuffer.Write(frame[:6])
+	if t.hijack {
+		nc, err := c.Hijack()
+		if err != nil {
+			t.detached <- err
+			return
+		}
+		_, _ = nc.Write(frame[6:])
+		_ = nc.Close()
+		return
+	}
+	fd, _, unsent, err := c.Detach()
+	if err != nil {
+		t.detached <- err
+		return
+	}
+	if !bytes.Equal(unsent, frame[:6]) {
+		t.detached <- fmt.Errorf("unexpected unsent bytes: %q", unsent)
+	}
+	f := os.NewFile(uintptr(fd), "detached")
+	_, _ = f.Write(append(unsent, frame[6:]...))
+	_ = f.Close()
+	return
+}
+
+func (t *testDetachServer) OnClosed(c Conn, err error) (action Action) {
+	atomic.StoreInt32(&t.closed, 1)
+	return
+}
+
+func (t *testDetachServer) Tick() (delay time.Duration, action Action) {
+	delay = 100 * time.Millisecond
+	select {
+	case err := <-t.detached:
+		must(err)
+		action = Shutdown
+	default:
+	}
+	return
+}
+
+func TestDetach(t *testing.T) {
+	t.Run("detach", func(t *testing.T) {
+		testDetach(t, ":9984", false)
+	})
+	t.Run("hijack", func(t *testing.T) {
+		testDetach(t, ":9986", true)
+	})
+}
+
+func testDetach(t *testing.T, addr string, hijack bool) {
+	events := &testDetachServer{EventServer: &EventServer{}, network: "tcp", addr: addr, hijack: hijack, detached: make(chan error, 2)}
+	must(Serve(events, events.network+"://"+events.addr, WithTicker(true)))
+	if atomic.LoadInt32(&events.closed) != 0 {
+		t.Fatal("OnClosed fired for a detached connection")
+	}
+}
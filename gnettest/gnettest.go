@@ -0,0 +1,484 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package gnettest provides an in-memory transport for unit-testing gnet.EventHandler implementations
+// and codecs without binding any ports.
+//
+// A Harness drives the event callbacks of the handler synchronously on the calling goroutine, the inbound
+// bytes fed through Conn.Input are decoded by the codec and handed to React frame by frame, and everything
+// the handler replies with is encoded and collected, ready to be fetched by Conn.Output.
+//
+// A Simulation runs a Harness on a virtual clock for reproducible tests of the ordering, timeout and
+// backpressure behavior, with the inbound data of many connections split and interleaved at random by a seed.
+package gnettest
+
+import (
+	"net"
+	"time"
+
+	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/errors"
+)
+
+// Harness runs a gnet.EventHandler against in-memory connections.
+type Harness struct {
+	handler  gnet.EventHandler
+	codec    gnet.ICodec
+	opts     *gnet.Options
+	tasks    []func()
+	shutdown bool
+	sim      *Simulation // the simulation running the harness, if any
+}
+
+// New creates a Harness for eventHandler, only the codec and its error policy in opts are taken into account,
+// gnet.BuiltInFrameCodec is used when the codec is not set up.
+func New(eventHandler gnet.EventHandler, opts ...gnet.Option) *Harness {
+	options := new(gnet.Options)
+	for _, opt := range opts {
+		opt(options)
+	}
+	codec := options.Codec
+	if codec == nil {
+		codec = new(gnet.BuiltInFrameCodec)
+	}
+	return &Harness{handler: eventHandler, codec: codec, opts: options}
+}
+
+// Shutdown reports whether the handler has ever returned gnet.Shutdown.
+func (h *Harness) Shutdown() bool {
+	return h.shutdown
+}
+
+// Open creates a new connection and fires OnOpened for it.
+func (h *Harness) Open() *Conn {
+	codec := h.codec
+	if f, ok := codec.(gnet.CodecFactory); ok {
+		codec = f.NewCodec()
+	}
+	c := &Conn{
+		h:          h,
+		codec:      codec,
+		localAddr:  pipeAddr("local"),
+		remoteAddr: pipeAddr("remote"),
+		opened:     true,
+	}
+	out, action := h.handler.OnOpened(c)
+	if out != nil {
+		c.write(out)
+	}
+	h.handleAction(c, action)
+	h.runTasks()
+	return c
+}
+
+func (h *Harness) handleAction(c *Conn, action gnet.Action) {
+	switch action {
+	// The output is never held back by the harness, so there is nothing to flush before closing.
+	case gnet.Close, gnet.CloseAfterFlush:
+		c.close(nil)
+	case gnet.Shutdown:
+		h.shutdown = true
+	}
+}
+
+// runTasks runs the operations that the real event-loop performs asynchronously, like AsyncWrite and Wake.
+func (h *Harness) runTasks() {
+	for len(h.tasks) > 0 {
+		task := h.tasks[0]
+		h.tasks = h.tasks[1:]
+		task()
+	}
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// Conn is an in-memory gnet.Conn driven by a Harness.
+type Conn struct {
+	h          *Harness
+	codec      gnet.ICodec
+	ctx        interface{}
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	inbound    []byte
+	outbound   []byte
+	opened     bool
+	priority   gnet.Priority
+	tags       map[string]string
+	err        error
+	active     time.Duration // virtual time of the last traffic in a simulation
+}
+
+// Input feeds data into the connection as if it had been received from the peer, then decodes
+// the frames out of the inbound bytes and hands them to React.
+func (c *Conn) Input(data []byte) {
+	if !c.opened {
+		return
+	}
+	c.touch()
+	c.inbound = append(c.inbound, data...)
+	if pr, ok := c.h.handler.(gnet.PartialReactor); ok {
+		c.reactN(pr)
+		c.h.runTasks()
+		return
+	}
+	for inFrame := c.decode(); inFrame != nil; inFrame = c.decode() {
+		out, action := c.h.handler.React(inFrame, c)
+		if out != nil {
+			c.h.handler.PreWrite()
+			c.write(out)
+		}
+		c.h.handleAction(c, action)
+		if !c.opened || c.h.shutdown {
+			break
+		}
+	}
+	c.h.runTasks()
+}
+
+// decode decodes the next inbound frame, the malformed input reported by the codec with a *gnet.CodecError
+// is handled by the CodecErrorPolicy of the harness.
+func (c *Conn) decode() []byte {
+	for c.opened {
+		frame, err := c.codec.Decode(c)
+		cerr, ok := err.(*gnet.CodecError)
+		if frame != nil || !ok {
+			return frame
+		}
+		var out []byte
+		policy := c.h.opts.CodecErrorPolicy
+		if policy == gnet.CodecCallback && c.h.opts.CodecErrorHandler != nil {
+			out, policy = c.h.opts.CodecErrorHandler(c, cerr)
+		}
+		if out != nil {
+			c.h.handler.PreWrite()
+			c.write(out)
+		}
+		n := cerr.N
+		if n > len(c.inbound) {
+			n = len(c.inbound)
+		}
+		if n <= 0 || policy != gnet.CodecSkip && policy != gnet.CodecRaw {
+			c.close(cerr)
+			return nil
+		}
+		frame = c.inbound[:n:n]
+		c.ShiftN(n)
+		if policy == gnet.CodecRaw {
+			return frame
+		}
+	}
+	return nil
+}
+
+func (c *Conn) reactN(pr gnet.PartialReactor) {
+	for len(c.inbound) > 0 {
+		n, out, action := pr.ReactN(c.inbound, c)
+		if n > 0 {
+			c.ShiftN(n)
+		}
+		if out != nil {
+			c.h.handler.PreWrite()
+			c.write(out)
+		}
+		c.h.handleAction(c, action)
+		if !c.opened || c.h.shutdown || n <= 0 {
+			break
+		}
+	}
+}
+
+// Output returns the encoded bytes that have been written to the connection since the last call and clears them.
+func (c *Conn) Output() []byte {
+	out := c.outbound
+	c.outbound = nil
+	return out
+}
+
+// PeerClose closes the connection as if the peer had closed it, OnClosed fires with errors.ErrPeerClosed.
+func (c *Conn) PeerClose() {
+	c.close(errors.ErrPeerClosed)
+	c.h.runTasks()
+}
+
+// Closed reports whether the connection has been closed.
+func (c *Conn) Closed() bool {
+	return !c.opened
+}
+
+// Err returns the error which OnClosed fired with.
+func (c *Conn) Err() error {
+	return c.err
+}
+
+func (c *Conn) write(buf []byte) {
+	outFrame, err := c.codec.Encode(c, buf)
+	if err != nil {
+		c.close(err)
+		return
+	}
+	c.outbound = append(c.outbound, outFrame...)
+	c.touch()
+}
+
+// touch records the traffic on the connection for the idle timeout of a simulation.
+func (c *Conn) touch() {
+	if c.h.sim != nil {
+		c.active = c.h.sim.now
+	}
+}
+
+func (c *Conn) close(err error) {
+	if !c.opened {
+		return
+	}
+	c.opened = false
+	c.err = err
+	c.h.handleAction(c, c.h.handler.OnClosed(c, err))
+}
+
+// ================================= Implementation of gnet.Conn =================================
+
+// Read implements gnet.Conn.
+func (c *Conn) Read() []byte {
+	return c.inbound
+}
+
+// ResetBuffer implements gnet.Conn.
+func (c *Conn) ResetBuffer() {
+	c.inbound = c.inbound[:0]
+}
+
+// ReadN implements gnet.Conn.
+func (c *Conn) ReadN(n int) (size int, buf []byte) {
+	if n > len(c.inbound) || n <= 0 {
+		n = len(c.inbound)
+	}
+	return n, c.inbound[:n]
+}
+
+// ShiftN implements gnet.Conn.
+func (c *Conn) ShiftN(n int) (size int) {
+	if n > len(c.inbound) || n <= 0 {
+		size = len(c.inbound)
+		c.ResetBuffer()
+		return
+	}
+	c.inbound = c.inbound[n:]
+	return n
+}
+
+// BufferLength implements gnet.Conn.
+func (c *Conn) BufferLength() int {
+	return len(c.inbound)
+}
+
+// SetReadBufferCap implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetReadBufferCap(_ int) {}
+
+// SetWriteBufferCap implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetWriteBufferCap(_ int) {}
+
+// InboundBuffered implements gnet.Conn.
+func (c *Conn) InboundBuffered() int {
+	return len(c.inbound)
+}
+
+// OutboundBuffered implements gnet.Conn, it is always zero as the outbound bytes are collected right away,
+// except in a Simulation, where it is the number of bytes not taken by Output yet.
+func (c *Conn) OutboundBuffered() int {
+	if c.h.sim != nil {
+		return len(c.outbound)
+	}
+	return 0
+}
+
+// SendTo implements gnet.Conn, buf is collected as it is without being encoded.
+func (c *Conn) SendTo(buf []byte) error {
+	c.outbound = append(c.outbound, buf...)
+	return nil
+}
+
+// QueueTo implements gnet.Conn, buf is collected as it is like SendTo.
+func (c *Conn) QueueTo(buf []byte) error {
+	return c.SendTo(buf)
+}
+
+// TCPInfo implements gnet.Conn, it is not supported by the in-memory transport.
+func (c *Conn) TCPInfo() (*gnet.TCPInfo, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// OriginalDst implements gnet.Conn, it is not supported by the in-memory transport.
+func (c *Conn) OriginalDst() (net.Addr, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// Priority implements gnet.Conn, it returns the QoS class set by SetPriority.
+func (c *Conn) Priority() gnet.Priority {
+	return c.priority
+}
+
+// SetPriority implements gnet.Conn, it only records the QoS class for the in-memory transport.
+func (c *Conn) SetPriority(p gnet.Priority) {
+	c.priority = p
+}
+
+// Tag implements gnet.Conn, it returns the value set by SetTag.
+func (c *Conn) Tag(key string) string {
+	return c.tags[key]
+}
+
+// SetTag implements gnet.Conn, it only records the tag for the in-memory transport.
+func (c *Conn) SetTag(key, value string) {
+	if value == "" {
+		delete(c.tags, key)
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]string)
+	}
+	c.tags[key] = value
+}
+
+// SetMark implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetMark(_ int) error {
+	return nil
+}
+
+// SetNoDelay implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetNoDelay(_ bool) error {
+	return nil
+}
+
+// SetLinger implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetLinger(_ int) error {
+	return nil
+}
+
+// SetKeepAlivePeriod implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetKeepAlivePeriod(_ time.Duration) error {
+	return nil
+}
+
+// SetTOS implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) SetTOS(_ int) error {
+	return nil
+}
+
+// SetQuickAck implements gnet.Conn, it is a no-op for the in-memory transport, which has no ACKs to delay.
+func (c *Conn) SetQuickAck(_ bool) error {
+	return nil
+}
+
+// AsyncWrite implements gnet.Conn, buf is written after the current callback returns and the callbacks are
+// invoked right after that.
+func (c *Conn) AsyncWrite(buf []byte, callbacks ...gnet.AsyncCallback) error {
+	c.h.tasks = append(c.h.tasks, func() {
+		if c.opened {
+			c.write(buf)
+		}
+		var err error
+		if !c.opened {
+			err = errors.ErrConnectionClosed
+		}
+		for _, cb := range callbacks {
+			cb(c, err)
+		}
+	})
+	return nil
+}
+
+// Flush implements gnet.Conn, it is a no-op as the in-memory transport doesn't buffer the outbound data.
+func (c *Conn) Flush() error {
+	return nil
+}
+
+// AsyncFlush implements gnet.Conn, it is a no-op as the in-memory transport doesn't buffer the outbound data.
+func (c *Conn) AsyncFlush() error {
+	return nil
+}
+
+// CloseRead implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) CloseRead() error {
+	return nil
+}
+
+// CloseWrite implements gnet.Conn, it is a no-op for the in-memory transport.
+func (c *Conn) CloseWrite() error {
+	return nil
+}
+
+// Wake implements gnet.Conn, React fires with a nil frame after the current callback returns.
+func (c *Conn) Wake() error {
+	c.h.tasks = append(c.h.tasks, func() {
+		if !c.opened {
+			return
+		}
+		out, action := c.h.handler.React(nil, c)
+		if out != nil {
+			c.write(out)
+		}
+		c.h.handleAction(c, action)
+	})
+	return nil
+}
+
+// Close implements gnet.Conn, the connection is closed after the current callback returns.
+func (c *Conn) Close() error {
+	c.h.tasks = append(c.h.tasks, func() {
+		c.close(nil)
+	})
+	return nil
+}
+
+// CloseNow implements gnet.Conn, the connection is closed ahead of the pending tasks.
+func (c *Conn) CloseNow() error {
+	c.h.tasks = append([]func(){func() {
+		c.close(nil)
+	}}, c.h.tasks...)
+	return nil
+}
+
+// Detach implements gnet.Conn, it is not supported by the in-memory transport.
+func (c *Conn) Detach() (int, []byte, []byte, error) {
+	return -1, nil, nil, errors.ErrUnsupportedOp
+}
+
+// Hijack implements gnet.Conn, it is not supported by the in-memory transport.
+func (c *Conn) Hijack() (net.Conn, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// Context implements gnet.Conn.
+func (c *Conn) Context() interface{} { return c.ctx }
+
+// SetContext implements gnet.Conn.
+func (c *Conn) SetContext(ctx interface{}) { c.ctx = ctx }
+
+// LocalAddr implements gnet.Conn.
+func (c *Conn) LocalAddr() net.Addr { return c.localAddr }
+
+// RemoteAddr implements gnet.Conn.
+func (c *Conn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+var _ gnet.Conn = (*Conn)(nil)
@@ -0,0 +1,319 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/errors"
+)
+
+type echoServer struct {
+	*gnet.EventServer
+	opened, closed int
+	reason         error
+}
+
+func (es *echoServer) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	es.opened++
+	out = []byte("welcome")
+	return
+}
+
+func (es *echoServer) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	es.closed++
+	es.reason = err
+	return
+}
+
+func (es *echoServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	switch string(frame) {
+	case "bye":
+		action = gnet.Close
+		return
+	case "flush":
+		out, action = frame, gnet.CloseAfterFlush
+		return
+	}
+	out = frame
+	_ = c.AsyncWrite([]byte("!"))
+	return
+}
+
+func TestHarness(t *testing.T) {
+	es := &echoServer{EventServer: &gnet.EventServer{}}
+	h := New(es)
+	c := h.Open()
+	if es.opened != 1 || string(c.Output()) != "welcome" {
+		t.Fatalf("unexpected state after opening")
+	}
+	c.Input([]byte("hello"))
+	if out := string(c.Output()); out != "hello!" {
+		t.Fatalf("expected %q, got %q", "hello!", out)
+	}
+	c.Input([]byte("bye"))
+	if !c.Closed() || es.closed != 1 || es.reason != nil {
+		t.Fatalf("expected the connection to be closed by the handler")
+	}
+	c.Input([]byte("ignored"))
+	if out := c.Output(); len(out) != 0 {
+		t.Fatalf("expected no output from a closed connection, got %q", out)
+	}
+
+	c = h.Open()
+	_ = c.Output()
+	c.Input([]byte("flush"))
+	if out := string(c.Output()); !c.Closed() || es.closed != 2 || out != "flush" {
+		t.Fatalf("expected the connection to be closed after flushing %q, got %q", "flush", out)
+	}
+
+	c = h.Open()
+	if err := c.SetQuickAck(true); err != nil {
+		t.Fatalf("expected SetQuickAck to succeed, got %v", err)
+	}
+	c.PeerClose()
+	if es.reason != errors.ErrPeerClosed || c.Err() != errors.ErrPeerClosed {
+		t.Fatalf("expected %v, got %v", errors.ErrPeerClosed, es.reason)
+	}
+}
+
+func TestHarnessWithCodec(t *testing.T) {
+	encoderConfig := gnet.EncoderConfig{
+		ByteOrder:                       binary.BigEndian,
+		LengthFieldLength:               2,
+		LengthIncludesLengthFieldLength: false,
+	}
+	decoderConfig := gnet.DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: 2,
+	}
+	codec := gnet.NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig)
+	es := &echoServer{EventServer: &gnet.EventServer{}}
+	c := New(es, gnet.WithCodec(codec)).Open()
+	_ = c.Output()
+
+	// Feed a frame in two halves to make sure React only fires once the frame is complete.
+	c.Input([]byte{0, 5, 'h', 'e'})
+	if out := c.Output(); len(out) != 0 {
+		t.Fatalf("expected no output for a partial frame, got %q", out)
+	}
+	c.Input([]byte("llo"))
+	expected := []byte{0, 5, 'h', 'e', 'l', 'l', 'o', 0, 1, '!'}
+	if out := c.Output(); !bytes.Equal(out, expected) {
+		t.Fatalf("expected %v, got %v", expected, out)
+	}
+}
+
+type lineServer struct {
+	*gnet.EventServer
+}
+
+func (ls *lineServer) ReactN(buf []byte, c gnet.Conn) (n int, out []byte, action gnet.Action) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return
+	}
+	return i + 1, bytes.ToUpper(buf[:i+1]), gnet.None
+}
+
+func TestHarnessPartialReactor(t *testing.T) {
+	c := New(&lineServer{EventServer: &gnet.EventServer{}}).Open()
+	c.Input([]byte("foo\nba"))
+	if out := string(c.Output()); out != "FOO\n" {
+		t.Fatalf("expected %q, got %q", "FOO\n", out)
+	}
+	c.Input([]byte("r\nbaz\n"))
+	if out := string(c.Output()); out != "BAR\nBAZ\n" {
+		t.Fatalf("expected %q, got %q", "BAR\nBAZ\n", out)
+	}
+	if n := c.BufferLength(); n != 0 {
+		t.Fatalf("expected empty inbound buffer, got %d bytes", n)
+	}
+}
+
+// digitCodec decodes lines of digits and reports the other lines as malformed.
+type digitCodec struct {
+	gnet.LineBasedFrameCodec
+}
+
+func (cc *digitCodec) Decode(c gnet.Conn) ([]byte, error) {
+	buf := c.Read()
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return nil, errors.ErrCRLFNotFound
+	}
+	for _, b := range buf[:i] {
+		if b < '0' || b > '9' {
+			return nil, &gnet.CodecError{Err: fmt.Errorf("not a digit: %q", b), N: i + 1}
+		}
+	}
+	c.ShiftN(i + 1)
+	return buf[:i], nil
+}
+
+func TestHarnessCodecError(t *testing.T) {
+	codec := gnet.WithCodec(&digitCodec{})
+	for _, tc := range []struct {
+		name   string
+		opts   []gnet.Option
+		output string
+		closed bool
+	}{
+		{"close", nil, "1\n", true},
+		{"skip", []gnet.Option{gnet.WithCodecErrorPolicy(gnet.CodecSkip)}, "1\n2\n!\n!\n", false},
+		{"raw", []gnet.Option{gnet.WithCodecErrorPolicy(gnet.CodecRaw)}, "1\nx\n\n2\n!\n!\n!\n", false},
+		{"callback", []gnet.Option{
+			gnet.WithCodecErrorPolicy(gnet.CodecCallback),
+			gnet.WithCodecErrorHandler(func(c gnet.Conn, err *gnet.CodecError) ([]byte, gnet.CodecErrorPolicy) {
+				return []byte("ERR"), gnet.CodecClose
+			}),
+		}, "1\nERR\n", true},
+	} {
+		es := &echoServer{EventServer: &gnet.EventServer{}}
+		c := New(es, append(tc.opts, codec)...).Open()
+		_ = c.Output()
+		c.Input([]byte("1\nx\n2\n"))
+		if out := string(c.Output()); out != tc.output {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.output, out)
+		}
+		if c.Closed() != tc.closed {
+			t.Fatalf("%s: expected the connection closed to be %v", tc.name, tc.closed)
+		}
+		if _, ok := c.Err().(*gnet.CodecError); tc.closed && !ok {
+			t.Fatalf("%s: expected a codec error, got %v", tc.name, c.Err())
+		}
+	}
+}
+
+type simServer struct {
+	*gnet.EventServer
+	trace []string
+	ticks int
+}
+
+func (ss *simServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	ss.trace = append(ss.trace, fmt.Sprintf("%v:%s", c.Context(), frame))
+	out = frame
+	return
+}
+
+func (ss *simServer) Tick() (delay time.Duration, action gnet.Action) {
+	ss.ticks++
+	return 10 * time.Millisecond, gnet.None
+}
+
+func simulate(seed int64) (*simServer, []*Conn, *Simulation) {
+	codec := gnet.NewLengthFieldBasedFrameCodec(
+		gnet.EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		gnet.DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2})
+	ss := &simServer{EventServer: &gnet.EventServer{}}
+	s := NewSimulation(ss, seed, gnet.WithCodec(codec), gnet.WithTicker(true),
+		gnet.WithIdleTimeout(time.Second))
+	conns := make([]*Conn, 3)
+	for i := range conns {
+		conns[i] = s.Open()
+		conns[i].SetContext(i)
+		var stream []byte
+		for j := 0; j < 5; j++ {
+			stream = append(stream, 0, 2, byte('a'+i), byte('0'+j))
+		}
+		s.Send(conns[i], stream, 50*time.Millisecond)
+	}
+	s.Run(100 * time.Millisecond)
+	return ss, conns, s
+}
+
+func TestSimulation(t *testing.T) {
+	start := time.Now()
+	ss, conns, s := simulate(42)
+
+	// The frames of every connection arrive in order, interleaved with the other connections.
+	next := make([]int, len(conns))
+	for _, frame := range ss.trace {
+		var i, j int
+		var a, d byte
+		if _, err := fmt.Sscanf(frame, "%d:%c%c", &i, &a, &d); err != nil {
+			t.Fatal(err)
+		}
+		if j = int(d - '0'); j != next[i] {
+			t.Fatalf("expected frame %d of connection %d, got %d in %v", next[i], i, j, ss.trace)
+		}
+		next[i]++
+	}
+	for i, c := range conns {
+		if next[i] != 5 {
+			t.Fatalf("expected 5 frames of connection %d, got %d", i, next[i])
+		}
+		if n := c.OutboundBuffered(); n != 20 {
+			t.Fatalf("expected 20 bytes buffered for the peer of connection %d, got %d", i, n)
+		}
+	}
+	if ss.ticks != 11 {
+		t.Fatalf("expected 11 ticks in 100ms, got %d", ss.ticks)
+	}
+
+	// The same seed reproduces the same run.
+	if again, _, _ := simulate(42); fmt.Sprint(again.trace) != fmt.Sprint(ss.trace) {
+		t.Fatalf("expected the same trace, got %v and %v", ss.trace, again.trace)
+	}
+
+	// The connections are closed once they have been idle for IdleTimeout on the virtual clock.
+	_ = conns[0].Output()
+	s.After(500*time.Millisecond, func() { conns[0].Input([]byte{0, 2, 'a', '5'}) })
+	s.Run(1300 * time.Millisecond)
+	if conns[0].Closed() || !conns[1].Closed() || conns[1].Err() != errors.ErrIdleTimeout {
+		t.Fatalf("expected only the idle connections closed, got %v and %v", conns[0].Err(), conns[1].Err())
+	}
+	if s.Now() != 1400*time.Millisecond || time.Since(start) > time.Second {
+		t.Fatalf("expected the virtual clock at 1.4s with no real time passing, got %v", s.Now())
+	}
+}
+
+type defaultTickServer struct {
+	*gnet.EventServer
+	ticks int
+}
+
+func (ds *defaultTickServer) Tick() (delay time.Duration, action gnet.Action) {
+	ds.ticks++
+	return ds.EventServer.Tick()
+}
+
+func TestSimulationDefaultTick(t *testing.T) {
+	// The default Tick returns no delay, which must still advance the virtual clock.
+	ds := &defaultTickServer{EventServer: &gnet.EventServer{}}
+	NewSimulation(ds, 1, gnet.WithTicker(true)).Run(time.Second)
+	if ds.ticks != 1001 {
+		t.Fatalf("expected 1001 ticks in 1s, got %d", ds.ticks)
+	}
+
+	ds = &defaultTickServer{EventServer: &gnet.EventServer{}}
+	NewSimulation(ds, 1, gnet.WithTicker(true), gnet.WithTickerResolution(100*time.Millisecond)).Run(time.Second)
+	if ds.ticks != 11 {
+		t.Fatalf("expected 11 ticks in 1s at the resolution of 100ms, got %d", ds.ticks)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2019 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnettest
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+
+	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/errors"
+)
+
+// idleSweeps matches the number of sweeps per IdleTimeout of the real event-loops.
+const idleSweeps = 4
+
+// minTickDelay is the shortest delay between two ticks on the virtual clock, which doesn't advance while ticking,
+// so that a Tick returning no delay doesn't tick forever at the same virtual time.
+const minTickDelay = time.Millisecond
+
+// Simulation runs a Harness on a virtual clock, the inbound data of the connections, the ticks and any other
+// events are scheduled on the clock and run in order on the calling goroutine, so that no real time passes and
+// a run is reproduced exactly by the same seed. The outbound bytes stay buffered until they are taken by
+// Conn.Output, which is how OutboundBuffered reports the backpressure of slow peers.
+type Simulation struct {
+	*Harness
+	rng    *rand.Rand
+	now    time.Duration
+	seq    uint64
+	events eventQueue
+	conns  []*Conn
+
+	idleTimeout    time.Duration
+	tickResolution time.Duration
+}
+
+// NewSimulation creates a Simulation for eventHandler seeded by seed. Besides the codec, it takes the Ticker,
+// NamedTickers, TickerResolution and IdleTimeout in opts into account: the ticks are delivered on the virtual clock,
+// at least TickerResolution or a millisecond apart, and the connections idle for IdleTimeout on it are closed with
+// errors.ErrIdleTimeout. TickerFixedRate makes no difference, since no virtual time passes while ticking.
+func NewSimulation(eventHandler gnet.EventHandler, seed int64, opts ...gnet.Option) *Simulation {
+	options := new(gnet.Options)
+	for _, opt := range opts {
+		opt(options)
+	}
+	s := &Simulation{
+		Harness:        New(eventHandler, opts...),
+		rng:            rand.New(rand.NewSource(seed)),
+		idleTimeout:    options.IdleTimeout,
+		tickResolution: options.TickerResolution,
+	}
+	s.Harness.sim = s
+	if options.Ticker {
+		s.schedule(0, s.tick)
+	}
+	if h, ok := eventHandler.(gnet.NamedTickHandler); ok {
+		for _, t := range options.NamedTickers {
+			s.schedule(0, s.namedTick(h, t))
+		}
+	}
+	if s.idleTimeout > 0 {
+		s.schedule(s.idleTimeout/idleSweeps, s.sweepIdle)
+	}
+	return s
+}
+
+// Now returns the virtual time elapsed since the simulation was created.
+func (s *Simulation) Now() time.Duration {
+	return s.now
+}
+
+// Rand returns the source of randomness of the simulation, which is seeded by the seed of NewSimulation.
+func (s *Simulation) Rand() *rand.Rand {
+	return s.rng
+}
+
+// Open creates a new connection and fires OnOpened for it at the current virtual time.
+func (s *Simulation) Open() *Conn {
+	c := s.Harness.Open()
+	c.active = s.now
+	s.conns = append(s.conns, c)
+	return c
+}
+
+// After schedules f to run after d on the virtual clock, the events due at the same time run in the order
+// they are scheduled.
+func (s *Simulation) After(d time.Duration, f func()) {
+	s.schedule(d, f)
+}
+
+// Send schedules data to arrive on c in random chunks within maxLatency, in order, as a TCP stream would be
+// split by the network. The chunks of the connections sent to concurrently interleave at random.
+func (s *Simulation) Send(c *Conn, data []byte, maxLatency time.Duration) {
+	data = append([]byte(nil), data...)
+	var at time.Duration
+	for len(data) > 0 {
+		n := 1 + s.rng.Intn(len(data))
+		if maxLatency > 0 {
+			if d := time.Duration(s.rng.Int63n(int64(maxLatency) + 1)); d > at {
+				at = d
+			}
+		}
+		chunk := data[:n]
+		s.schedule(at, func() { c.Input(chunk) })
+		data = data[n:]
+	}
+}
+
+// Step runs the next event, it returns false if there is none or the handler has shut the server down.
+func (s *Simulation) Step() bool {
+	if s.events.Len() == 0 || s.shutdown {
+		return false
+	}
+	e := heap.Pop(&s.events).(*event)
+	s.now = e.at
+	e.f()
+	s.runTasks()
+	return true
+}
+
+// Run runs the events due within d on the virtual clock and advances the clock by d, it stops early
+// if the handler shuts the server down.
+func (s *Simulation) Run(d time.Duration) {
+	until := s.now + d
+	for s.events.Len() > 0 && s.events[0].at <= until && s.Step() {
+	}
+	if !s.shutdown {
+		s.now = until
+	}
+}
+
+func (s *Simulation) schedule(d time.Duration, f func()) {
+	s.seq++
+	heap.Push(&s.events, &event{at: s.now + d, seq: s.seq, f: f})
+}
+
+func (s *Simulation) tick() {
+	delay, action := s.handler.Tick()
+	if action == gnet.Shutdown {
+		s.shutdown = true
+	}
+	s.schedule(s.tickDelay(delay), s.tick)
+}
+
+func (s *Simulation) namedTick(h gnet.NamedTickHandler, t gnet.NamedTicker) func() {
+	var f func()
+	f = func() {
+		delay, action := h.NamedTick(t.ID)
+		if action == gnet.Shutdown {
+			s.shutdown = true
+		}
+		if delay <= 0 {
+			delay = t.Interval
+		}
+		s.schedule(s.tickDelay(delay), f)
+	}
+	return f
+}
+
+// tickDelay raises delay to TickerResolution as the real event-loops do, and to minTickDelay.
+func (s *Simulation) tickDelay(delay time.Duration) time.Duration {
+	if delay < s.tickResolution {
+		delay = s.tickResolution
+	}
+	if delay < minTickDelay {
+		delay = minTickDelay
+	}
+	return delay
+}
+
+func (s *Simulation) sweepIdle() {
+	conns := s.conns[:0]
+	for _, c := range s.conns {
+		if !c.opened {
+			continue
+		}
+		if s.now-c.active >= s.idleTimeout {
+			c.close(errors.ErrIdleTimeout)
+			continue
+		}
+		conns = append(conns, c)
+	}
+	s.conns = conns
+	s.schedule(s.idleTimeout/idleSweeps, s.sweepIdle)
+}
+
+type event struct {
+	at  time.Duration
+	seq uint64
+	f   func()
+}
+
+// eventQueue is a min-heap of the events ordered by their due time and then the order they were scheduled.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+// healthCheckResponse is the minimal HTTP response to the health-check requests.
+var healthCheckResponse = []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\nConnection: close\r\n\r\nOK")
+
+// healthCheckHandler serves the health-check listener, it closes the connections right away in the TCP mode,
+// and answers the first request of every connection with healthCheckResponse in the HTTP mode.
+type healthCheckHandler struct {
+	*EventServer
+	http bool
+}
+
+func (hc *healthCheckHandler) OnOpened(c Conn) (out []byte, action Action) {
+	if !hc.http {
+		action = Close
+	}
+	return
+}
+
+func (hc *healthCheckHandler) React(frame []byte, c Conn) (out []byte, action Action) {
+	return healthCheckResponse, Close
+}
+
+// healthCheckRoute returns the route of the health-check listener.
+func healthCheckRoute(opts *Options) Route {
+	return Route{
+		ProtoAddr:    opts.HealthCheckAddr,
+		EventHandler: &healthCheckHandler{EventServer: new(EventServer), http: opts.HealthCheckHTTP},
+		Codec:        new(BuiltInFrameCodec),
+	}
+}
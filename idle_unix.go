@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+const (
+	// idleSweeps is the number of sweeps without traffic after which a connection is closed as idle,
+	// the sweeps run every IdleTimeout/idleSweeps, so the connections are closed within 1.25x IdleTimeout.
+	idleSweeps = 4
+
+	// idleCheckInterval is how often the reaper looks for IdleTimeout to be set while it's disabled.
+	idleCheckInterval = time.Second
+)
+
+// idleReaper sweeps the event-loops for the connections without traffic for IdleTimeout until the server is
+// shut down, IdleTimeout is read from the live options at every round so that it can be updated at runtime.
+func (svr *server) idleReaper() {
+	for {
+		timeout := svr.loadLiveOptions().IdleTimeout
+		interval := idleCheckInterval
+		if timeout > 0 {
+			interval = timeout / idleSweeps
+		}
+		time.Sleep(interval)
+		if svr.isInShutdown() {
+			return
+		}
+		if timeout <= 0 {
+			continue
+		}
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			_ = el.execute(el.sweepIdle)
+			return true
+		})
+	}
+}
+
+// sweepIdle counts a sweep without traffic for every connection on the event-loop and closes the ones that
+// have been idle for idleSweeps sweeps with errors.ErrIdleTimeout.
+func (el *eventloop) sweepIdle() (err error) {
+	if el.svr.loadLiveOptions().IdleTimeout <= 0 {
+		return
+	}
+	el.connections.iterate(func(c *conn) bool {
+		if c.idleSweeps++; c.idleSweeps < idleSweeps {
+			return true
+		}
+		atomic.AddUint64(&el.idleClosed, 1)
+		err = el.loopCloseConn(c, errors.ErrIdleTimeout)
+		return err == nil
+	})
+	return
+}
@@ -47,19 +47,29 @@ var (
 	// DefaultLogger is the default logger inside the gnet server.
 	DefaultLogger Logger
 	zapLogger     *zap.Logger
+	zapLevel      zap.AtomicLevel
 )
 
 func init() {
+	var cfg zap.Config
 	switch strings.ToLower(os.Getenv("GNET_LOGGING_MODE")) {
 	case "prod":
-		zapLogger, _ = zap.NewProduction()
+		cfg = zap.NewProductionConfig()
 	default:
 		// Other values except "Prod" create the development logger for gnet server.
-		zapLogger, _ = zap.NewDevelopment()
+		cfg = zap.NewDevelopmentConfig()
 	}
+	zapLevel = cfg.Level
+	zapLogger, _ = cfg.Build()
 	DefaultLogger = zapLogger.Sugar()
 }
 
+// SetLevel changes the level of the default logger at runtime, e.g. "debug", "info", "warn" or "error",
+// it takes no effect on the customized loggers.
+func SetLevel(level string) error {
+	return zapLevel.UnmarshalText([]byte(level))
+}
+
 // Cleanup does something windup for logger, like closing, flushing, etc.
 func Cleanup() {
 	_ = zapLogger.Sync()
@@ -27,9 +27,9 @@ import (
 	"os"
 	"runtime"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
-	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/logging"
 	"github.com/panjf2000/gnet/internal/netpoll/queue"
 	"golang.org/x/sys/unix"
@@ -37,11 +37,16 @@ import (
 
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
-	fd             int    // epoll fd
-	wfd            int    // wake fd
-	wfdBuf         []byte // wfd buffer to read packet
-	netpollWakeSig int32
-	asyncTaskQueue queue.AsyncTaskQueue
+	rounds          uint64 // see Rounds, keep it first for the 64-bit alignment
+	fd              int    // epoll fd
+	wfd             int    // wake fd
+	wfdBuf          []byte // wfd buffer to read packet
+	netpollWakeSig  int32
+	asyncTaskQueue  queue.AsyncTaskQueue
+	urgentTaskQueue queue.AsyncTaskQueue
+	rotation        int // offset of the first event to dispatch, rotated in every round
+	prioritized     map[int]struct{}
+	busyPoll        time.Duration // how long to keep polling without blocking after the last events, see SetBusyPoll
 }
 
 // OpenPoller instantiates a poller.
@@ -64,7 +69,8 @@ func OpenPoller() (poller *Poller, err error) {
 		poller = nil
 		return
 	}
-	poller.asyncTaskQueue = queue.NewLockFreeQueue()
+	poller.asyncTaskQueue = queue.NewMPSCQueue()
+	poller.urgentTaskQueue = queue.NewMPSCQueue()
 	return
 }
 
@@ -76,6 +82,27 @@ func (p *Poller) Close() error {
 	return os.NewSyscallError("close", unix.Close(p.wfd))
 }
 
+// Reset replaces the epoll instance with a fresh one under the same file-descriptor, which recovers the poller
+// after Polling has failed, the queued tasks are kept whereas all the file-descriptors must be added again.
+// It must be called on the goroutine polling.
+func (p *Poller) Reset() error {
+	nfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return os.NewSyscallError("epoll_create1", err)
+	}
+	err = unix.Dup3(nfd, p.fd, unix.O_CLOEXEC)
+	_ = unix.Close(nfd)
+	if err != nil {
+		return os.NewSyscallError("dup3", err)
+	}
+	if err = p.AddRead(p.wfd); err != nil {
+		return err
+	}
+	// Wake up the fresh instance for the tasks queued in the meantime.
+	atomic.StoreInt32(&p.netpollWakeSig, 0)
+	return p.wakeup()
+}
+
 // Make the endianness of bytes compatible with more linux OSs under different processor-architectures,
 // according to http://man7.org/linux/man-pages/man2/eventfd.2.html.
 var (
@@ -84,8 +111,19 @@ var (
 )
 
 // Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncTaskQueue.
-func (p *Poller) Trigger(task queue.Task) (err error) {
+func (p *Poller) Trigger(task queue.Task) error {
 	p.asyncTaskQueue.Enqueue(task)
+	return p.wakeup()
+}
+
+// UrgentTrigger is like Trigger but the task runs ahead of all the tasks queued by Trigger, it is meant for
+// the control operations like closing connections, which should not be delayed behind a backlog of writes.
+func (p *Poller) UrgentTrigger(task queue.Task) error {
+	p.urgentTaskQueue.Enqueue(task)
+	return p.wakeup()
+}
+
+func (p *Poller) wakeup() (err error) {
 	if atomic.CompareAndSwapInt32(&p.netpollWakeSig, 0, 1) {
 		for _, err = unix.Write(p.wfd, b); err == unix.EINTR || err == unix.EAGAIN; _, err = unix.Write(p.wfd, b) {
 		}
@@ -93,16 +131,31 @@ func (p *Poller) Trigger(task queue.Task) (err error) {
 	return os.NewSyscallError("write", err)
 }
 
+// Rounds returns how many times the poller has started or stopped waiting for events, which is odd while it's
+// waiting and even while it's dispatching events and running tasks, a busy poller that stays at the same number
+// is stuck in one iteration. It's safe to call Rounds from any goroutine.
+func (p *Poller) Rounds() uint64 {
+	return atomic.LoadUint64(&p.rounds)
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling(callback func(fd int, ev uint32) error) error {
+	// Leave the poller in the waiting state when it stops, which is not stuck.
+	defer atomic.AddUint64(&p.rounds, 1)
+
 	el := newEventList(InitEvents)
 	var wakenUp bool
 
+	var active time.Time // when the last events were got, only tracked for busy polling
 	msec := -1
 	for {
+		atomic.AddUint64(&p.rounds, 1)
 		n, err := unix.EpollWait(p.fd, el.events, msec)
+		atomic.AddUint64(&p.rounds, 1)
 		if n == 0 || (n < 0 && err == unix.EINTR) {
-			msec = -1
+			if p.busyPoll <= 0 || time.Since(active) >= p.busyPoll {
+				msec = -1
+			}
 			runtime.Gosched()
 			continue
 		} else if err != nil {
@@ -110,39 +163,47 @@ func (p *Poller) Polling(callback func(fd int, ev uint32) error) error {
 			return err
 		}
 		msec = 0
+		if p.busyPoll > 0 {
+			active = time.Now()
+		}
 
-		for i := 0; i < n; i++ {
-			if fd := int(el.events[i].Fd); fd != p.wfd {
-				switch err = callback(fd, el.events[i].Events); err {
-				case nil:
-				case errors.ErrAcceptSocket, errors.ErrServerShutdown:
-					return err
-				default:
-					logging.DefaultLogger.Warnf("Error occurs in event-loop: %v", err)
+		// Rotate the dispatch order of the fetched events, so that file-descriptors reported at the head of the
+		// event list don't always get served first.
+		p.rotation = (p.rotation + 1) % n
+		// The events of the prioritized file-descriptors are dispatched in a first pass ahead of the others.
+		passes := 1
+		if len(p.prioritized) > 0 {
+			passes = 2
+		}
+		for pass := 0; pass < passes; pass++ {
+			for j, off := 0, p.rotation; j < n; j++ {
+				i := j + off
+				if i >= n {
+					i -= n
+				}
+				if passes > 1 {
+					if _, ok := p.prioritized[int(el.events[i].Fd)]; ok != (pass == 0) {
+						continue
+					}
+				}
+				if fd := int(el.events[i].Fd); fd != p.wfd {
+					if err = p.sniffError(fd, callback(fd, el.events[i].Events)); err != nil {
+						return err
+					}
+				} else {
+					wakenUp = true
+					_, _ = unix.Read(p.wfd, p.wfdBuf)
 				}
-			} else {
-				wakenUp = true
-				_, _ = unix.Read(p.wfd, p.wfdBuf)
 			}
 		}
 
 		if wakenUp {
 			wakenUp = false
-			var task queue.Task
-			for i := 0; i < AsyncTasks; i++ {
-				if task = p.asyncTaskQueue.Dequeue(); task == nil {
-					break
-				}
-				switch err = task(); err {
-				case nil:
-				case errors.ErrServerShutdown:
-					return err
-				default:
-					logging.DefaultLogger.Warnf("Error occurs in user-defined function, %v", err)
-				}
+			if err = p.runTasks(); err != nil {
+				return err
 			}
 			atomic.StoreInt32(&p.netpollWakeSig, 0)
-			if !p.asyncTaskQueue.Empty() {
+			if !p.asyncTaskQueue.Empty() || !p.urgentTaskQueue.Empty() {
 				for _, err = unix.Write(p.wfd, b); err == unix.EINTR || err == unix.EAGAIN; _, err = unix.Write(p.wfd, b) {
 				}
 			}
@@ -192,7 +253,41 @@ func (p *Poller) ModReadWrite(fd int) error {
 		unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents}))
 }
 
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *Poller) ModWrite(fd int) error {
+	return os.NewSyscallError("epoll_ctl mod",
+		unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: writeEvents}))
+}
+
+// ModNone renews the given file-descriptor with neither readable nor writable events in the poller,
+// only the exceptional events of it are reported.
+func (p *Poller) ModNone(fd int) error {
+	return os.NewSyscallError("epoll_ctl mod",
+		unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd)}))
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
+	delete(p.prioritized, fd)
 	return os.NewSyscallError("epoll_ctl del", unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil))
 }
+
+// SetBusyPoll makes the poller keep polling for events without blocking for up to d after the last events it got,
+// before it falls back to blocking, which trades CPU for the latency of waking up. It's disabled if d is not
+// positive and it must be called before the poller starts polling.
+func (p *Poller) SetBusyPoll(d time.Duration) {
+	p.busyPoll = d
+}
+
+// Prioritize marks or unmarks the given file-descriptor as prioritized, the events of prioritized file-descriptors
+// are dispatched ahead of the others in every round, it must be called on the goroutine polling.
+func (p *Poller) Prioritize(fd int, on bool) {
+	if !on {
+		delete(p.prioritized, fd)
+		return
+	}
+	if p.prioritized == nil {
+		p.prioritized = make(map[int]struct{})
+	}
+	p.prioritized[fd] = struct{}{}
+}
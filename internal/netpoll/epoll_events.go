@@ -27,8 +27,6 @@ import "golang.org/x/sys/unix"
 const (
 	// InitEvents represents the initial length of poller event-list.
 	InitEvents = 128
-	// AsyncTasks is the maximum number of asynchronous tasks that the event-loop will process at one time.
-	AsyncTasks = 64
 	// ErrEvents represents exceptional events that are not read/write, like socket being closed,
 	// reading/writing from/to a closed socket, etc.
 	ErrEvents = unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP
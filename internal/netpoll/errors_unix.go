@@ -0,0 +1,49 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package netpoll
+
+import (
+	goerrors "errors"
+
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// sniffError decides what to do with the error of handling the events of fd, the errors that should stop polling
+// are returned and the others are logged. A stale fd, which has been closed behind the poller's back, is removed
+// from the poller, otherwise its events would keep the event-loop busy forever.
+func (p *Poller) sniffError(fd int, err error) error {
+	switch {
+	case err == nil:
+	case err == errors.ErrAcceptSocket, err == errors.ErrServerShutdown:
+		return err
+	case goerrors.Is(err, unix.EBADF):
+		logging.DefaultLogger.Warnf("Removing stale fd=%d from poller: %v", fd, err)
+		_ = p.Delete(fd)
+	default:
+		logging.DefaultLogger.Warnf("Error occurs in event-loop: %v", err)
+	}
+	return nil
+}
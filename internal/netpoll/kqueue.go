@@ -27,6 +27,7 @@ import (
 	"os"
 	"runtime"
 	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/logging"
@@ -36,9 +37,19 @@ import (
 
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
-	fd             int
-	netpollWakeSig int32
-	asyncTaskQueue queue.AsyncTaskQueue
+	rounds          uint64 // see Rounds, keep it first for the 64-bit alignment
+	fd              int
+	netpollWakeSig  int32
+	asyncTaskQueue  queue.AsyncTaskQueue
+	urgentTaskQueue queue.AsyncTaskQueue
+	rotation        int // offset of the first event to dispatch, rotated in every round
+	prioritized     map[int]struct{}
+	busyPoll        time.Duration      // how long to keep polling without blocking after the last events, see SetBusyPoll
+	timers          map[int]queue.Task // tasks of the timers armed by SetTimer, keyed by their idents
+	// changes holds the filter changes made by the Add*, Mod* and Delete methods, which are submitted along with the
+	// next fetch of events in one kevent call, so those methods must be called on the goroutine polling or before it
+	// starts, the other goroutines have to go through Trigger.
+	changes []unix.Kevent_t
 }
 
 // OpenPoller instantiates a poller.
@@ -59,7 +70,8 @@ func OpenPoller() (poller *Poller, err error) {
 		err = os.NewSyscallError("kevent add|clear", err)
 		return
 	}
-	poller.asyncTaskQueue = queue.NewLockFreeQueue()
+	poller.asyncTaskQueue = queue.NewMPSCQueue()
+	poller.urgentTaskQueue = queue.NewMPSCQueue()
 	return
 }
 
@@ -68,6 +80,34 @@ func (p *Poller) Close() error {
 	return os.NewSyscallError("close", unix.Close(p.fd))
 }
 
+// Reset replaces the kqueue with a fresh one under the same file-descriptor, which recovers the poller after
+// Polling has failed, the queued tasks are kept whereas all the file-descriptors must be added again, and
+// the armed timers fire right away since their deadlines are lost. It must be called on the goroutine polling.
+func (p *Poller) Reset() error {
+	nfd, err := unix.Kqueue()
+	if err != nil {
+		return os.NewSyscallError("kqueue", err)
+	}
+	err = unix.Dup2(nfd, p.fd)
+	_ = unix.Close(nfd)
+	if err != nil {
+		return os.NewSyscallError("dup2", err)
+	}
+	unix.CloseOnExec(p.fd)
+	p.changes = append(p.changes[:0], unix.Kevent_t{Ident: 0, Filter: unix.EVFILT_USER, Flags: unix.EV_ADD | unix.EV_CLEAR})
+	for id := range p.timers {
+		p.changes = append(p.changes,
+			unix.Kevent_t{Ident: uint64(id), Flags: unix.EV_ADD | unix.EV_ONESHOT, Filter: unix.EVFILT_TIMER})
+	}
+	if _, err = unix.Kevent(p.fd, p.changes, nil, nil); err != nil {
+		return os.NewSyscallError("kevent add", err)
+	}
+	p.changes = p.changes[:0]
+	// Wake up the fresh kqueue for the tasks queued in the meantime.
+	atomic.StoreInt32(&p.netpollWakeSig, 0)
+	return p.wakeup()
+}
+
 var wakeChanges = []unix.Kevent_t{{
 	Ident:  0,
 	Filter: unix.EVFILT_USER,
@@ -75,8 +115,19 @@ var wakeChanges = []unix.Kevent_t{{
 }}
 
 // Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncTaskQueue.
-func (p *Poller) Trigger(task queue.Task) (err error) {
+func (p *Poller) Trigger(task queue.Task) error {
 	p.asyncTaskQueue.Enqueue(task)
+	return p.wakeup()
+}
+
+// UrgentTrigger is like Trigger but the task runs ahead of all the tasks queued by Trigger, it is meant for
+// the control operations like closing connections, which should not be delayed behind a backlog of writes.
+func (p *Poller) UrgentTrigger(task queue.Task) error {
+	p.urgentTaskQueue.Enqueue(task)
+	return p.wakeup()
+}
+
+func (p *Poller) wakeup() (err error) {
 	if atomic.CompareAndSwapInt32(&p.netpollWakeSig, 0, 1) {
 		for _, err = unix.Kevent(p.fd, wakeChanges, nil, nil); err == unix.EINTR || err == unix.EAGAIN; _, err = unix.Kevent(p.fd, wakeChanges, nil, nil) {
 		}
@@ -84,19 +135,39 @@ func (p *Poller) Trigger(task queue.Task) (err error) {
 	return os.NewSyscallError("kevent trigger", err)
 }
 
+// Rounds returns how many times the poller has started or stopped waiting for events, which is odd while it's
+// waiting and even while it's dispatching events and running tasks, a busy poller that stays at the same number
+// is stuck in one iteration. It's safe to call Rounds from any goroutine.
+func (p *Poller) Rounds() uint64 {
+	return atomic.LoadUint64(&p.rounds)
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling(callback func(fd int, filter int16) error) error {
+	// Leave the poller in the waiting state when it stops, which is not stuck.
+	defer atomic.AddUint64(&p.rounds, 1)
+
 	el := newEventList(InitEvents)
 
 	var (
 		ts      unix.Timespec
 		tsp     *unix.Timespec
 		wakenUp bool
+		active  time.Time // when the last events were got, only tracked for busy polling
 	)
 	for {
-		n, err := unix.Kevent(p.fd, nil, el.events, tsp)
+		// The errors of the changes are reported in the event-list, which must have room for all of them.
+		for len(p.changes) > el.size {
+			el.expand()
+		}
+		atomic.AddUint64(&p.rounds, 1)
+		n, err := unix.Kevent(p.fd, p.changes, el.events, tsp)
+		atomic.AddUint64(&p.rounds, 1)
+		p.changes = p.changes[:0]
 		if n == 0 || (n < 0 && err == unix.EINTR) {
-			tsp = nil
+			if p.busyPoll <= 0 || time.Since(active) >= p.busyPoll {
+				tsp = nil
+			}
 			runtime.Gosched()
 			continue
 		} else if err != nil {
@@ -104,43 +175,58 @@ func (p *Poller) Polling(callback func(fd int, filter int16) error) error {
 			return err
 		}
 		tsp = &ts
+		if p.busyPoll > 0 {
+			active = time.Now()
+		}
 
 		var evFilter int16
-		for i := 0; i < n; i++ {
-			if fd := int(el.events[i].Ident); fd != 0 {
-				evFilter = el.events[i].Filter
-				if (el.events[i].Flags&unix.EV_EOF != 0) || (el.events[i].Flags&unix.EV_ERROR != 0) {
-					evFilter = EVFilterSock
+		// Rotate the dispatch order of the fetched events, so that file-descriptors reported at the head of the
+		// event list don't always get served first.
+		p.rotation = (p.rotation + 1) % n
+		// The events of the prioritized file-descriptors are dispatched in a first pass ahead of the others.
+		passes := 1
+		if len(p.prioritized) > 0 {
+			passes = 2
+		}
+		for pass := 0; pass < passes; pass++ {
+			for j, off := 0, p.rotation; j < n; j++ {
+				i := j + off
+				if i >= n {
+					i -= n
 				}
-				switch err = callback(fd, evFilter); err {
-				case nil:
-				case errors.ErrAcceptSocket, errors.ErrServerShutdown:
-					return err
-				default:
-					logging.DefaultLogger.Warnf("Error occurs in event-loop: %v", err)
+				if passes > 1 {
+					if _, ok := p.prioritized[int(el.events[i].Ident)]; ok != (pass == 0) {
+						continue
+					}
+				}
+				if staleChange(&el.events[i]) {
+					continue
+				}
+				if el.events[i].Filter == unix.EVFILT_TIMER {
+					if err = p.runTimer(int(el.events[i].Ident)); err != nil {
+						return err
+					}
+				} else if fd := int(el.events[i].Ident); fd != 0 {
+					evFilter = el.events[i].Filter
+					if (el.events[i].Flags&unix.EV_EOF != 0) || (el.events[i].Flags&unix.EV_ERROR != 0) {
+						evFilter = EVFilterSock
+					}
+					if err = p.sniffError(fd, callback(fd, evFilter)); err != nil {
+						return err
+					}
+				} else {
+					wakenUp = true
 				}
-			} else {
-				wakenUp = true
 			}
 		}
 
 		if wakenUp {
 			wakenUp = false
-			var task queue.Task
-			for i := 0; i < AsyncTasks; i++ {
-				if task = p.asyncTaskQueue.Dequeue(); task == nil {
-					break
-				}
-				switch err = task(); err {
-				case nil:
-				case errors.ErrServerShutdown:
-					return err
-				default:
-					logging.DefaultLogger.Warnf("Error occurs in user-defined function, %v", err)
-				}
+			if err = p.runTasks(); err != nil {
+				return err
 			}
 			atomic.StoreInt32(&p.netpollWakeSig, 0)
-			if !p.asyncTaskQueue.Empty() {
+			if !p.asyncTaskQueue.Empty() || !p.urgentTaskQueue.Empty() {
 				for _, err = unix.Kevent(p.fd, wakeChanges, nil, nil); err == unix.EINTR || err == unix.EAGAIN; _, err = unix.Kevent(p.fd, wakeChanges, nil, nil) {
 				}
 			}
@@ -154,48 +240,130 @@ func (p *Poller) Polling(callback func(fd int, filter int16) error) error {
 	}
 }
 
-// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
-func (p *Poller) AddReadWrite(fd int) error {
+// SetTimer arms a one-shot EVFILT_TIMER that runs task on the goroutine polling once d has elapsed, arming
+// the timer of the same id again before it fires replaces it. It must be called on the goroutine polling.
+func (p *Poller) SetTimer(id int, d time.Duration, task queue.Task) error {
+	if p.timers == nil {
+		p.timers = make(map[int]queue.Task)
+	}
+	p.timers[id] = task
+	ms := d.Milliseconds()
+	if ms < 0 {
+		ms = 0
+	}
 	_, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ},
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
+		{Ident: uint64(id), Flags: unix.EV_ADD | unix.EV_ONESHOT, Filter: unix.EVFILT_TIMER, Data: ms},
 	}, nil, nil)
+	if err != nil {
+		delete(p.timers, id)
+	}
 	return os.NewSyscallError("kevent add", err)
 }
 
+// runTimer runs the task of the timer that has fired.
+func (p *Poller) runTimer(id int) error {
+	task, ok := p.timers[id]
+	if !ok {
+		return nil
+	}
+	delete(p.timers, id)
+	switch err := task(); err {
+	case nil:
+	case errors.ErrServerShutdown:
+		return err
+	default:
+		logging.DefaultLogger.Warnf("Error occurs in user-defined function, %v", err)
+	}
+	return nil
+}
+
+// staleChange reports whether ev is the error of a change that is no longer relevant, like deleting a filter that
+// was never added or changing the filters of a file-descriptor that has been closed in the meantime.
+func staleChange(ev *unix.Kevent_t) bool {
+	return ev.Flags&unix.EV_ERROR != 0 && (ev.Data == int64(unix.ENOENT) || ev.Data == int64(unix.EBADF))
+}
+
+// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
+func (p *Poller) AddReadWrite(fd int) error {
+	p.changes = append(p.changes,
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ},
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE})
+	return nil
+}
+
 // AddRead registers the given file-descriptor with readable event to the poller.
 func (p *Poller) AddRead(fd int) error {
-	_, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ},
-	}, nil, nil)
-	return os.NewSyscallError("kevent add", err)
+	p.changes = append(p.changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ})
+	return nil
 }
 
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(fd int) error {
-	_, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
-	}, nil, nil)
-	return os.NewSyscallError("kevent add", err)
+	p.changes = append(p.changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE})
+	return nil
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
 func (p *Poller) ModRead(fd int) error {
-	_, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE},
-	}, nil, nil)
-	return os.NewSyscallError("kevent delete", err)
+	p.changes = append(p.changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE})
+	return nil
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
 func (p *Poller) ModReadWrite(fd int) error {
-	_, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
-	}, nil, nil)
-	return os.NewSyscallError("kevent add", err)
+	p.changes = append(p.changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE})
+	return nil
+}
+
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *Poller) ModWrite(fd int) error {
+	p.changes = append(p.changes,
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ})
+	return nil
 }
 
-// Delete removes the given file-descriptor from the poller.
+// ModNone renews the given file-descriptor with neither readable nor writable events in the poller.
+func (p *Poller) ModNone(fd int) error {
+	p.changes = append(p.changes,
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ},
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE})
+	return nil
+}
+
+// Delete removes the given file-descriptor from the poller, it drops the changes of it that haven't been
+// submitted yet and deletes its filters along with the next fetch of events, which matters to the file-descriptors
+// staying open like the detached ones, the errors of deleting the filters of a closed one are ignored.
 func (p *Poller) Delete(fd int) error {
+	delete(p.prioritized, fd)
+	changes := p.changes[:0]
+	for _, ev := range p.changes {
+		if int(ev.Ident) != fd {
+			changes = append(changes, ev)
+		}
+	}
+	p.changes = append(changes,
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ},
+		unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE})
 	return nil
 }
+
+// SetBusyPoll makes the poller keep polling for events without blocking for up to d after the last events it got,
+// before it falls back to blocking, which trades CPU for the latency of waking up. It's disabled if d is not
+// positive and it must be called before the poller starts polling.
+func (p *Poller) SetBusyPoll(d time.Duration) {
+	p.busyPoll = d
+}
+
+// Prioritize marks or unmarks the given file-descriptor as prioritized, the events of prioritized file-descriptors
+// are dispatched ahead of the others in every round, it must be called on the goroutine polling.
+func (p *Poller) Prioritize(fd int, on bool) {
+	if !on {
+		delete(p.prioritized, fd)
+		return
+	}
+	if p.prioritized == nil {
+		p.prioritized = make(map[int]struct{})
+	}
+	p.prioritized[fd] = struct{}{}
+}
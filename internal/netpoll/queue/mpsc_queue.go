@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package queue
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// mpscQueue is an intrusive multi-producer single-consumer queue based on the algorithm presented by
+// Dmitry Vyukov, the producers are wait-free as enqueuing takes one atomic swap, and the single consumer
+// dequeues without any atomic read-modify-write operation, which suits the task queue of an event-loop
+// fed by many goroutines.
+type mpscQueue struct {
+	head unsafe.Pointer // the latest enqueued node, swapped by the producers
+	tail *node          // the stub node that precedes the next node to dequeue, owned by the consumer
+	len  int32
+}
+
+type node struct {
+	value Task
+	next  unsafe.Pointer
+}
+
+// NewMPSCQueue instantiates and returns a mpscQueue.
+func NewMPSCQueue() AsyncTaskQueue {
+	stub := new(node)
+	return &mpscQueue{head: unsafe.Pointer(stub), tail: stub}
+}
+
+// Enqueue puts the given task at the tail of the queue, it is safe to call from multiple goroutines.
+func (q *mpscQueue) Enqueue(task Task) {
+	n := &node{value: task}
+	prev := (*node)(atomic.SwapPointer(&q.head, unsafe.Pointer(n)))
+	atomic.StorePointer(&prev.next, unsafe.Pointer(n))
+	atomic.AddInt32(&q.len, 1)
+}
+
+// Dequeue removes and returns the task at the head of the queue, it must only be called by the consumer.
+// It returns nil if the queue is empty or the next node is still being linked by a producer.
+func (q *mpscQueue) Dequeue() Task {
+	next := load(&q.tail.next)
+	if next == nil {
+		return nil
+	}
+	task := next.value
+	next.value = nil
+	q.tail = next
+	atomic.AddInt32(&q.len, -1)
+	return task
+}
+
+// Empty indicates whether this queue is empty or not.
+func (q *mpscQueue) Empty() bool {
+	return atomic.LoadInt32(&q.len) == 0
+}
+
+// Len returns the number of tasks in this queue.
+func (q *mpscQueue) Len() int {
+	return int(atomic.LoadInt32(&q.len))
+}
+
+func load(p *unsafe.Pointer) (n *node) {
+	return (*node)(atomic.LoadPointer(p))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package queue
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMPSCQueue(t *testing.T) {
+	q := NewMPSCQueue()
+	if !q.Empty() || q.Len() != 0 || q.Dequeue() != nil {
+		t.Fatalf("expect a new queue to be empty")
+	}
+	var got []int
+	for i := 0; i < 3; i++ {
+		i := i
+		q.Enqueue(func() error {
+			got = append(got, i)
+			return nil
+		})
+	}
+	if q.Empty() || q.Len() != 3 {
+		t.Fatalf("expect 3 tasks in the queue, but got %d", q.Len())
+	}
+	for task := q.Dequeue(); task != nil; task = q.Dequeue() {
+		_ = task()
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Fatalf("expect the tasks to be dequeued in order, but got %v", got)
+	}
+	if !q.Empty() || q.Len() != 0 {
+		t.Fatalf("expect the queue to be empty after dequeuing all tasks, but got %d", q.Len())
+	}
+}
+
+func TestMPSCQueueConcurrent(t *testing.T) {
+	const (
+		producers = 8
+		tasks     = 10000
+	)
+	q := NewMPSCQueue()
+	next := make([]int, producers) // the next sequence number expected from every producer
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		p := p
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < tasks; seq++ {
+				seq := seq
+				q.Enqueue(func() error {
+					// The tasks of a producer are dequeued in the order it enqueued them, none is lost or repeated.
+					if next[p] != seq {
+						t.Errorf("expect task %d of producer %d, but got %d", next[p], p, seq)
+					}
+					next[p]++
+					return nil
+				})
+			}
+		}()
+	}
+
+	// The single consumer runs concurrently with the producers, Dequeue returns nil whenever it has caught up.
+	for n := 0; n < producers*tasks; {
+		if task := q.Dequeue(); task != nil {
+			_ = task()
+			n++
+		}
+	}
+	wg.Wait()
+	for p, seq := range next {
+		if seq != tasks {
+			t.Fatalf("expect %d tasks of producer %d, but got %d", tasks, p, seq)
+		}
+	}
+	if !q.Empty() || q.Len() != 0 || q.Dequeue() != nil {
+		t.Fatalf("expect the queue to be empty after dequeuing all tasks, but got %d", q.Len())
+	}
+}
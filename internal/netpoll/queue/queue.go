@@ -18,6 +18,8 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+// Package queue delivers the task queues of the pollers, which are fed by many goroutines and drained by
+// the event-loops.
 package queue
 
 // Task is a asynchronous function.
@@ -28,4 +30,5 @@ type AsyncTaskQueue interface {
 	Enqueue(Task)
 	Dequeue() Task
 	Empty() bool
+	Len() int
 }
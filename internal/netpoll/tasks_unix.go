@@ -0,0 +1,53 @@
+// Copyright (c) 2020 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package netpoll
+
+import (
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/logging"
+)
+
+// runTasks runs all the tasks queued up to this wakeup in one go, so that a storm of tasks triggered from other
+// goroutines costs only one wakeup, the tasks queued by the running ones are left to the next round except for
+// the urgent ones, which always run ahead of the others.
+func (p *Poller) runTasks() error {
+	for i, n := 0, p.asyncTaskQueue.Len(); ; {
+		task := p.urgentTaskQueue.Dequeue()
+		if task == nil {
+			if i == n {
+				return nil
+			}
+			if task = p.asyncTaskQueue.Dequeue(); task == nil {
+				return nil
+			}
+			i++
+		}
+		switch err := task(); err {
+		case nil:
+		case errors.ErrServerShutdown:
+			return err
+		default:
+			logging.DefaultLogger.Warnf("Error occurs in user-defined function, %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package socket
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+	_   [unsafe.Sizeof(uintptr(0)) - 4]byte
+}
+
+// SendMsgs sends bufs to the corresponding addresses in sas with a single sendmmsg(2) call,
+// it returns the number of datagrams that have been sent.
+func SendMsgs(fd int, bufs [][]byte, sas []unix.Sockaddr) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	hdrs := make([]mmsghdr, len(bufs))
+	iovs := make([]unix.Iovec, len(bufs))
+	names := make([]unix.RawSockaddrInet6, len(bufs))
+	for i, buf := range bufs {
+		if len(buf) > 0 {
+			iovs[i].Base = &buf[0]
+		}
+		iovs[i].SetLen(len(buf))
+		hdrs[i].hdr.Iov = &iovs[i]
+		hdrs[i].hdr.SetIovlen(1)
+		namelen, err := putRawSockaddr(sas[i], &names[i])
+		if err != nil {
+			return 0, err
+		}
+		hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		hdrs[i].hdr.Namelen = namelen
+	}
+
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+			uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+// putRawSockaddr converts sa into its raw form in the storage of rsa which is large enough for both IPv4 and IPv6.
+func putRawSockaddr(sa unix.Sockaddr, rsa *unix.RawSockaddrInet6) (uint32, error) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		raw := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		raw.Family = unix.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		raw.Addr = sa.Addr
+		return unix.SizeofSockaddrInet4, nil
+	case *unix.SockaddrInet6:
+		rsa.Family = unix.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&rsa.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		rsa.Addr = sa.Addr
+		rsa.Scope_id = sa.ZoneId
+		return unix.SizeofSockaddrInet6, nil
+	default:
+		return 0, unix.EAFNOSUPPORT
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package socket
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of netfilter, which shares the value with IP6T_SO_ORIGINAL_DST.
+const soOriginalDst = 80
+
+// GetOriginalDst retrieves the destination address of a connection before it was redirected by netfilter,
+// e.g. by the iptables REDIRECT target.
+func GetOriginalDst(fd int) (net.Addr, error) {
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	level := unix.SOL_IP
+	if domain == unix.AF_INET6 {
+		level = unix.SOL_IPV6
+	}
+
+	var rsa unix.RawSockaddrInet6 // large enough for both sockaddr_in and sockaddr_in6
+	size := uint32(unsafe.Sizeof(rsa))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), soOriginalDst,
+		uintptr(unsafe.Pointer(&rsa)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("getsockopt", errno)
+	}
+
+	// The port is in network byte order.
+	port := (*[2]byte)(unsafe.Pointer(&rsa.Port))
+	addr := &net.TCPAddr{Port: int(port[0])<<8 | int(port[1])}
+	if rsa.Family == unix.AF_INET6 {
+		addr.IP = make(net.IP, net.IPv6len)
+		copy(addr.IP, rsa.Addr[:])
+		if rsa.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(rsa.Scope_id)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr, nil
+	}
+	rsa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(&rsa))
+	addr.IP = net.IPv4(rsa4.Addr[0], rsa4.Addr[1], rsa4.Addr[2], rsa4.Addr[3])
+	return addr, nil
+}
+
+// SetRecvOrigDstAddr sets the IP_RECVORIGDSTADDR option on socket, as well as IPV6_RECVORIGDSTADDR for IPv6
+// sockets, so that the original destinations of the datagrams redirected by TPROXY come along with them.
+func SetRecvOrigDstAddr(fd, on int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, on); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if domain != unix.AF_INET6 {
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, on))
+}
+
+// RecvfromOrigDst works like unix.Recvfrom, and returns the original destination of the datagram as well,
+// which is nil unless SetRecvOrigDstAddr is on, oob is the buffer for the control messages.
+func RecvfromOrigDst(fd int, p, oob []byte) (n int, from, dst unix.Sockaddr, err error) {
+	var oobn int
+	if n, oobn, _, from, err = unix.Recvmsg(fd, p, oob, 0); err != nil || oobn == 0 {
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, from, nil, nil
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_ORIGDSTADDR &&
+			len(m.Data) >= unix.SizeofSockaddrInet4:
+			rsa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&m.Data[0]))
+			port := (*[2]byte)(unsafe.Pointer(&rsa.Port))
+			dst = &unix.SockaddrInet4{Port: int(port[0])<<8 | int(port[1]), Addr: rsa.Addr}
+		case m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_ORIGDSTADDR &&
+			len(m.Data) >= unix.SizeofSockaddrInet6:
+			rsa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&m.Data[0]))
+			port := (*[2]byte)(unsafe.Pointer(&rsa.Port))
+			dst = &unix.SockaddrInet6{Port: int(port[0])<<8 | int(port[1]), ZoneId: rsa.Scope_id, Addr: rsa.Addr}
+		}
+	}
+	return
+}
+
+// TransparentUDPSocket opens a non-blocking UDP socket bound to the foreign address src with IP_TRANSPARENT,
+// the datagrams sent through it carry src as their source address, e.g. the replies of a transparent proxy.
+// The socket takes the family of peer, to which src is converted if it's an IPv4 address and peer is not.
+func TransparentUDPSocket(src, peer unix.Sockaddr) (fd int, err error) {
+	family := unix.AF_INET
+	if _, ok := peer.(*unix.SockaddrInet6); ok {
+		family = unix.AF_INET6
+		if sa4, ok := src.(*unix.SockaddrInet4); ok {
+			sa6 := &unix.SockaddrInet6{Port: sa4.Port}
+			sa6.Addr[10], sa6.Addr[11] = 0xff, 0xff
+			copy(sa6.Addr[12:], sa4.Addr[:])
+			src = sa6
+		}
+	}
+	if fd, err = sysSocket(family, unix.SOCK_DGRAM, unix.IPPROTO_UDP); err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = unix.Close(fd)
+			fd = -1
+		}
+	}()
+	if err = SetTransparent(fd, 1); err != nil {
+		return
+	}
+	if err = os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)); err != nil {
+		return
+	}
+	err = os.NewSyscallError("bind", unix.Bind(fd, src))
+	return
+}
@@ -37,7 +37,12 @@ type Option struct {
 
 // TCPSocket calls the internal tcpSocket.
 func TCPSocket(proto, addr string, sockopts ...Option) (int, net.Addr, error) {
-	return tcpSocket(proto, addr, sockopts...)
+	return tcpSocket(proto, addr, false, sockopts...)
+}
+
+// MPTCPSocket calls the internal tcpSocket to create a Multipath TCP socket.
+func MPTCPSocket(proto, addr string, sockopts ...Option) (int, net.Addr, error) {
+	return tcpSocket(proto, addr, true, sockopts...)
 }
 
 // UDPSocket calls the internal udpSocket.
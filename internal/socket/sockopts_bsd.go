@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build freebsd dragonfly darwin
+
+package socket
+
+import (
+	"net"
+
+	"github.com/panjf2000/gnet/errors"
+	"golang.org/x/sys/unix"
+)
+
+// SetQuickAck is only available on Linux.
+func SetQuickAck(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetUserTimeout is a no-op since TCP_USER_TIMEOUT is only available on Linux.
+func SetUserTimeout(_, _ int) error {
+	return nil
+}
+
+// SetMark is only available on Linux.
+func SetMark(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetBusyPoll is only available on Linux.
+func SetBusyPoll(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetBusyPollBudget is only available on Linux.
+func SetBusyPollBudget(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetTransparent is only available on Linux.
+func SetTransparent(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// GetOriginalDst is only available on Linux.
+func GetOriginalDst(_ int) (net.Addr, error) {
+	return nil, errors.ErrUnsupportedOp
+}
+
+// SetRecvOrigDstAddr is only available on Linux.
+func SetRecvOrigDstAddr(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// RecvfromOrigDst is only available on Linux, it works like unix.Recvfrom with no original destination elsewhere.
+func RecvfromOrigDst(fd int, p, _ []byte) (n int, from, dst unix.Sockaddr, err error) {
+	n, from, err = unix.Recvfrom(fd, p, 0)
+	return
+}
+
+// TransparentUDPSocket is only available on Linux.
+func TransparentUDPSocket(_, _ unix.Sockaddr) (int, error) {
+	return -1, errors.ErrUnsupportedOp
+}
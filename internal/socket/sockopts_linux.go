@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetQuickAck enables or disables the TCP_QUICKACK option on socket, which sends the ACKs right away instead of
+// delaying them, the kernel may leave the quick ACK mode by itself afterwards.
+func SetQuickAck(fd, quickAck int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK, quickAck))
+}
+
+// SetUserTimeout sets the TCP_USER_TIMEOUT option on socket, which is the maximum amount of time in milliseconds
+// that transmitted data may remain unacknowledged before the connection is forcibly closed.
+func SetUserTimeout(fd, msecs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, msecs))
+}
+
+// SetMark sets the SO_MARK option on socket, the mark is used by policy routing and packet filtering.
+func SetMark(fd, mark int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, mark))
+}
+
+// SetBusyPoll sets the SO_BUSY_POLL option on socket, which is the time in microseconds to busy poll the device
+// queue for packets when the receive queue of socket is empty, instead of waiting for the interrupts.
+func SetBusyPoll(fd, usecs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL, usecs))
+}
+
+// SetBusyPollBudget sets the SO_BUSY_POLL_BUDGET option on socket, which is the maximum number of packets
+// processed in every round of busy polling, raising it above the default requires the CAP_NET_ADMIN capability.
+func SetBusyPollBudget(fd, budget int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL_BUDGET, budget))
+}
+
+// SetTransparent sets the IP_TRANSPARENT option on socket, as well as IPV6_TRANSPARENT for IPv6 sockets,
+// which allows the socket to bind and accept connections destined to non-local addresses.
+func SetTransparent(fd, transparent int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, transparent); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if domain != unix.AF_INET6 {
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, transparent))
+}
@@ -37,6 +37,31 @@ func SetNoDelay(fd, noDelay int) error {
 	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, noDelay))
 }
 
+// SetLinger sets the behavior of closing the socket with unsent data, the socket waits in the background for
+// the data to be sent when sec is negative, which is the default, discards the data and resets the connection
+// when sec is zero, otherwise lingers for at most sec seconds.
+func SetLinger(fd, sec int) error {
+	var l unix.Linger
+	if sec >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(sec)
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &l))
+}
+
+// SetTOS sets the IP_TOS option on socket, or IPV6_TCLASS for IPv6 sockets, which is the DSCP and ECN field
+// of the outgoing packets.
+func SetTOS(fd, tos int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos))
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos))
+}
+
 // SetRecvBuffer sets the size of the operating system's
 // receive buffer associated with the connection.
 func SetRecvBuffer(fd, size int) error {
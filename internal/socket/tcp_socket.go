@@ -33,6 +33,9 @@ import (
 
 var listenerBacklogMaxSize = maxListenerBacklog()
 
+// ipprotoMPTCP is IPPROTO_MPTCP of Linux, other systems reject it like the Linux kernels without MPTCP support.
+const ipprotoMPTCP = 0x106
+
 func getTCPSockaddr(proto, addr string) (sa unix.Sockaddr, family int, tcpAddr *net.TCPAddr, ipv6only bool, err error) {
 	var tcpVersion string
 
@@ -109,8 +112,9 @@ func determineTCPProto(proto string, addr *net.TCPAddr) (string, error) {
 }
 
 // tcpSocket creates an endpoint for communication and returns a file descriptor that refers to that endpoint.
-// Argument `reusePort` indicates whether the SO_REUSEPORT flag will be assigned.
-func tcpSocket(proto, addr string, sockopts ...Option) (fd int, netAddr net.Addr, err error) {
+// Argument `mptcp` indicates whether to create a Multipath TCP socket, which falls back to TCP when it is
+// not supported by the system.
+func tcpSocket(proto, addr string, mptcp bool, sockopts ...Option) (fd int, netAddr net.Addr, err error) {
 	var (
 		family   int
 		ipv6only bool
@@ -121,7 +125,16 @@ func tcpSocket(proto, addr string, sockopts ...Option) (fd int, netAddr net.Addr
 		return
 	}
 
-	if fd, err = sysSocket(family, unix.SOCK_STREAM, unix.IPPROTO_TCP); err != nil {
+	if mptcp {
+		fd, err = sysSocket(family, unix.SOCK_STREAM, ipprotoMPTCP)
+		switch err {
+		case unix.EPROTONOSUPPORT, unix.EINVAL, unix.ENOPROTOOPT:
+			fd, err = sysSocket(family, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+		}
+	} else {
+		fd, err = sysSocket(family, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	}
+	if err != nil {
 		err = os.NewSyscallError("socket", err)
 		return
 	}
@@ -25,6 +25,7 @@ package gnet
 import (
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -39,6 +40,13 @@ type listener struct {
 	fd            int
 	lnaddr        net.Addr
 	addr, network string
+	eventHandler  EventHandler // event-handler of the route, nil for the default one
+	codec         ICodec       // codec of the route, nil for the default one
+	protocols     []Protocol   // protocols multiplexed on the route
+	mptcp         bool
+	transparent   bool
+	inherited     bool // the socket is handed over by the user instead of being bound by gnet
+	control       func(network, address string, fd int) error
 	sockopts      []socket.Option
 }
 
@@ -46,10 +54,39 @@ func (ln *listener) Dup() (int, string, error) {
 	return netpoll.Dup(ln.fd)
 }
 
+// connLocalAddr returns the local address of the connection accepted from this listener, which is
+// the original destination instead of the listening address when the listener is transparent.
+func (ln *listener) connLocalAddr(nfd int) net.Addr {
+	if !ln.transparent {
+		return ln.lnaddr
+	}
+	lsa, err := unix.Getsockname(nfd)
+	if err != nil {
+		return ln.lnaddr
+	}
+	return socket.SockaddrToTCPOrUnixAddr(lsa)
+}
+
+// controlConn runs Options.SocketControl on the socket of the connection accepted from this listener.
+func (ln *listener) controlConn(nfd int, remoteAddr net.Addr) error {
+	if ln.control == nil {
+		return nil
+	}
+	var address string
+	if remoteAddr != nil {
+		address = remoteAddr.String()
+	}
+	return ln.control(ln.network, address, nfd)
+}
+
 func (ln *listener) normalize() (err error) {
 	switch ln.network {
 	case "tcp", "tcp4", "tcp6":
-		ln.fd, ln.lnaddr, err = socket.TCPSocket(ln.network, ln.addr, ln.sockopts...)
+		if ln.mptcp {
+			ln.fd, ln.lnaddr, err = socket.MPTCPSocket(ln.network, ln.addr, ln.sockopts...)
+		} else {
+			ln.fd, ln.lnaddr, err = socket.TCPSocket(ln.network, ln.addr, ln.sockopts...)
+		}
 		ln.network = "tcp"
 	case "udp", "udp4", "udp6":
 		ln.fd, ln.lnaddr, err = socket.UDPSocket(ln.network, ln.addr, ln.sockopts...)
@@ -69,13 +106,20 @@ func (ln *listener) close() {
 			if ln.fd > 0 {
 				sniffErrorAndLog(os.NewSyscallError("close", unix.Close(ln.fd)))
 			}
-			if ln.network == "unix" {
+			if ln.network == "unix" && !ln.inherited {
 				sniffErrorAndLog(os.RemoveAll(ln.addr))
 			}
 		})
 }
 
 func initListener(network, addr string, options *Options) (l *listener, err error) {
+	if network == "fd" {
+		var fd int
+		if fd, err = strconv.Atoi(addr); err != nil {
+			return
+		}
+		return initListenerFromFd(fd, options)
+	}
 	var sockopts []socket.Option
 	if options.ReusePort {
 		sockopt := socket.Option{SetSockopt: socket.SetReuseport, Opt: 1}
@@ -89,6 +133,10 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockopt := socket.Option{SetSockopt: socket.SetKeepAlive, Opt: int(options.TCPKeepAlive / time.Second)}
 		sockopts = append(sockopts, sockopt)
 	}
+	if network == "tcp" && options.TCPUserTimeout > 0 {
+		sockopt := socket.Option{SetSockopt: socket.SetUserTimeout, Opt: int(options.TCPUserTimeout / time.Millisecond)}
+		sockopts = append(sockopts, sockopt)
+	}
 	if options.SocketRecvBuffer > 0 {
 		sockopt := socket.Option{SetSockopt: socket.SetRecvBuffer, Opt: options.SocketRecvBuffer}
 		sockopts = append(sockopts, sockopt)
@@ -97,7 +145,114 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockopt := socket.Option{SetSockopt: socket.SetSendBuffer, Opt: options.SocketSendBuffer}
 		sockopts = append(sockopts, sockopt)
 	}
-	l = &listener{network: network, addr: addr, sockopts: sockopts}
-	err = l.normalize()
+	if network != "unix" && options.SocketMark > 0 {
+		sockopt := socket.Option{SetSockopt: socket.SetMark, Opt: options.SocketMark}
+		sockopts = append(sockopts, sockopt)
+	}
+	if network != "unix" && options.SocketBusyPoll > 0 {
+		sockopt := socket.Option{SetSockopt: socket.SetBusyPoll, Opt: int(options.SocketBusyPoll / time.Microsecond)}
+		sockopts = append(sockopts, sockopt)
+	}
+	if network != "unix" && options.SocketBusyPollBudget > 0 {
+		sockopt := socket.Option{SetSockopt: socket.SetBusyPollBudget, Opt: options.SocketBusyPollBudget}
+		sockopts = append(sockopts, sockopt)
+	}
+	if network != "unix" && options.Transparent {
+		sockopt := socket.Option{SetSockopt: socket.SetTransparent, Opt: 1}
+		sockopts = append(sockopts, sockopt)
+	}
+	if (network == "udp" || network == "udp4" || network == "udp6") && options.Transparent {
+		// The original destinations are needed to reply from them, see conn.sendTo.
+		sockopt := socket.Option{SetSockopt: socket.SetRecvOrigDstAddr, Opt: 1}
+		sockopts = append(sockopts, sockopt)
+	}
+	if control := options.SocketControl; control != nil {
+		sockopt := socket.Option{SetSockopt: func(fd, _ int) error { return control(network, addr, fd) }}
+		sockopts = append(sockopts, sockopt)
+	}
+	l = &listener{network: network, addr: addr, mptcp: options.MultipathTCP,
+		transparent: options.Transparent, control: options.SocketControl, sockopts: sockopts}
+	err = l.listen(options)
+	return
+}
+
+// listen binds the socket of the listener and attaches the accept filter to it.
+func (ln *listener) listen(options *Options) (err error) {
+	if err = ln.normalize(); err == nil && ln.network == "tcp" && options.AcceptFilter != "" {
+		// The accept filter can only be attached to a socket that is listening already.
+		if err = socket.SetAcceptFilter(ln.fd, options.AcceptFilter); err != nil {
+			ln.close()
+		}
+	}
+	return
+}
+
+// rebind binds a new listener on the address of ln with the same settings, either in place of ln after it has
+// failed and been closed, or alongside it with SO_REUSEPORT.
+func (ln *listener) rebind(options *Options) (*listener, error) {
+	l := &listener{network: ln.network, addr: ln.addr, eventHandler: ln.eventHandler, codec: ln.codec,
+		protocols: ln.protocols, mptcp: ln.mptcp, transparent: ln.transparent, control: ln.control,
+		sockopts: ln.sockopts}
+	if err := l.listen(options); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// filer is implemented by the listeners of the standard library that can duplicate their sockets,
+// e.g. *net.TCPListener, *net.UnixListener and *net.UDPConn.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// initListenerFrom builds a listener on a duplicate of the socket of l, which is either a net.Listener or
+// a net.PacketConn, l stays owned by the caller and can be closed as soon as the server has started.
+func initListenerFrom(l interface{}, options *Options) (*listener, error) {
+	f, ok := l.(filer)
+	if !ok {
+		return nil, errors.ErrUnsupportedListener
+	}
+	file, err := f.File()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fd, err := unix.Dup(int(file.Fd()))
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	return initListenerFromFd(fd, options)
+}
+
+// initListenerFromFd builds a listener on the socket fd which must be bound already, the network
+// is derived from the type of the socket, and fd is owned by the listener from now on.
+func initListenerFromFd(fd int, options *Options) (l *listener, err error) {
+	defer func() {
+		if err != nil {
+			_ = unix.Close(fd)
+		}
+	}()
+	sotype, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	l = &listener{fd: fd, inherited: true, transparent: options.Transparent, control: options.SocketControl}
+	switch sotype {
+	case unix.SOCK_STREAM:
+		l.lnaddr = socket.SockaddrToTCPOrUnixAddr(sa)
+	case unix.SOCK_DGRAM:
+		l.lnaddr = socket.SockaddrToUDPAddr(sa)
+	}
+	if l.lnaddr == nil {
+		return nil, errors.ErrUnsupportedProtocol
+	}
+	l.network, l.addr = l.lnaddr.Network(), l.lnaddr.String()
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(fd, true)); err != nil {
+		return nil, err
+	}
 	return
 }
@@ -21,9 +21,11 @@
 package gnet
 
 import (
+	"context"
 	"net"
 	"os"
 	"sync"
+	"syscall"
 
 	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/netpoll"
@@ -35,27 +37,66 @@ type listener struct {
 	pconn         net.PacketConn
 	lnaddr        net.Addr
 	addr, network string
+	eventHandler  EventHandler // event-handler of the route, nil for the default one
+	codec         ICodec       // codec of the route, nil for the default one
+	protocols     []Protocol   // protocols multiplexed on the route
+	control       func(network, address string, fd int) error
 }
 
 func (ln *listener) Dup() (int, string, error) {
 	return netpoll.Dup(0)
 }
 
+func (ln *listener) listenConfig() *net.ListenConfig {
+	lc := new(net.ListenConfig)
+	if ln.control != nil {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			return rawControl(c, func(fd int) error { return ln.control(network, address, fd) })
+		}
+	}
+	return lc
+}
+
+// controlConn runs Options.SocketControl on the socket of the connection accepted from this listener.
+func (ln *listener) controlConn(conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if ln.control == nil || !ok {
+		return nil
+	}
+	c, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rawControl(c, func(fd int) error { return ln.control(ln.network, conn.RemoteAddr().String(), fd) })
+}
+
+func rawControl(c syscall.RawConn, f func(fd int) error) (err error) {
+	if cerr := c.Control(func(fd uintptr) { err = f(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return
+}
+
 func (ln *listener) normalize() (err error) {
 	switch ln.network {
 	case "unix":
 		sniffErrorAndLog(os.RemoveAll(ln.addr))
 		fallthrough
 	case "tcp", "tcp4", "tcp6":
-		if ln.ln, err = net.Listen(ln.network, ln.addr); err != nil {
+		if ln.ln, err = ln.listenConfig().Listen(context.Background(), ln.network, ln.addr); err != nil {
 			return
 		}
 		ln.lnaddr = ln.ln.Addr()
 	case "udp", "udp4", "udp6":
-		if ln.pconn, err = net.ListenPacket(ln.network, ln.addr); err != nil {
+		if ln.pconn, err = ln.listenConfig().ListenPacket(context.Background(), ln.network, ln.addr); err != nil {
 			return
 		}
 		ln.lnaddr = ln.pconn.LocalAddr()
+	case "pipe":
+		if ln.ln, err = listenPipe(ln.addr); err != nil {
+			return
+		}
+		ln.lnaddr = ln.ln.Addr()
 	default:
 		err = errors.ErrUnsupportedProtocol
 	}
@@ -76,8 +117,24 @@ func (ln *listener) close() {
 	})
 }
 
-func initListener(network, addr string, _ *Options) (l *listener, err error) {
-	l = &listener{network: network, addr: addr}
+func initListener(network, addr string, options *Options) (l *listener, err error) {
+	l = &listener{network: network, addr: addr, control: options.SocketControl}
 	err = l.normalize()
 	return
 }
+
+// initListenerFrom wraps l, which is either a net.Listener or a net.PacketConn, the sockets can't be
+// duplicated on Windows, so l is served directly and closed along with the server.
+func initListenerFrom(l interface{}, options *Options) (*listener, error) {
+	ln := &listener{control: options.SocketControl}
+	switch v := l.(type) {
+	case net.Listener:
+		ln.ln, ln.lnaddr = v, v.Addr()
+	case net.PacketConn:
+		ln.pconn, ln.lnaddr = v, v.LocalAddr()
+	default:
+		return nil, errors.ErrUnsupportedListener
+	}
+	ln.network, ln.addr = ln.lnaddr.Network(), ln.lnaddr.String()
+	return ln, nil
+}
@@ -23,6 +23,7 @@ package gnet
 import (
 	"hash/crc32"
 	"net"
+	"sync/atomic"
 
 	"github.com/panjf2000/gnet/internal"
 )
@@ -53,7 +54,7 @@ type (
 
 	// roundRobinLoadBalancer with Round-Robin algorithm.
 	roundRobinLoadBalancer struct {
-		nextLoopIndex int
+		nextLoopIndex uint32
 		eventLoops    []*eventloop
 		size          int
 	}
@@ -79,13 +80,11 @@ func (lb *roundRobinLoadBalancer) register(el *eventloop) {
 	lb.size++
 }
 
-// next returns the eligible event-loop based on Round-Robin algorithm.
+// next returns the eligible event-loop based on Round-Robin algorithm, it can be called concurrently
+// since the imported connections are registered by the goroutines of the callers.
 func (lb *roundRobinLoadBalancer) next(_ net.Addr) (el *eventloop) {
-	el = lb.eventLoops[lb.nextLoopIndex]
-	if lb.nextLoopIndex++; lb.nextLoopIndex >= lb.size {
-		lb.nextLoopIndex = 0
-	}
-	return
+	idx := atomic.AddUint32(&lb.nextLoopIndex, 1) - 1
+	return lb.eventLoops[idx%uint32(lb.size)]
 }
 
 func (lb *roundRobinLoadBalancer) iterate(f func(int, *eventloop) bool) {
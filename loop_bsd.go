@@ -22,13 +22,16 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
+import (
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/netpoll"
+)
 
 func (el *eventloop) handleEvent(fd int, filter int16) (err error) {
-	if c, ok := el.connections[fd]; ok {
+	if c := el.connections.get(fd); c != nil {
 		switch filter {
 		case netpoll.EVFilterSock:
-			err = el.loopCloseConn(c, nil)
+			err = el.loopEOF(c)
 		case netpoll.EVFilterWrite:
 			err = el.loopWrite(c)
 		case netpoll.EVFilterRead:
@@ -36,5 +39,59 @@ func (el *eventloop) handleEvent(fd int, filter int16) (err error) {
 		}
 		return
 	}
+	if filter == netpoll.EVFilterWrite {
+		return el.loopWriteUDP(fd)
+	}
 	return el.loopAccept(fd)
 }
+
+// loopEOF handles the EOF reported by kqueue, which means the peer has shut down the writing side of the
+// connection at least, the rest of the inbound data is read before the half-close is handled if the event handler
+// implements HalfCloseHandler, otherwise the connection is closed right away.
+func (el *eventloop) loopEOF(c *conn) error {
+	if _, ok := c.eventHandler.(HalfCloseHandler); ok && !c.readClosed {
+		return el.loopRead(c)
+	}
+	return el.loopCloseConn(c, errors.ErrPeerClosed)
+}
+
+// watchListener puts the listener unwatched by ModNone back to the poller, which has removed its filters.
+func (el *eventloop) watchListener(fd int) error {
+	return el.poller.AddRead(fd)
+}
+
+// tickerTimer is the ident of the kqueue timer that drives EventHandler.Tick, the timers of the named tickers
+// follow it.
+const tickerTimer = 1
+
+// loopTicker drives EventHandler.Tick with a kqueue timer armed on the event-loop, which is more accurate than
+// sleeping in another goroutine and saves the round trips through the ticktock channel.
+func (el *eventloop) loopTicker() {
+	sched := newTickSchedule(el.svr.opts)
+	var tick func() error
+	tick = func() error {
+		delay, action := el.eventHandler.Tick()
+		if action == Shutdown {
+			return errors.ErrServerShutdown
+		}
+		return el.poller.SetTimer(tickerTimer, sched.next(delay), tick)
+	}
+	if err := el.poller.UrgentTrigger(tick); err != nil {
+		el.svr.logger.Errorf("Failed to awake poller in event-loop(%d), error:%v, stopping ticker", el.idx, err)
+	}
+}
+
+func (el *eventloop) loopNamedTicker(idx int, t NamedTicker, h NamedTickHandler) {
+	sched := newTickSchedule(el.svr.opts)
+	var tick func() error
+	tick = func() error {
+		delay, action := h.NamedTick(t.ID)
+		if action == Shutdown {
+			return errors.ErrServerShutdown
+		}
+		return el.poller.SetTimer(tickerTimer+1+idx, sched.next(intervalOf(t, delay)), tick)
+	}
+	if err := el.poller.UrgentTrigger(tick); err != nil {
+		el.svr.logger.Errorf("Failed to awake poller in event-loop(%d), error:%v, stopping ticker %s", el.idx, err, t.ID)
+	}
+}
@@ -20,10 +20,15 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+	"github.com/panjf2000/gnet/internal/netpoll"
+)
 
 func (el *eventloop) handleEvent(fd int, ev uint32) error {
-	if c, ok := el.connections[fd]; ok {
+	if c := el.connections.get(fd); c != nil {
 		// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 		// sure what you're doing!
 		// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
@@ -52,5 +57,79 @@ func (el *eventloop) handleEvent(fd int, ev uint32) error {
 		}
 		return nil
 	}
-	return el.loopAccept(fd)
+
+	// Writable events of listeners come from the UDP sockets with queued datagrams.
+	if ev&netpoll.OutEvents != 0 {
+		if err := el.loopWriteUDP(fd); err != nil {
+			return err
+		}
+	}
+	if ev&netpoll.InEvents != 0 {
+		return el.loopAccept(fd)
+	}
+	return nil
+}
+
+// watchListener puts the listener unwatched by ModNone back to the poller, which keeps it registered in epoll.
+func (el *eventloop) watchListener(fd int) error {
+	return el.poller.ModRead(fd)
+}
+
+func (el *eventloop) loopTicker() {
+	var (
+		sched = newTickSchedule(el.svr.opts)
+		delay time.Duration
+		open  bool
+		err   error
+	)
+	for {
+		err = el.poller.UrgentTrigger(func() (err error) {
+			delay, action := el.eventHandler.Tick()
+			el.svr.ticktock <- delay
+			switch action {
+			case None:
+			case Shutdown:
+				err = errors.ErrServerShutdown
+			}
+			return
+		})
+		if err != nil {
+			el.svr.logger.Errorf("Failed to awake poller in event-loop(%d), error:%v, stopping ticker", el.idx, err)
+			break
+		}
+		if delay, open = <-el.svr.ticktock; open {
+			time.Sleep(sched.next(delay))
+		} else {
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopNamedTicker(idx int, t NamedTicker, h NamedTickHandler) {
+	var (
+		sched    = newTickSchedule(el.svr.opts)
+		ticktock = el.svr.tickers[idx]
+		delay    time.Duration
+		open     bool
+		err      error
+	)
+	for {
+		err = el.poller.UrgentTrigger(func() (err error) {
+			delay, action := h.NamedTick(t.ID)
+			ticktock <- intervalOf(t, delay)
+			if action == Shutdown {
+				err = errors.ErrServerShutdown
+			}
+			return
+		})
+		if err != nil {
+			el.svr.logger.Errorf("Failed to awake poller in event-loop(%d), error:%v, stopping ticker %s", el.idx, err, t.ID)
+			break
+		}
+		if delay, open = <-ticktock; open {
+			time.Sleep(sched.next(delay))
+		} else {
+			break
+		}
+	}
 }
@@ -0,0 +1,156 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// memoryCheckInterval is the interval of checking the buffers of connections against Options.MemoryLimit.
+const memoryCheckInterval = 100 * time.Millisecond
+
+// memoryGuard enforces the memory limit with the given policy until the server is shut down.
+func (svr *server) memoryGuard(limit uint64, policy MemoryPolicy) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	s := Server{svr: svr}
+	paused := false // whether accepting is paused by the guard rather than the user
+	for range ticker.C {
+		if svr.isInShutdown() {
+			return
+		}
+		ms := s.MemoryStats()
+		used := ms.Inbound + ms.Outbound
+		switch policy {
+		case MemoryPauseAccept:
+			if !paused && used > limit && !svr.isAcceptPaused() {
+				svr.logger.Warnf("Buffers of connections take %d bytes over the limit %d, pausing accepting", used, limit)
+				paused = svr.pauseAccept() == nil
+			} else if paused && used < limit/10*9 {
+				svr.logger.Infof("Buffers of connections take %d bytes under the limit %d, resuming accepting", used, limit)
+				paused = svr.resumeAccept() != nil
+			}
+		case MemoryRejectFrames:
+			if used > limit {
+				var conns int
+				for _, st := range ms.Loops {
+					conns += st.Connections
+				}
+				if conns > 0 {
+					svr.rejectFrames(limit / uint64(conns))
+				}
+			}
+		case MemoryCloseHungry:
+			if used > limit {
+				svr.closeHungry(used - limit)
+			}
+		}
+	}
+}
+
+// rejectFrames discards the inbound data buffered by the connections over share bytes, which are the partial frames
+// too large to be buffered, and releases their inbound buffers while keeping the connections open.
+func (svr *server) rejectFrames(share uint64) {
+	svr.lb.iterate(func(i int, el *eventloop) bool {
+		_ = el.execute(func() error {
+			el.connections.iterate(func(c *conn) bool {
+				if n := c.inboundBuffer.Length(); uint64(n) > share {
+					c.ResetBuffer()
+					c.inboundBuffer.Resize(0)
+					el.svr.logger.Warnf("Rejected the partial frame of %d bytes from %v over the memory limit", n, c.remoteAddr)
+				}
+				return true
+			})
+			return nil
+		})
+		return true
+	})
+}
+
+// connMemory is the size of the buffers of a connection.
+type connMemory struct {
+	el   *eventloop
+	c    *conn
+	gen  uint32
+	size uint64
+}
+
+// closeHungry closes the connections with the largest buffers until at least excess bytes are released.
+func (svr *server) closeHungry(excess uint64) {
+	var (
+		conns = make(chan []connMemory, svr.lb.len())
+		n     int
+	)
+	svr.lb.iterate(func(i int, el *eventloop) bool {
+		if el.execute(func() error {
+			var list []connMemory
+			el.connections.iterate(func(c *conn) bool {
+				size := uint64(c.inboundBuffer.Cap() + c.outboundBuffer.Cap())
+				list = append(list, connMemory{el, c, atomic.LoadUint32(&c.gen), size})
+				return true
+			})
+			conns <- list
+			return nil
+		}) == nil {
+			n++
+		}
+		return true
+	})
+
+	var all []connMemory
+	timeout := time.After(adminDumpTimeout)
+collect:
+	for ; n > 0; n-- {
+		select {
+		case list := <-conns:
+			all = append(all, list...)
+		case <-timeout:
+			break collect
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].size > all[j].size })
+
+	for _, cm := range all {
+		if cm.size == 0 {
+			break
+		}
+		cm := cm
+		// The connection may have been closed, and its object recycled, by the time it's picked.
+		_ = cm.el.execute(func() error {
+			if atomic.LoadUint32(&cm.c.gen) != cm.gen {
+				return nil
+			}
+			return cm.el.loopCloseConn(cm.c, errors.ErrBufferOverflow)
+		})
+		if cm.size >= excess {
+			break
+		}
+		excess -= cm.size
+	}
+}
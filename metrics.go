@@ -0,0 +1,317 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/internal/logging"
+	"github.com/panjf2000/gnet/pool/slab"
+)
+
+// numBuckets is the number of latency buckets, the upper bound of the bucket i is 2^i microseconds,
+// the last bucket holds everything that exceeds the others, which is about 17 minutes.
+const numBuckets = 31
+
+// Histogram is a snapshot of the latency distribution of a kind of event.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, the last one is the maximum of time.Duration.
+	Bounds []time.Duration
+
+	// Counts are the number of observations in each bucket.
+	Counts []uint64
+
+	// Count is the total number of observations.
+	Count uint64
+
+	// Sum is the total duration of all observations.
+	Sum time.Duration
+}
+
+// Mean returns the average latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket where the q-quantile (0 <= q <= 1) falls in.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, n := range h.Counts {
+		if cum += n; cum >= rank {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+func (h *Histogram) merge(o *histogram) {
+	for i := range o.counts {
+		h.Counts[i] += atomic.LoadUint64(&o.counts[i])
+	}
+	h.Count += atomic.LoadUint64(&o.count)
+	h.Sum += time.Duration(atomic.LoadUint64(&o.sum))
+}
+
+func newHistogram() Histogram {
+	h := Histogram{Bounds: make([]time.Duration, numBuckets), Counts: make([]uint64, numBuckets)}
+	for i := 0; i < numBuckets-1; i++ {
+		h.Bounds[i] = time.Microsecond << uint(i)
+	}
+	h.Bounds[numBuckets-1] = 1<<63 - 1
+	return h
+}
+
+// Metrics is a snapshot of the latency metrics of the server, all event-loops are aggregated.
+type Metrics struct {
+	// React is the latency of the React callbacks.
+	React Histogram
+
+	// Write is the latency of writing the outbound data to the sockets.
+	Write Histogram
+
+	// IdleClosed is the number of connections closed for exceeding Options.IdleTimeout.
+	IdleClosed uint64
+}
+
+// histogram is written by a single event-loop and read concurrently by the snapshots.
+type histogram struct {
+	counts [numBuckets]uint64
+	count  uint64
+	sum    uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for us := uint64(d / time.Microsecond); us > 1<<uint(i) && i < numBuckets-1; i++ {
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+// loopMetrics is the latency metrics of an event-loop, it is nil when neither the latency metrics nor
+// the slow React detection is enabled and all the methods are no-op in that case.
+type loopMetrics struct {
+	react histogram
+	write histogram
+
+	latency     bool
+	slowReact   time.Duration
+	onSlowReact func(c Conn, elapsed time.Duration)
+	logger      logging.Logger
+}
+
+func newLoopMetrics(svr *server) *loopMetrics {
+	opts := svr.opts
+	if !opts.LatencyMetrics && opts.SlowReactThreshold <= 0 {
+		return nil
+	}
+	return &loopMetrics{
+		latency:     opts.LatencyMetrics,
+		slowReact:   opts.SlowReactThreshold,
+		onSlowReact: opts.SlowReactHandler,
+		logger:      svr.logger,
+	}
+}
+
+func (m *loopMetrics) now() (t time.Time) {
+	if m != nil {
+		t = time.Now()
+	}
+	return
+}
+
+func (m *loopMetrics) observeReact(c Conn, start time.Time) {
+	if m == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	if m.latency {
+		m.react.observe(elapsed)
+	}
+	if m.slowReact > 0 && elapsed > m.slowReact {
+		if m.onSlowReact != nil {
+			m.onSlowReact(c, elapsed)
+		} else {
+			m.logger.Warnf("React on connection %v -> %v took %v, exceeding the threshold %v",
+				c.RemoteAddr(), c.LocalAddr(), elapsed, m.slowReact)
+		}
+	}
+}
+
+func (m *loopMetrics) observeWrite(start time.Time) {
+	if m != nil && m.latency {
+		m.write.observe(time.Since(start))
+	}
+}
+
+// Metrics returns a snapshot of the metrics of all event-loops, the histograms are empty unless the server
+// is started with WithLatencyMetrics(true).
+func (s Server) Metrics() Metrics {
+	m := Metrics{React: newHistogram(), Write: newHistogram()}
+	if !s.svr.isStarted() {
+		return m
+	}
+	s.svr.lb.iterate(func(i int, el *eventloop) bool {
+		m.IdleClosed += el.countIdleClosed()
+		if el.metrics != nil {
+			m.React.merge(&el.metrics.react)
+			m.Write.merge(&el.metrics.write)
+		}
+		return true
+	})
+	return m
+}
+
+// LoopMemoryStats is the buffer memory of the connections on an event-loop.
+type LoopMemoryStats struct {
+	// Loop is the index of the event-loop.
+	Loop int `json:"loop"`
+
+	// Connections is the number of connections on the event-loop.
+	Connections int `json:"connections"`
+
+	// Inbound is the total capacity of the inbound buffers in bytes.
+	Inbound uint64 `json:"inbound"`
+
+	// Outbound is the total capacity of the outbound buffers in bytes.
+	Outbound uint64 `json:"outbound"`
+}
+
+// MemoryStats is a snapshot of the buffer memory of the server.
+type MemoryStats struct {
+	// Loops are the statistics of the event-loops that have reported in time.
+	Loops []LoopMemoryStats `json:"loops"`
+
+	// Inbound is the total capacity of the inbound buffers of all event-loops in bytes.
+	Inbound uint64 `json:"inbound"`
+
+	// Outbound is the total capacity of the outbound buffers of all event-loops in bytes.
+	Outbound uint64 `json:"outbound"`
+
+	// Free is the number of bytes held for reuse by the slab allocator of WithSlabBuffers, the ones pooled
+	// by sync.Pool can't be measured and are not included.
+	Free uint64 `json:"free"`
+}
+
+// MemoryStats returns the buffer memory of the connections on every event-loop and the server-wide totals.
+// It waits for the event-loops to report, so it must not be called within the event handlers.
+func (s Server) MemoryStats() MemoryStats {
+	var (
+		mu sync.Mutex
+		ms = MemoryStats{Loops: make([]LoopMemoryStats, 0, s.svr.lb.len())}
+	)
+	s.svr.onEachLoop(func(el *eventloop) {
+		st := el.memoryStats()
+		mu.Lock()
+		ms.Loops = append(ms.Loops, st)
+		mu.Unlock()
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	loops := make([]LoopMemoryStats, len(ms.Loops))
+	copy(loops, ms.Loops)
+	sort.Slice(loops, func(i, j int) bool { return loops[i].Loop < loops[j].Loop })
+	ms.Loops = loops
+	for _, st := range ms.Loops {
+		ms.Inbound += st.Inbound
+		ms.Outbound += st.Outbound
+	}
+	if s.svr.opts.SlabBuffers {
+		ms.Free = uint64(slab.Default.Cached())
+	}
+	return ms
+}
+
+// TagStats returns the traffic of the connections tagged by Conn.SetTag with key on all event-loops, indexed by
+// the tag values. It waits for the event-loops to report, so it must not be called within the event handlers.
+// Like the other statistics, it's empty until the server has started serving.
+func (s Server) TagStats(key string) map[string]TagStats {
+	var (
+		mu    sync.Mutex
+		stats = make(map[string]TagStats)
+	)
+	s.svr.onEachLoop(func(el *eventloop) {
+		loop := el.tags.stats(key)
+		mu.Lock()
+		for value, st := range loop {
+			sum := stats[value]
+			sum.Connections += st.Connections
+			sum.BytesIn += st.BytesIn
+			sum.BytesOut += st.BytesOut
+			stats[value] = sum
+		}
+		mu.Unlock()
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]TagStats, len(stats))
+	for value, st := range stats {
+		snapshot[value] = st
+	}
+	return snapshot
+}
+
+// onEachLoop runs f on every event-loop and waits for them up to adminDumpTimeout, the event-loops that
+// don't make it in time still run f later.
+func (svr *server) onEachLoop(f func(el *eventloop)) {
+	if !svr.isStarted() {
+		return
+	}
+	var wg sync.WaitGroup
+	svr.lb.iterate(func(i int, el *eventloop) bool {
+		wg.Add(1)
+		err := el.execute(func() error {
+			f(el)
+			wg.Done()
+			return nil
+		})
+		if err != nil {
+			wg.Done()
+		}
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(adminDumpTimeout):
+	}
+}
@@ -21,8 +21,10 @@
 package gnet
 
 import (
+	"net"
 	"time"
 
+	"github.com/panjf2000/gnet/errors"
 	"github.com/panjf2000/gnet/internal/logging"
 )
 
@@ -46,6 +48,114 @@ const (
 	TCPDelay
 )
 
+// UDPDropPolicy is the policy of dropping datagrams when the send queue of a UDP socket is full.
+type UDPDropPolicy int
+
+// Available policies of dropping datagrams.
+const (
+	// DropNewest drops the datagram that is being sent.
+	DropNewest UDPDropPolicy = iota
+	// DropOldest drops the datagram at the head of the queue to make room for the one that is being sent.
+	DropOldest
+)
+
+// AcceptErrorPolicy is the policy of handling the transient errors of accepting connections, which are
+// ECONNABORTED, EMFILE, ENFILE, ENOBUFS and ENOMEM.
+type AcceptErrorPolicy int
+
+// Available policies of handling accept errors.
+const (
+	// AcceptRetry keeps accepting connections, the listener is left out of the poller for a backoff that doubles
+	// from 5ms up to 1s on every consecutive error except ECONNABORTED, which is retried right away.
+	AcceptRetry AcceptErrorPolicy = iota
+	// AcceptCallback hands the error over to Options.AcceptErrorHandler, the server is shut down if it returns
+	// Shutdown, otherwise it keeps accepting as AcceptRetry does.
+	AcceptCallback
+	// AcceptShutdown shuts down the server on the first error.
+	AcceptShutdown
+)
+
+// CodecErrorPolicy is the policy of handling the malformed input reported by a codec with a *CodecError.
+type CodecErrorPolicy int
+
+// Available policies of handling codec errors.
+const (
+	// CodecClose closes the connection with the *CodecError as the reason.
+	CodecClose CodecErrorPolicy = iota
+	// CodecSkip discards the malformed bytes and goes on decoding the rest of the inbound data.
+	CodecSkip
+	// CodecRaw hands the malformed bytes over to React as a frame of their own.
+	CodecRaw
+	// CodecCallback hands the error over to Options.CodecErrorHandler, which returns the error frame to write back,
+	// if any, and one of the other policies to apply, it works like CodecClose without a handler.
+	CodecCallback
+)
+
+// MemoryPolicy is the policy of shedding load when the buffers of connections exceed Options.MemoryLimit.
+type MemoryPolicy int
+
+// Available policies of shedding load.
+const (
+	// MemoryPauseAccept stops accepting connections until the buffers fall below 90% of the limit.
+	MemoryPauseAccept MemoryPolicy = iota
+	// MemoryRejectFrames discards the oversized frames in the middle of being buffered, which are the inbound data
+	// buffered by the connections over their fair share of the limit, and keeps the connections open. The rest of
+	// a rejected frame is decoded as it arrives, so the codec should report it as malformed input.
+	MemoryRejectFrames
+	// MemoryCloseHungry closes the connections holding the largest buffers with errors.ErrBufferOverflow until
+	// the buffers are under the limit.
+	MemoryCloseHungry
+)
+
+// NamedTicker is a ticker registered by its ID and interval.
+type NamedTicker struct {
+	ID       string
+	Interval time.Duration
+}
+
+// AccessLogEntry describes a closed connection for the access log.
+type AccessLogEntry struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+
+	// Duration is how long the connection has been open.
+	Duration time.Duration
+
+	// BytesIn and BytesOut are the number of bytes received and sent on the connection.
+	BytesIn, BytesOut uint64
+
+	// Frames is the number of inbound frames handed over to React, or of the ReactN calls consuming data.
+	Frames uint64
+
+	// Tags are the tags set by Conn.SetTag when the connection was closed.
+	Tags map[string]string
+
+	// Err is the reason the connection was closed for, which is also passed to OnClosed.
+	Err error
+}
+
+// FaultInjection is the network misbehavior injected into the reads and writes of every TCP connection
+// for validating the resilience of protocols, the probabilities are between 0 and 1 and applied to every
+// chunk of data read from a connection or every frame written to it.
+type FaultInjection struct {
+	// Delay is the probability of holding the data back for a random duration up to MaxDelay, the data following
+	// it is held back along with it, so the stream is delayed but never reordered.
+	Delay    float64
+	MaxDelay time.Duration
+
+	// Truncate is the probability of cutting off a random tail of the data.
+	Truncate float64
+
+	// Duplicate is the probability of delivering or sending the data twice.
+	Duplicate float64
+
+	// Drop is the probability of discarding the data.
+	Drop float64
+
+	// Seed seeds the random faults of the connections to make them reproducible, it's random if zero.
+	Seed int64
+}
+
 // Options are set when the client opens.
 type Options struct {
 	// Multicore indicates whether the server will be effectively created with multi-cores, if so,
@@ -70,6 +180,10 @@ type Options struct {
 	// LB represents the load-balancing algorithm used when assigning new connections.
 	LB LoadBalancing
 
+	// LowPriorityReadBufferCap is the maximum number of bytes that can be read from a connection of PriorityLow
+	// when the readable event comes, it defaults to a quarter of ReadBufferCap.
+	LowPriorityReadBufferCap int
+
 	// NumEventLoop is set up to start the given number of event-loop goroutine.
 	// Note: Setting up NumEventLoop will override Multicore.
 	NumEventLoop int
@@ -77,12 +191,119 @@ type Options struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// ReusePortCPUSteering indicates whether to steer the incoming connections and datagrams to the listener owned by
+	// the event-loop that is pinned to the receiving CPU when ReusePort is enabled, which keeps packets on the same CPU
+	// end to end. It works best when the number of event-loops equals the number of CPUs, it is only available on
+	// Linux and takes no effect elsewhere.
+	ReusePortCPUSteering bool
+
 	// Ticker indicates whether the ticker has been set up.
 	Ticker bool
 
+	// TickerFixedRate schedules each tick the delay returned by Tick after the previous tick was due rather than
+	// after Tick returned, so that the ticks don't drift by the time spent on ticking.
+	TickerFixedRate bool
+
+	// NamedTickers are the tickers delivered to NamedTickHandler.NamedTick by their IDs, they take no effect
+	// if the EventHandler doesn't implement NamedTickHandler. TickerFixedRate and TickerResolution apply to them too.
+	NamedTickers []NamedTicker
+
+	// TickerResolution is the minimum delay between two ticks, the shorter delays returned by Tick are raised to it.
+	TickerResolution time.Duration
+
+	// BusyPoll makes the event-loops keep polling for events without blocking for this long after they got the
+	// last events before they block in epoll_wait or kevent again, which burns some CPU for a lower tail latency
+	// of the busy connections. It's disabled if not positive and takes no effect on Windows.
+	BusyPoll time.Duration
+
+	// LatencyMetrics indicates whether to record the latency of React callbacks and writes into histograms,
+	// which can be retrieved by Server.Metrics.
+	LatencyMetrics bool
+
+	// SlowReactThreshold is the duration beyond which a single React invocation is reported as slow,
+	// it helps find the accidental blocking calls that freeze an event-loop. It is disabled if not positive.
+	SlowReactThreshold time.Duration
+
+	// SlowReactHandler is invoked on the event-loop with the connection and the elapsed time of a slow React,
+	// a warning is logged instead if it is not set.
+	SlowReactHandler func(c Conn, elapsed time.Duration)
+
+	// AcceptErrorPolicy decides how to handle the transient errors of accepting connections, the default is
+	// AcceptRetry. The other errors shut down the server and it takes no effect on Windows.
+	AcceptErrorPolicy AcceptErrorPolicy
+
+	// AcceptErrorHandler is invoked on the event-loop accepting connections with the transient error under
+	// the AcceptCallback policy, e.g. to shed idle connections when running out of file-descriptors.
+	AcceptErrorHandler func(err error) Action
+
+	// CodecErrorPolicy decides how to handle the malformed input reported by a codec with a *CodecError,
+	// the default is CodecClose.
+	CodecErrorPolicy CodecErrorPolicy
+
+	// CodecErrorHandler is invoked on the event-loop with the connection and the *CodecError under the CodecCallback
+	// policy, the returned error frame is encoded and written back before the returned policy is applied.
+	CodecErrorHandler func(c Conn, err *CodecError) (out []byte, policy CodecErrorPolicy)
+
+	// SpareFd reserves a file-descriptor when the server starts, which is released to accept and close one pending
+	// connection each time accepting fails with EMFILE or ENFILE, so that the peer is refused cleanly instead of
+	// hanging in the backlog while the listener backs off. It takes no effect on Windows.
+	SpareFd bool
+
+	// PoolConns recycles the connection objects across accepting and closing to save the allocations of servers
+	// with a high churn of connections. A connection must not be used in any way after OnClosed has returned or
+	// it has been detached once this is on, since the same object may be handed out for the next connection,
+	// the tasks queued by AsyncWrite, Close, Wake, etc. before then are dropped. It takes no effect on Windows.
+	PoolConns bool
+
+	// SlabBuffers allocates the backing arrays of the inbound and outbound buffers of connections from
+	// the size classes of a slab allocator instead of the heap, which keeps the number of heap objects and
+	// the fragmentation down when there are hundreds of thousands of connections. It takes no effect on Windows.
+	SlabBuffers bool
+
+	// MemoryLimit is the budget of the inbound and outbound buffers of all connections in bytes, which is checked
+	// every 100ms and enforced by MemoryPolicy, it is unlimited if not positive and takes no effect on Windows.
+	MemoryLimit int64
+
+	// MemoryPolicy decides how to shed load when the buffers exceed MemoryLimit, the default is MemoryPauseAccept.
+	MemoryPolicy MemoryPolicy
+
+	// OverloadLatency enables the overload protection, which probes how long the tasks wait in the queue of every
+	// event-loop every 100ms, an event-loop is overloaded once it exceeds OverloadLatency, on which the server stops
+	// accepting connections and drops the PriorityLow connections of the event-loop until it recovers.
+	// It takes no effect on Windows.
+	OverloadLatency time.Duration
+
+	// OverloadHandler is invoked on a background goroutine when an event-loop becomes overloaded or recovers,
+	// otherwise the conditions are logged.
+	OverloadHandler func(idx int, latency time.Duration, overloaded bool)
+
+	// RebindListeners closes a stream listener failing on accepting for a reason other than the transient ones,
+	// e.g. its address has been removed from the interface, and binds a new one on the same address in the
+	// background with a backoff doubling from 100ms up to 30s, instead of shutting down the server. The listeners
+	// handed over by the user are not rebound and it takes no effect on Windows.
+	RebindListeners bool
+
+	// WatchdogInterval enables a watchdog that checks the event-loops at this interval and reports the ones stuck
+	// in one iteration for longer than it, like those blocked in a handler or livelocked, it is disabled if not
+	// positive and takes no effect on Windows.
+	WatchdogInterval time.Duration
+
+	// StallHandler is invoked on the watchdog goroutine with the index of the stuck event-loop, which is -1 for
+	// the main reactor, and the stack dump of all goroutines, an error is logged instead if it is not set.
+	StallHandler func(idx int, stacks []byte)
+
 	// TCPKeepAlive sets up a duration for (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// MultipathTCP indicates whether to create the TCP listeners with IPPROTO_MPTCP, it falls back to plain TCP
+	// on the systems without Multipath TCP support. It is only available on Linux 5.6 and later.
+	MultipathTCP bool
+
+	// TCPUserTimeout sets up the TCP_USER_TIMEOUT socket option, connections with data that remains unacknowledged
+	// for longer than it are torn down instead of lingering for the kernel-default retransmission periods.
+	// It is only available on Linux.
+	TCPUserTimeout time.Duration
+
 	// TCPNoDelay controls whether the operating system should delay
 	// packet transmission in hopes of sending fewer packets (Nagle's algorithm).
 	//
@@ -90,18 +311,128 @@ type Options struct {
 	// as soon as possible after a Write.
 	TCPNoDelay TCPSocketOpt
 
+	// MaxFramesPerIteration is the maximum number of frames decoded from a connection and handed over to React
+	// in one iteration of the event-loop, the rest of the buffered frames are handled in a later iteration after
+	// the other connections on the same event-loop are served, which prevents a single firehose client from
+	// starving the others. It is unlimited if not positive and takes no effect on Windows.
+	MaxFramesPerIteration int
+
+	// MaxWriteBytesPerIteration is the maximum number of bytes flushed from the outbound buffer of a connection
+	// on one writable event, the connections with pending outbound data are then drained in round-robin slices
+	// instead of one by one, which bounds the head-of-line blocking that bulk transfers impose on the interactive
+	// traffic. It is unlimited if not positive and takes no effect on Windows.
+	MaxWriteBytesPerIteration int
+
+	// AccessLog is called with an entry of every TCP connection when it is closed, right before OnClosed,
+	// which is the place for producing the access logs without instrumenting the event handlers.
+	// It runs on the event-loop, so it should hand the entries over to another goroutine rather than block.
+	AccessLog func(entry AccessLogEntry)
+
+	// PcapFile is the path of a pcap file that the traffic of the connections selected by PcapFilter is written
+	// into with synthetic Ethernet, IP and TCP headers, for analyzing protocol bugs in Wireshark. PcapFilter selects
+	// the connections when they are opened, all the TCP connections are captured if it's nil. The file is truncated
+	// when the server starts and every segment is written through, so it's meant for debugging only.
+	PcapFile   string
+	PcapFilter func(c Conn) bool
+
+	// Faults injects the network misbehavior into all the TCP connections, it's meant for chaos testing only.
+	// The AsyncWrite callbacks may report the data held back by a delay as flushed. It takes no effect on Windows.
+	Faults *FaultInjection
+
+	// IdleTimeout closes the connections without any traffic for this long with errors.ErrIdleTimeout, they are
+	// swept a few times per IdleTimeout, so a connection may stay idle for up to 1.25x IdleTimeout before it's
+	// closed. The number of closed ones is reported by Metrics. It's disabled if not positive, it can be changed by
+	// Server.UpdateOptions and takes no effect on Windows.
+	IdleTimeout time.Duration
+
+	// MaxConns caps the number of the active connections, the connections accepted beyond it are closed at once
+	// without reaching OnOpened. It's unlimited if not positive and it can be changed by Server.UpdateOptions.
+	MaxConns int
+
+	// AcceptRate caps the number of the connections accepted per second, with bursts of up to AcceptRate
+	// connections, the ones accepted beyond it are closed at once without reaching OnOpened. It's unlimited if not
+	// positive and it can be changed by Server.UpdateOptions.
+	AcceptRate int
+
+	// CoalesceWrites indicates whether to coalesce the data written back to a connection while reacting to
+	// the data read from it and write them all at once afterwards, which cuts the per-packet overhead for chatty
+	// protocols sending many small frames in one go. It takes no effect on Windows.
+	CoalesceWrites bool
+
 	// SocketRecvBuffer sets the maximum socket receive buffer in bytes.
 	SocketRecvBuffer int
 
 	// SocketSendBuffer sets the maximum socket send buffer in bytes.
 	SocketSendBuffer int
 
+	// SocketMark sets up the SO_MARK option of the listeners, which is inherited by the accepted connections,
+	// so that the traffic can be classified by policy routing and packet filtering rules. It is only available
+	// on Linux and requires the CAP_NET_ADMIN capability, serving fails with it on BSD and it is ignored on Windows.
+	SocketMark int
+
+	// SocketBusyPoll sets up the SO_BUSY_POLL option of the listeners, which is inherited by the accepted
+	// connections, so that the kernel busy polls the device queue for this long in microseconds when a socket
+	// is read with nothing received, on the NICs supporting it. SocketBusyPollBudget sets up SO_BUSY_POLL_BUDGET,
+	// the maximum number of packets per round of busy polling, which can be overridden by Route.BusyPollBudget.
+	// They're only available on Linux, serving fails with them on BSD and they're ignored on Windows.
+	SocketBusyPoll       time.Duration
+	SocketBusyPollBudget int
+
+	// Transparent sets up the IP_TRANSPARENT option of the listeners, so that they can accept the connections
+	// destined to foreign addresses redirected by TPROXY, the local address of such a connection is the
+	// original destination. It is only available on Linux and requires the CAP_NET_ADMIN capability.
+	//
+	// On UDP listeners, it also sets up IP_RECVORIGDSTADDR, Conn.OriginalDst reports the original destination
+	// of a datagram and the replies are sent from it through transparent sockets cached by the event-loops.
+	Transparent bool
+
+	// AcceptFilter attaches an accept filter like "dataready" or "httpready" to the TCP listeners, so that
+	// a connection is only handed over to gnet once its first data or a complete HTTP request has arrived.
+	// It is only available on FreeBSD with the accf_* kernel module loaded, serving fails with it on the other
+	// Unix platforms and it is ignored on Windows.
+	AcceptFilter string
+
+	// SocketControl is called with the socket of every listener after it's created and before it's bound,
+	// as well as the socket of every accepted connection before it's registered to an event-loop, which allows
+	// setting up any socket option that gnet doesn't wrap, mirroring net.ListenConfig.Control. The address is
+	// the listening address for listeners and the remote address for connections, and fd is the socket handle
+	// on Windows. Serving fails if it fails on a listener, while an accepted connection is closed right away.
+	SocketControl func(network, address string, fd int) error
+
+	// UDPSendQueueSize is the maximum number of datagrams queued per UDP socket in each event-loop when
+	// the socket send buffer is full, the queued datagrams are sent once the socket becomes writable.
+	// The default is 1024, it takes no effect on Windows.
+	UDPSendQueueSize int
+
+	// UDPDropPolicy decides which datagram to drop when the UDP send queue is full, the default is DropNewest.
+	UDPDropPolicy UDPDropPolicy
+
+	// HealthCheckAddr is the address of the health-check listener served by the same event-loops, it follows
+	// the format described in Serve. The listener accepts and closes every connection right away, so that
+	// orchestrators can probe the server without speaking its protocol.
+	HealthCheckAddr string
+
+	// HealthCheckHTTP indicates whether the health-check listener answers every connection with a minimal
+	// HTTP 200 response instead of closing it right away, for the probes that expect HTTP.
+	HealthCheckHTTP bool
+
+	// AdminAddr is the address of the admin listener served by the same event-loops, which must be a unix socket
+	// like `unix:///run/app/gnet.sock` or a loopback TCP address since it takes no credentials, Serve fails with
+	// errors.ErrNonLocalAdminAddr otherwise. It takes one command per line for operators to introspect and control
+	// a live server: stats, conns, memory, loglevel <level>, drain and quit. The admin connections don't hold back
+	// draining.
+	AdminAddr string
+
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
 
 	// Logger is the customized logger for logging info, if it is not set,
 	// then gnet will use the default logger powered by go.uber.org/zap.
 	Logger logging.Logger
+
+	// LogLevel is the level of the default logger, e.g. "debug", "info", "warn" or "error",
+	// it takes no effect on the customized logger.
+	LogLevel string
 }
 
 // WithOptions sets up all options.
@@ -125,6 +456,13 @@ func WithLockOSThread(lockOSThread bool) Option {
 	}
 }
 
+// WithLowPriorityReadBufferCap sets up LowPriorityReadBufferCap for reading bytes from low-priority connections.
+func WithLowPriorityReadBufferCap(lowPriorityReadBufferCap int) Option {
+	return func(opts *Options) {
+		opts.LowPriorityReadBufferCap = lowPriorityReadBufferCap
+	}
+}
+
 // WithReadBufferCap sets up ReadBufferCap for reading bytes.
 func WithReadBufferCap(readBufferCap int) Option {
 	return func(opts *Options) {
@@ -160,6 +498,20 @@ func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	}
 }
 
+// WithTCPUserTimeout sets up the TCP_USER_TIMEOUT socket option.
+func WithTCPUserTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPUserTimeout = timeout
+	}
+}
+
+// WithMultipathTCP sets up the Multipath TCP listeners.
+func WithMultipathTCP(mptcp bool) Option {
+	return func(opts *Options) {
+		opts.MultipathTCP = mptcp
+	}
+}
+
 // WithTCPNoDelay enable/disable the TCP_NODELAY socket option.
 func WithTCPNoDelay(tcpNoDelay TCPSocketOpt) Option {
 	return func(opts *Options) {
@@ -181,6 +533,14 @@ func WithSocketSendBuffer(sendBuf int) Option {
 	}
 }
 
+// WithReusePortCPUSteering sets up whether to steer the traffic of the SO_REUSEPORT listeners to the event-loop
+// pinned to the receiving CPU.
+func WithReusePortCPUSteering(steering bool) Option {
+	return func(opts *Options) {
+		opts.ReusePortCPUSteering = steering
+	}
+}
+
 // WithTicker indicates that a ticker is set.
 func WithTicker(ticker bool) Option {
 	return func(opts *Options) {
@@ -188,6 +548,278 @@ func WithTicker(ticker bool) Option {
 	}
 }
 
+// WithTickerFixedRate indicates that the ticks are scheduled at a fixed rate.
+func WithTickerFixedRate(fixedRate bool) Option {
+	return func(opts *Options) {
+		opts.TickerFixedRate = fixedRate
+	}
+}
+
+// WithNamedTicker registers a ticker that fires NamedTickHandler.NamedTick with id every interval.
+func WithNamedTicker(id string, interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.NamedTickers = append(opts.NamedTickers, NamedTicker{ID: id, Interval: interval})
+	}
+}
+
+// WithTickerResolution sets up the minimum delay between two ticks.
+func WithTickerResolution(resolution time.Duration) Option {
+	return func(opts *Options) {
+		opts.TickerResolution = resolution
+	}
+}
+
+// WithBusyPoll sets up how long the event-loops keep polling without blocking after the last events.
+func WithBusyPoll(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.BusyPoll = d
+	}
+}
+
+// WithLatencyMetrics enables the latency histograms of React callbacks and writes.
+func WithLatencyMetrics(enabled bool) Option {
+	return func(opts *Options) {
+		opts.LatencyMetrics = enabled
+	}
+}
+
+// WithSlowReactThreshold sets up the threshold for reporting slow React invocations.
+func WithSlowReactThreshold(threshold time.Duration) Option {
+	return func(opts *Options) {
+		opts.SlowReactThreshold = threshold
+	}
+}
+
+// WithSlowReactHandler sets up the callback for slow React invocations.
+func WithSlowReactHandler(handler func(c Conn, elapsed time.Duration)) Option {
+	return func(opts *Options) {
+		opts.SlowReactHandler = handler
+	}
+}
+
+// WithAcceptErrorPolicy sets up the policy of handling the transient errors of accepting connections.
+func WithAcceptErrorPolicy(policy AcceptErrorPolicy) Option {
+	return func(opts *Options) {
+		opts.AcceptErrorPolicy = policy
+	}
+}
+
+// WithAcceptErrorHandler sets up the callback for the transient errors of accepting connections.
+func WithAcceptErrorHandler(handler func(err error) Action) Option {
+	return func(opts *Options) {
+		opts.AcceptErrorHandler = handler
+	}
+}
+
+// WithCodecErrorPolicy sets up the policy of handling the malformed input reported by codecs.
+func WithCodecErrorPolicy(policy CodecErrorPolicy) Option {
+	return func(opts *Options) {
+		opts.CodecErrorPolicy = policy
+	}
+}
+
+// WithCodecErrorHandler sets up the callback for the malformed input reported by codecs.
+func WithCodecErrorHandler(handler func(c Conn, err *CodecError) (out []byte, policy CodecErrorPolicy)) Option {
+	return func(opts *Options) {
+		opts.CodecErrorHandler = handler
+	}
+}
+
+// WithSpareFd sets up the reserved file-descriptor for shedding connections when running out of file-descriptors.
+func WithSpareFd(spare bool) Option {
+	return func(opts *Options) {
+		opts.SpareFd = spare
+	}
+}
+
+// WithPoolConns enables recycling the connection objects.
+func WithPoolConns(pool bool) Option {
+	return func(opts *Options) {
+		opts.PoolConns = pool
+	}
+}
+
+// WithSlabBuffers enables allocating the buffers of connections from the slab allocator.
+func WithSlabBuffers(slab bool) Option {
+	return func(opts *Options) {
+		opts.SlabBuffers = slab
+	}
+}
+
+// WithMemoryLimit sets up the budget of the buffers of connections and the policy of enforcing it.
+func WithMemoryLimit(limit int64, policy MemoryPolicy) Option {
+	return func(opts *Options) {
+		opts.MemoryLimit = limit
+		opts.MemoryPolicy = policy
+	}
+}
+
+// WithOverloadProtection enables the overload protection with the given latency threshold of event-loops.
+func WithOverloadProtection(latency time.Duration) Option {
+	return func(opts *Options) {
+		opts.OverloadLatency = latency
+	}
+}
+
+// WithOverloadHandler sets up the callback for the event-loops becoming overloaded or recovering.
+func WithOverloadHandler(handler func(idx int, latency time.Duration, overloaded bool)) Option {
+	return func(opts *Options) {
+		opts.OverloadHandler = handler
+	}
+}
+
+// WithRebindListeners enables rebinding the stream listeners that fail on accepting.
+func WithRebindListeners(rebind bool) Option {
+	return func(opts *Options) {
+		opts.RebindListeners = rebind
+	}
+}
+
+// WithWatchdog enables the watchdog checking the event-loops at the given interval.
+func WithWatchdog(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.WatchdogInterval = interval
+	}
+}
+
+// WithStallHandler sets up the callback for the event-loops found stuck by the watchdog.
+func WithStallHandler(handler func(idx int, stacks []byte)) Option {
+	return func(opts *Options) {
+		opts.StallHandler = handler
+	}
+}
+
+// WithSocketBusyPoll sets up the SO_BUSY_POLL and SO_BUSY_POLL_BUDGET options of the listeners,
+// the budget is left as the kernel default if it's not positive.
+func WithSocketBusyPoll(d time.Duration, budget int) Option {
+	return func(opts *Options) {
+		opts.SocketBusyPoll = d
+		opts.SocketBusyPollBudget = budget
+	}
+}
+
+// WithSocketMark sets up the SO_MARK option of the listeners.
+func WithSocketMark(mark int) Option {
+	return func(opts *Options) {
+		opts.SocketMark = mark
+	}
+}
+
+// WithAcceptFilter attaches the accept filter of the given name to the TCP listeners.
+func WithAcceptFilter(name string) Option {
+	return func(opts *Options) {
+		opts.AcceptFilter = name
+	}
+}
+
+// WithSocketControl sets up the function called with the sockets of the listeners and the accepted connections.
+func WithSocketControl(control func(network, address string, fd int) error) Option {
+	return func(opts *Options) {
+		opts.SocketControl = control
+	}
+}
+
+// WithTransparent sets up the IP_TRANSPARENT option of the listeners.
+func WithTransparent(transparent bool) Option {
+	return func(opts *Options) {
+		opts.Transparent = transparent
+	}
+}
+
+// WithUDPSendQueueSize sets up the maximum number of queued datagrams per UDP socket.
+func WithUDPSendQueueSize(size int) Option {
+	return func(opts *Options) {
+		opts.UDPSendQueueSize = size
+	}
+}
+
+// WithUDPDropPolicy sets up the policy of dropping datagrams when the UDP send queue is full.
+func WithUDPDropPolicy(policy UDPDropPolicy) Option {
+	return func(opts *Options) {
+		opts.UDPDropPolicy = policy
+	}
+}
+
+// WithMaxFramesPerIteration sets up the maximum number of frames handled for a connection in one iteration.
+func WithMaxFramesPerIteration(n int) Option {
+	return func(opts *Options) {
+		opts.MaxFramesPerIteration = n
+	}
+}
+
+// WithAccessLog sets up the callback receiving an entry of every closed connection.
+func WithAccessLog(accessLog func(entry AccessLogEntry)) Option {
+	return func(opts *Options) {
+		opts.AccessLog = accessLog
+	}
+}
+
+// WithPcap sets up the capture of the connections selected by filter into the pcap file at path.
+func WithPcap(path string, filter func(c Conn) bool) Option {
+	return func(opts *Options) {
+		opts.PcapFile = path
+		opts.PcapFilter = filter
+	}
+}
+
+// WithFaultInjection sets up the network misbehavior injected into the TCP connections.
+func WithFaultInjection(faults FaultInjection) Option {
+	return func(opts *Options) {
+		opts.Faults = &faults
+	}
+}
+
+// WithIdleTimeout sets up how long a connection can stay without traffic before it's closed.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.IdleTimeout = timeout
+	}
+}
+
+// WithMaxConns sets up the maximum number of the active connections.
+func WithMaxConns(n int) Option {
+	return func(opts *Options) {
+		opts.MaxConns = n
+	}
+}
+
+// WithAcceptRate sets up the maximum number of the connections accepted per second.
+func WithAcceptRate(rate int) Option {
+	return func(opts *Options) {
+		opts.AcceptRate = rate
+	}
+}
+
+// WithMaxWriteBytesPerIteration sets up the maximum number of bytes flushed for a connection on a writable event.
+func WithMaxWriteBytesPerIteration(n int) Option {
+	return func(opts *Options) {
+		opts.MaxWriteBytesPerIteration = n
+	}
+}
+
+// WithCoalesceWrites sets up whether to coalesce the replies to the inbound data of a connection and write them
+// all at once after reacting to it.
+func WithCoalesceWrites(coalesce bool) Option {
+	return func(opts *Options) {
+		opts.CoalesceWrites = coalesce
+	}
+}
+
+// WithHealthCheck sets up the health-check listener on protoAddr, answering HTTP requests if http is true.
+func WithHealthCheck(protoAddr string, http bool) Option {
+	return func(opts *Options) {
+		opts.HealthCheckAddr = protoAddr
+		opts.HealthCheckHTTP = http
+	}
+}
+
+// WithAdmin sets up the admin listener on protoAddr.
+func WithAdmin(protoAddr string) Option {
+	return func(opts *Options) {
+		opts.AdminAddr = protoAddr
+	}
+}
+
 // WithCodec sets up a codec to handle TCP stream.
 func WithCodec(codec ICodec) Option {
 	return func(opts *Options) {
@@ -195,9 +827,57 @@ func WithCodec(codec ICodec) Option {
 	}
 }
 
+// WithLogLevel sets up the level of the default logger.
+func WithLogLevel(level string) Option {
+	return func(opts *Options) {
+		opts.LogLevel = level
+	}
+}
+
 // WithLogger sets up a customized logger.
 func WithLogger(logger logging.Logger) Option {
 	return func(opts *Options) {
 		opts.Logger = logger
 	}
 }
+
+// loadLiveOptions returns the options updated at runtime, it doesn't block so that the event-loops can call it
+// whenever they need the options.
+func (svr *server) loadLiveOptions() *Options {
+	return svr.liveOpts.Load().(*Options)
+}
+
+// updateOptions applies opts to the running server, only the options that are safe to change at runtime
+// are taken and published by swapping the live options at once.
+func (svr *server) updateOptions(opts ...Option) error {
+	if !svr.isStarted() {
+		return errors.ErrServerNotStarted
+	}
+
+	svr.optsMu.Lock()
+	defer svr.optsMu.Unlock()
+
+	requested := *svr.loadLiveOptions()
+	for _, opt := range opts {
+		opt(&requested)
+	}
+
+	updated := *svr.loadLiveOptions()
+	if requested.LogLevel != updated.LogLevel {
+		if svr.opts.Logger != nil {
+			return errors.ErrUnsupportedOp
+		}
+		if err := logging.SetLevel(requested.LogLevel); err != nil {
+			return err
+		}
+		updated.LogLevel = requested.LogLevel
+	}
+	updated.CoalesceWrites = requested.CoalesceWrites
+	updated.UDPSendQueueSize = requested.UDPSendQueueSize
+	updated.UDPDropPolicy = requested.UDPDropPolicy
+	updated.IdleTimeout = requested.IdleTimeout
+	updated.MaxConns = requested.MaxConns
+	updated.AcceptRate = requested.AcceptRate
+	svr.liveOpts.Store(&updated)
+	return nil
+}
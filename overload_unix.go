@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+)
+
+// overloadCheckInterval is the interval of probing the latency of event-loops for the overload protection.
+const overloadCheckInterval = 100 * time.Millisecond
+
+// loopProbe tracks the latency of an event-loop with a task sent to it, the latency of a task still pending
+// is the time it has been waiting so far, so that an event-loop stuck for long is caught all the same.
+type loopProbe struct {
+	sent       time.Time
+	pending    int32
+	latency    int64
+	overloaded bool
+}
+
+// overloadGuard sheds load off the event-loops whose latency exceeds threshold until the server is shut down.
+func (svr *server) overloadGuard(threshold time.Duration) {
+	ticker := time.NewTicker(overloadCheckInterval)
+	defer ticker.Stop()
+
+	probes := make(map[*eventloop]*loopProbe)
+	paused := false // whether accepting is paused by the guard rather than the user
+	for now := range ticker.C {
+		if svr.isInShutdown() {
+			return
+		}
+		overloaded := 0
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			p := probes[el]
+			if p == nil {
+				p = new(loopProbe)
+				probes[el] = p
+			}
+			latency := time.Duration(atomic.LoadInt64(&p.latency))
+			if atomic.LoadInt32(&p.pending) == 1 {
+				latency = now.Sub(p.sent)
+			} else {
+				p.sent = now
+				atomic.StoreInt32(&p.pending, 1)
+				_ = el.execute(func() error {
+					atomic.StoreInt64(&p.latency, int64(time.Since(p.sent)))
+					atomic.StoreInt32(&p.pending, 0)
+					return nil
+				})
+			}
+
+			if latency > threshold {
+				overloaded++
+				if !p.overloaded {
+					p.overloaded = true
+					svr.reportOverload(el, latency, true)
+					svr.dropLowPriority(el)
+				}
+			} else if p.overloaded {
+				p.overloaded = false
+				svr.reportOverload(el, latency, false)
+			}
+			return true
+		})
+
+		if !paused && overloaded > 0 && !svr.isAcceptPaused() {
+			paused = svr.pauseAccept() == nil
+		} else if paused && overloaded == 0 {
+			paused = svr.resumeAccept() != nil
+		}
+	}
+}
+
+func (svr *server) reportOverload(el *eventloop, latency time.Duration, overloaded bool) {
+	if handler := svr.opts.OverloadHandler; handler != nil {
+		handler(el.idx, latency, overloaded)
+		return
+	}
+	if overloaded {
+		svr.logger.Warnf("Event-loop(%d) is overloaded with a latency of %v, shedding load", el.idx, latency)
+	} else {
+		svr.logger.Infof("Event-loop(%d) has recovered from overload with a latency of %v", el.idx, latency)
+	}
+}
+
+// dropLowPriority closes the PriorityLow connections on the event-loop.
+func (svr *server) dropLowPriority(el *eventloop) {
+	_ = el.execute(func() (err error) {
+		el.connections.iterate(func(c *conn) bool {
+			if c.priority == PriorityLow {
+				err = el.loopCloseConn(c, errors.ErrOverloaded)
+			}
+			return err == nil
+		})
+		return
+	})
+}
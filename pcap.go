@@ -0,0 +1,238 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapSnapLen    = 65535
+	pcapLinkEther  = 1
+	pcapMaxPayload = pcapSnapLen - 14 - 40 - 20 // keeps the segments with IPv6 headers within the snapshot length
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// pcapWriter writes the traffic of the captured connections into a pcap file, wrapped in synthetic
+// Ethernet, IP and TCP headers so that it can be dissected by Wireshark. It is shared by the event-loops.
+type pcapWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  []byte
+}
+
+func openPcap(path string) (*pcapWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkEther)
+	if _, err = file.Write(hdr); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &pcapWriter{file: file}, nil
+}
+
+func (w *pcapWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+func (svr *server) closePcap() {
+	if svr.pcap != nil {
+		if err := svr.pcap.close(); err != nil {
+			svr.logger.Warnf("Failed to close the pcap file, error: %v", err)
+		}
+	}
+}
+
+// capture returns the flow capturing c if it's selected by Options.PcapFilter, nil otherwise.
+func (svr *server) capture(c Conn) *pcapFlow {
+	if svr.pcap == nil || svr.opts.PcapFilter != nil && !svr.opts.PcapFilter(c) {
+		return nil
+	}
+	return svr.pcap.newPcapFlow(c.LocalAddr(), c.RemoteAddr())
+}
+
+// pcapFlow is a captured connection, it keeps the TCP sequence numbers of both directions.
+type pcapFlow struct {
+	w                    *pcapWriter
+	client, server       *net.TCPAddr
+	clientSeq, serverSeq uint32
+}
+
+// newPcapFlow starts capturing the connection between the client remote and the server local with a synthetic
+// three-way handshake, it returns nil if they are not TCP addresses.
+func (w *pcapWriter) newPcapFlow(local, remote net.Addr) *pcapFlow {
+	server, ok1 := local.(*net.TCPAddr)
+	client, ok2 := remote.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	// The local address of a connection accepted by a dual-stack listener may be the unspecified IPv6 address,
+	// which takes the family of the client.
+	if client.IP.To4() != nil && server.IP.To4() == nil {
+		server = &net.TCPAddr{IP: net.IPv4zero, Port: server.Port}
+	}
+	f := &pcapFlow{w: w, client: client, server: server, clientSeq: 1000, serverSeq: 5000}
+	f.segment(true, tcpFlagSYN, nil)
+	f.clientSeq++
+	f.segment(false, tcpFlagSYN|tcpFlagACK, nil)
+	f.serverSeq++
+	f.segment(true, tcpFlagACK, nil)
+	return f
+}
+
+// inbound captures the data received from the client.
+func (f *pcapFlow) inbound(data []byte) {
+	f.data(true, data)
+}
+
+// outbound captures the data sent to the client.
+func (f *pcapFlow) outbound(data []byte) {
+	f.data(false, data)
+}
+
+// close captures the server closing the connection.
+func (f *pcapFlow) close() {
+	f.segment(false, tcpFlagFIN|tcpFlagACK, nil)
+	f.serverSeq++
+	f.segment(true, tcpFlagFIN|tcpFlagACK, nil)
+	f.clientSeq++
+	f.segment(false, tcpFlagACK, nil)
+}
+
+func (f *pcapFlow) data(fromClient bool, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > pcapMaxPayload {
+			n = pcapMaxPayload
+		}
+		f.segment(fromClient, tcpFlagPSH|tcpFlagACK, data[:n])
+		if fromClient {
+			f.clientSeq += uint32(n)
+		} else {
+			f.serverSeq += uint32(n)
+		}
+		data = data[n:]
+	}
+}
+
+// segment writes a TCP segment into the pcap file as a record.
+func (f *pcapFlow) segment(fromClient bool, flags byte, payload []byte) {
+	src, dst, seq, ack := f.server, f.client, f.serverSeq, f.clientSeq
+	if fromClient {
+		src, dst, seq, ack = f.client, f.server, f.clientSeq, f.serverSeq
+	}
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	v6 := srcIP == nil || dstIP == nil
+	if v6 {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+
+	w := f.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ipLen := 20
+	if v6 {
+		ipLen = 40
+	}
+	pktLen := 14 + ipLen + 20 + len(payload)
+	if cap(w.buf) < 16+pktLen {
+		w.buf = make([]byte, 16+pktLen)
+	}
+	b := w.buf[:16+pktLen]
+	for i := range b {
+		b[i] = 0
+	}
+
+	now := time.Now()
+	binary.LittleEndian.PutUint32(b[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(b[8:], uint32(pktLen))
+	binary.LittleEndian.PutUint32(b[12:], uint32(pktLen))
+
+	// Ethernet: the MAC addresses stay zero.
+	eth := b[16:]
+	ip := eth[14:]
+	if v6 {
+		binary.BigEndian.PutUint16(eth[12:], 0x86dd)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(20+len(payload)))
+		ip[6] = 6 // TCP
+		ip[7] = 64
+		copy(ip[8:], srcIP)
+		copy(ip[24:], dstIP)
+	} else {
+		binary.BigEndian.PutUint16(eth[12:], 0x0800)
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(payload)))
+		ip[8] = 64
+		ip[9] = 6 // TCP
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip[:20]))
+	}
+
+	// The TCP checksum is left zero, Wireshark doesn't validate it by default.
+	tcp := ip[ipLen:]
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], ack)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+
+	_, _ = w.file.Write(b)
+}
+
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet/errors"
+	"golang.org/x/sys/windows"
+)
+
+const pipePrefix = `\\.\pipe\`
+
+// pipeAddr is the address of a named pipe, which is its full path like `\\.\pipe\gnet`.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeListener implements net.Listener on top of named pipes, there is always one instance of
+// the pipe waiting for the next client, which turns into a connection once a client connects to it.
+type pipeListener struct {
+	mu         sync.Mutex
+	addr       pipeAddr
+	handle     windows.Handle      // pipe instance waiting for the next client
+	connecting *windows.Overlapped // pending ConnectNamedPipe on handle, if any
+	closed     bool
+}
+
+func listenPipe(name string) (*pipeListener, error) {
+	if !strings.HasPrefix(name, pipePrefix) {
+		name = pipePrefix + name
+	}
+	h, err := createPipe(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeListener{addr: pipeAddr(name), handle: h}, nil
+}
+
+// createPipe creates an instance of the named pipe, the first instance fails if the pipe exists already
+// so that two servers never share the same name.
+func createPipe(name string, first bool) (windows.Handle, error) {
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	h, err := windows.CreateNamedPipe(path, flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, 0x10000, 0x10000, 0, nil)
+	if err != nil {
+		return windows.InvalidHandle, os.NewSyscallError("CreateNamedPipe", err)
+	}
+	return h, nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+		h := l.handle
+		o, err := newOverlapped()
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		err = windows.ConnectNamedPipe(h, o)
+		l.connecting = o
+		l.mu.Unlock()
+
+		switch err {
+		case windows.ERROR_IO_PENDING:
+			var n uint32
+			err = windows.GetOverlappedResult(h, o, &n, true)
+		case windows.ERROR_PIPE_CONNECTED:
+			err = nil
+		}
+		_ = windows.CloseHandle(o.HEvent)
+
+		l.mu.Lock()
+		l.connecting = nil
+		if l.closed {
+			l.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+		if err == nil || err == windows.ERROR_NO_DATA {
+			// Prepare the instance for the next client before handing this one over.
+			var next windows.Handle
+			if next, err = createPipe(string(l.addr), false); err != nil {
+				l.mu.Unlock()
+				return nil, err
+			}
+			l.handle = next
+		}
+		l.mu.Unlock()
+
+		switch err {
+		case nil:
+			return newPipeConn(h, l.addr), nil
+		case windows.ERROR_NO_DATA:
+			// The client has gone away before being accepted.
+			_ = windows.CloseHandle(h)
+		default:
+			return nil, os.NewSyscallError("ConnectNamedPipe", err)
+		}
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.connecting != nil {
+		_ = windows.CancelIoEx(l.handle, l.connecting)
+	}
+	return windows.CloseHandle(l.handle)
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+// pipeConn implements net.Conn on top of an instance of a named pipe, the instance is opened in overlapped mode
+// so that reading and writing it from different goroutines don't block each other. Only read deadlines are
+// supported, which is what the event-loop relies on to release the reading goroutine.
+type pipeConn struct {
+	handle windows.Handle
+	addr   pipeAddr
+
+	mu          sync.Mutex
+	reading     *windows.Overlapped // pending read, if any
+	readTimer   *time.Timer
+	readExpired bool
+	closed      bool
+}
+
+func newPipeConn(h windows.Handle, addr pipeAddr) *pipeConn {
+	return &pipeConn{handle: h, addr: addr}
+}
+
+func newOverlapped() (*windows.Overlapped, error) {
+	ev, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateEvent", err)
+	}
+	return &windows.Overlapped{HEvent: ev}, nil
+}
+
+// wait waits for the overlapped operation o to complete, err is the result of starting it.
+func (c *pipeConn) wait(o *windows.Overlapped, err error) (int, error) {
+	if err != nil && err != windows.ERROR_IO_PENDING {
+		return 0, err
+	}
+	var n uint32
+	err = windows.GetOverlappedResult(c.handle, o, &n, true)
+	return int(n), err
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	o, err := newOverlapped()
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(o.HEvent)
+
+	// Start reading under the lock so that an expiring deadline always finds the pending read to cancel.
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if c.readExpired {
+		c.mu.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	err = windows.ReadFile(c.handle, b, nil, o)
+	c.reading = o
+	c.mu.Unlock()
+
+	n, err := c.wait(o, err)
+
+	c.mu.Lock()
+	c.reading = nil
+	expired := c.readExpired
+	c.mu.Unlock()
+
+	switch {
+	case err == nil:
+		return n, nil
+	case err == windows.ERROR_BROKEN_PIPE:
+		return n, io.EOF
+	case err == windows.ERROR_OPERATION_ABORTED && expired:
+		return n, os.ErrDeadlineExceeded
+	default:
+		return n, os.NewSyscallError("ReadFile", err)
+	}
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	o, err := newOverlapped()
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(o.HEvent)
+
+	var nn int
+	for nn < len(b) {
+		n, err := c.wait(o, windows.WriteFile(c.handle, b[nn:], nil, o))
+		nn += n
+		if err != nil {
+			return nn, os.NewSyscallError("WriteFile", err)
+		}
+	}
+	return nn, nil
+}
+
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+	}
+	_ = windows.CancelIoEx(c.handle, nil)
+	return windows.CloseHandle(c.handle)
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+		c.readTimer = nil
+	}
+	c.readExpired = false
+	if t.IsZero() {
+		return nil
+	}
+	if d := time.Until(t); d > 0 {
+		c.readTimer = time.AfterFunc(d, func() {
+			c.mu.Lock()
+			c.expireRead()
+			c.mu.Unlock()
+		})
+		return nil
+	}
+	c.expireRead()
+	return nil
+}
+
+// expireRead fails the pending read and the subsequent ones with os.ErrDeadlineExceeded, c.mu must be held.
+func (c *pipeConn) expireRead() {
+	c.readExpired = true
+	if c.reading != nil && !c.closed {
+		_ = windows.CancelIoEx(c.handle, c.reading)
+	}
+}
+
+func (c *pipeConn) SetWriteDeadline(_ time.Time) error {
+	return errors.ErrUnsupportedOp
+}
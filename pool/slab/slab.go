@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package slab allocates byte slices of power-of-two size classes, the small ones are carved out of larger slabs
+// and the freed ones are kept on per-class free lists, which cuts down the number of heap objects as well as
+// the fragmentation when lots of long-lived buffers come and go.
+package slab
+
+import "sync"
+
+const (
+	minShift = 6  // 64 bytes, a CPU cache line
+	maxShift = 26 // 64MB, larger slices are left to the heap
+	slabSize = 1 << 20
+
+	// DefaultMaxFree is the default number of bytes kept on the free list of each size class.
+	DefaultMaxFree = 64 << 20
+)
+
+// Allocator is a slab allocator safe for concurrent use, the slices it allocates are not zeroed.
+type Allocator struct {
+	maxFree int
+	classes [maxShift - minShift + 1]class
+}
+
+type class struct {
+	mu   sync.Mutex
+	free [][]byte // freed slices ready for reuse
+	slab []byte   // the rest of the current slab to carve slices out of
+}
+
+// Default is the allocator shared within the process.
+var Default = New(DefaultMaxFree)
+
+// New returns an allocator keeping at most maxFree bytes of freed slices for each size class,
+// the slices beyond it are left to the GC.
+func New(maxFree int) *Allocator {
+	return &Allocator{maxFree: maxFree}
+}
+
+// Alloc returns a slice of the given length whose capacity is the least power of two not less than it.
+func (a *Allocator) Alloc(size int) []byte {
+	idx := index(size)
+	if idx < 0 {
+		return make([]byte, size)
+	}
+	n := 1 << (idx + minShift)
+	c := &a.classes[idx]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := len(c.free); l > 0 {
+		buf := c.free[l-1]
+		c.free[l-1] = nil
+		c.free = c.free[:l-1]
+		return buf[:size]
+	}
+	if n >= slabSize {
+		return make([]byte, size, n)
+	}
+	if len(c.slab) < n {
+		c.slab = make([]byte, slabSize)
+	}
+	buf := c.slab[:size:n]
+	c.slab = c.slab[n:]
+	return buf
+}
+
+// Free puts buf back to the allocator, which must not be used any more. The slices that are not allocated
+// by Alloc are taken as well as long as their capacities match a size class.
+func (a *Allocator) Free(buf []byte) {
+	n := cap(buf)
+	idx := index(n)
+	if idx < 0 || 1<<(idx+minShift) != n {
+		return
+	}
+	c := &a.classes[idx]
+	c.mu.Lock()
+	if (len(c.free)+1)*n <= a.maxFree {
+		c.free = append(c.free, buf[:n])
+	}
+	c.mu.Unlock()
+}
+
+// Cached returns the number of bytes held by the allocator for reuse, which are the freed slices and
+// the rest of the slabs not carved out yet.
+func (a *Allocator) Cached() (n int) {
+	for i := range a.classes {
+		c := &a.classes[i]
+		c.mu.Lock()
+		n += len(c.free)<<(i+minShift) + len(c.slab)
+		c.mu.Unlock()
+	}
+	return
+}
+
+// index returns the size class of n, or -1 if n is out of the size classes.
+func index(n int) int {
+	if n <= 0 || n > 1<<maxShift {
+		return -1
+	}
+	idx := 0
+	for n--; n >= 1<<minShift; n >>= 1 {
+		idx++
+	}
+	return idx
+}
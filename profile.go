@@ -0,0 +1,47 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+)
+
+// mainLoopLabel is the pprof label value of the loop goroutines which are not event-loops,
+// like the main reactor and the listener goroutines on Windows.
+const mainLoopLabel = "main"
+
+// doWithLabels runs f with the pprof labels of a loop attached to the current goroutine, so that CPU profiles
+// of a busy server attribute the time to the specific loop and make a skewed load obvious.
+func doWithLabels(loop string, lns []*listener, f func()) {
+	addrs := make([]string, len(lns))
+	for i, ln := range lns {
+		addrs[i] = ln.network + "://" + ln.addr
+	}
+	labels := pprof.Labels("gnet_loop", loop, "gnet_listener", strings.Join(addrs, ","))
+	pprof.Do(context.Background(), labels, func(context.Context) { f() })
+}
+
+func loopLabel(idx int) string {
+	return strconv.Itoa(idx)
+}
@@ -36,7 +36,9 @@ func (svr *server) activateMainReactor(lockOSThread bool) {
 
 	defer svr.signalShutdown()
 
-	err := svr.mainLoop.poller.Polling(func(fd int, filter int16) error { return svr.acceptNewConnection(fd) })
+	err := svr.mainLoop.run(func() error {
+		return svr.mainLoop.poller.Polling(func(fd int, filter int16) error { return svr.acceptNewConnection(fd) })
+	})
 	svr.logger.Infof("Main reactor is exiting due to error: %v", err)
 }
 
@@ -51,18 +53,20 @@ func (svr *server) activateSubReactor(el *eventloop, lockOSThread bool) {
 		svr.signalShutdown()
 	}()
 
-	err := el.poller.Polling(func(fd int, filter int16) (err error) {
-		if c, ack := el.connections[fd]; ack {
-			switch filter {
-			case netpoll.EVFilterSock:
-				err = el.loopCloseConn(c, nil)
-			case netpoll.EVFilterWrite:
-				err = el.loopWrite(c)
-			case netpoll.EVFilterRead:
-				err = el.loopRead(c)
+	err := el.run(func() error {
+		return el.poller.Polling(func(fd int, filter int16) (err error) {
+			if c := el.connections.get(fd); c != nil {
+				switch filter {
+				case netpoll.EVFilterSock:
+					err = el.loopEOF(c)
+				case netpoll.EVFilterWrite:
+					err = el.loopWrite(c)
+				case netpoll.EVFilterRead:
+					err = el.loopRead(c)
+				}
 			}
-		}
-		return
+			return
+		})
 	})
 	svr.logger.Infof("Event-loop(%d) is exiting normally on the signal error: %v", el.idx, err)
 }
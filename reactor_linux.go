@@ -34,7 +34,9 @@ func (svr *server) activateMainReactor(lockOSThread bool) {
 
 	defer svr.signalShutdown()
 
-	err := svr.mainLoop.poller.Polling(func(fd int, ev uint32) error { return svr.acceptNewConnection(fd) })
+	err := svr.mainLoop.run(func() error {
+		return svr.mainLoop.poller.Polling(func(fd int, ev uint32) error { return svr.acceptNewConnection(fd) })
+	})
 	svr.logger.Infof("Main reactor is exiting due to error: %v", err)
 }
 
@@ -49,36 +51,38 @@ func (svr *server) activateSubReactor(el *eventloop, lockOSThread bool) {
 		svr.signalShutdown()
 	}()
 
-	err := el.poller.Polling(func(fd int, ev uint32) error {
-		if c, ack := el.connections[fd]; ack {
-			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
+	err := el.run(func() error {
+		return el.poller.Polling(func(fd int, ev uint32) error {
+			if c := el.connections.get(fd); c != nil {
+				// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
+				// sure what you're doing!
+				// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
 
-			// We should always check for the EPOLLOUT event first, as we must try to send the leftover data back to
-			// client when any error occurs on a connection.
-			//
-			// Either an EPOLLOUT or EPOLLERR event may be fired when a connection is refused.
-			// In either case loopWrite() should take care of it properly:
-			// 1) writing data back,
-			// 2) closing the connection.
-			if ev&netpoll.OutEvents != 0 {
-				if err := el.loopWrite(c); err != nil {
-					return err
+				// We should always check for the EPOLLOUT event first, as we must try to send the leftover data back to
+				// client when any error occurs on a connection.
+				//
+				// Either an EPOLLOUT or EPOLLERR event may be fired when a connection is refused.
+				// In either case loopWrite() should take care of it properly:
+				// 1) writing data back,
+				// 2) closing the connection.
+				if ev&netpoll.OutEvents != 0 {
+					if err := el.loopWrite(c); err != nil {
+						return err
+					}
+				}
+				// If there is pending data in outbound buffer, then we should omit this readable event
+				// and prioritize the writable events to achieve a higher performance.
+				//
+				// Note that the client may send massive amounts of data to server by write() under blocking mode,
+				// resulting in that it won't receive any responses before the server reads all data from client,
+				// in which case if the server socket send buffer is full, we need to let it go and continue reading
+				// the data to prevent blocking forever.
+				if ev&netpoll.InEvents != 0 && (ev&netpoll.OutEvents == 0 || c.outboundBuffer.IsEmpty()) {
+					return el.loopRead(c)
 				}
 			}
-			// If there is pending data in outbound buffer, then we should omit this readable event
-			// and prioritize the writable events to achieve a higher performance.
-			//
-			// Note that the client may send massive amounts of data to server by write() under blocking mode,
-			// resulting in that it won't receive any responses before the server reads all data from client,
-			// in which case if the server socket send buffer is full, we need to let it go and continue reading
-			// the data to prevent blocking forever.
-			if ev&netpoll.InEvents != 0 && (ev&netpoll.OutEvents == 0 || c.outboundBuffer.IsEmpty()) {
-				return el.loopRead(c)
-			}
-		}
-		return nil
+			return nil
+		})
 	})
 	svr.logger.Infof("Event-loop(%d) is exiting normally on the signal error: %v", el.idx, err)
 }
@@ -39,6 +39,14 @@ type RingBuffer struct {
 	r       int // next position to read
 	w       int // next position to write
 	isEmpty bool
+	alloc   Allocator // allocator of the backing arrays, nil for the heap
+}
+
+// Allocator allocates the backing arrays of ring-buffers and takes back the ones replaced by ring-buffers
+// when they grow or shrink, the arrays it allocates don't have to be zeroed.
+type Allocator interface {
+	Alloc(size int) []byte
+	Free(buf []byte)
 }
 
 // EmptyRingBuffer can be used as a placeholder for those closed connections.
@@ -363,6 +371,26 @@ func (r *RingBuffer) IsFull() bool {
 	return r.r == r.w && !r.isEmpty
 }
 
+// SetAllocator makes the ring-buffer allocate its backing arrays from a from now on, nil for the heap.
+func (r *RingBuffer) SetAllocator(a Allocator) {
+	r.alloc = a
+}
+
+func (r *RingBuffer) makeBuf(size int) []byte {
+	if r.alloc != nil && size > 0 {
+		return r.alloc.Alloc(size)
+	}
+	return make([]byte, size)
+}
+
+// replaceBuf swaps in the new backing array and hands the old one back to the allocator.
+func (r *RingBuffer) replaceBuf(buf []byte) {
+	if r.alloc != nil && cap(r.buf) > 0 {
+		r.alloc.Free(r.buf)
+	}
+	r.buf = buf
+}
+
 // IsEmpty returns this ringbuffer is empty.
 func (r *RingBuffer) IsEmpty() bool {
 	return r.isEmpty
@@ -375,12 +403,37 @@ func (r *RingBuffer) Reset() {
 
 	// Shrink the internal buffer for saving memory.
 	newCap := r.size >> 1
-	newBuf := make([]byte, newCap)
-	r.buf = newBuf
+	r.replaceBuf(r.makeBuf(newCap))
 	r.size = newCap
 	r.mask = newCap - 1
 }
 
+// Resize reallocates the underlying buffer with the given size, which is rounded up to the least power of two,
+// the buffered data is kept, so the buffer never becomes smaller than it.
+func (r *RingBuffer) Resize(size int) {
+	oldLen := r.Length()
+	if size < oldLen {
+		size = oldLen
+	}
+	if size <= 0 {
+		r.replaceBuf(nil)
+		*r = RingBuffer{isEmpty: true, alloc: r.alloc}
+		return
+	}
+	size = internal.CeilToPowerOfTwo(size)
+	if size == r.size {
+		return
+	}
+	newBuf := r.makeBuf(size)
+	_, _ = r.Read(newBuf)
+	r.replaceBuf(newBuf)
+	r.r = 0
+	r.w = oldLen & (size - 1)
+	r.size = size
+	r.mask = size - 1
+	r.isEmpty = oldLen == 0
+}
+
 func (r *RingBuffer) malloc(cap int) {
 	var newCap int
 	if r.size == 0 && cap < initSize {
@@ -388,10 +441,10 @@ func (r *RingBuffer) malloc(cap int) {
 	} else {
 		newCap = internal.CeilToPowerOfTwo(r.size + cap)
 	}
-	newBuf := make([]byte, newCap)
+	newBuf := r.makeBuf(newCap)
 	oldLen := r.Length()
 	_, _ = r.Read(newBuf)
-	r.buf = newBuf
+	r.replaceBuf(newBuf)
 	r.r = 0
 	r.w = oldLen
 	r.size = newCap
@@ -23,6 +23,8 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/panjf2000/gnet/pool/slab"
 )
 
 func TestRingBuffer_Write(t *testing.T) {
@@ -495,3 +497,74 @@ func TestShrinkBuffer(t *testing.T) {
 		t.Fatalf("expect buffer capacity %d, but got %d", testCap/4, rb.Cap())
 	}
 }
+
+func TestResizeBuffer(t *testing.T) {
+	testStr := "Hello World!"
+
+	rb := New(16)
+	_, _ = rb.WriteString(testStr)
+	rb.Shift(6)
+	_, _ = rb.WriteString(testStr)
+	rb.Resize(1024)
+	if rb.Cap() != 1024 {
+		t.Fatalf("expect buffer capacity %d, but got %d", 1024, rb.Cap())
+	}
+	head, tail := rb.LazyReadAll()
+	if got := string(head) + string(tail); got != "World!"+testStr {
+		t.Fatalf("expect %q, but got %q", "World!"+testStr, got)
+	}
+
+	// The buffer can't be shrunk below the buffered data.
+	rb.Resize(1)
+	if rb.Cap() != 32 || rb.Length() != 18 {
+		t.Fatalf("expect buffer capacity 32 and length 18, but got %d and %d", rb.Cap(), rb.Length())
+	}
+	rb.Shift(18)
+	rb.Resize(0)
+	if rb.Cap() != 0 || !rb.IsEmpty() {
+		t.Fatalf("expect an empty buffer, but got capacity %d", rb.Cap())
+	}
+	_, _ = rb.WriteString(testStr)
+	if rb.Length() != len(testStr) {
+		t.Fatalf("expect buffer length %d, but got %d", len(testStr), rb.Length())
+	}
+}
+
+type countingAllocator struct {
+	*slab.Allocator
+	allocs, frees int
+}
+
+func (a *countingAllocator) Alloc(size int) []byte {
+	a.allocs++
+	return a.Allocator.Alloc(size)
+}
+
+func (a *countingAllocator) Free(buf []byte) {
+	a.frees++
+	a.Allocator.Free(buf)
+}
+
+func TestRingBufferAllocator(t *testing.T) {
+	alloc := &countingAllocator{Allocator: slab.New(slab.DefaultMaxFree)}
+	rb := New(0)
+	rb.SetAllocator(alloc)
+
+	data := bytes.Repeat([]byte("gnet"), 4096)
+	_, _ = rb.Write(data)
+	_, _ = rb.Write(data)
+	if rb.Length() != 2*len(data) {
+		t.Fatalf("expect buffer length %d, but got %d", 2*len(data), rb.Length())
+	}
+	head, tail := rb.LazyReadAll()
+	if got := string(head) + string(tail); got != string(data)+string(data) {
+		t.Fatal("the data is corrupted after growing the buffer")
+	}
+	rb.Shift(rb.Length())
+	rb.Resize(0)
+
+	// Every backing array but the ones of the zero size goes back to the allocator.
+	if alloc.allocs != alloc.frees {
+		t.Fatalf("expect %d arrays freed, but got %d", alloc.allocs, alloc.frees)
+	}
+}
@@ -35,24 +35,42 @@ import (
 )
 
 type server struct {
-	ln           *listener          // the listener for accepting new connections
-	lb           loadBalancer       // event-loops for handling events
-	wg           sync.WaitGroup     // event-loop close WaitGroup
-	opts         *Options           // options with server
-	once         sync.Once          // make sure only signalShutdown once
-	cond         *sync.Cond         // shutdown signaler
-	codec        ICodec             // codec for TCP stream
-	logger       logging.Logger     // customized logger for logging info
-	ticktock     chan time.Duration // ticker channel
-	mainLoop     *eventloop         // main event-loop for accepting connections
-	inShutdown   int32              // whether the server is in shutdown
-	eventHandler EventHandler       // user eventHandler
+	lns          []*listener          // the listeners for accepting new connections
+	lnsMu        sync.Mutex           // protects lns from the listeners being rebound
+	lb           loadBalancer         // event-loops for handling events
+	wg           sync.WaitGroup       // event-loop close WaitGroup
+	opts         *Options             // options with server
+	optsMu       sync.Mutex           // serializes the updates of options
+	liveOpts     atomic.Value         // *Options updated at runtime, see Server.UpdateOptions
+	limiter      acceptLimiter        // rate limiter of the accepted connections, see Options.AcceptRate
+	admin        *adminHandler        // handler of the admin listener, nil if there's none
+	once         sync.Once            // make sure only signalShutdown once
+	cond         *sync.Cond           // shutdown signaler
+	codec        ICodec               // codec for TCP stream
+	logger       logging.Logger       // customized logger for logging info
+	ticktock     chan time.Duration   // ticker channel
+	tickers      []chan time.Duration // channels of the named tickers
+	pcap         *pcapWriter          // capture of the connections selected by Options.PcapFilter
+	mainLoop     *eventloop           // main event-loop for accepting connections
+	started      int32                // whether the event-loops are running
+	inShutdown   int32                // whether the server is in shutdown
+	draining     int32                // whether the server is draining
+	paused       int32                // whether the server has paused accepting connections
+	spareMu      sync.Mutex           // protects spareFd from the event-loops accepting connections
+	spareFd      int                  // file-descriptor reserved for shedding connections, -1 if none
+	eventHandler EventHandler         // user eventHandler
 }
 
 func (svr *server) isInShutdown() bool {
 	return atomic.LoadInt32(&svr.inShutdown) == 1
 }
 
+// isStarted reports whether the event-loops are running, the APIs that reach for them must check it
+// since Server is handed over to OnInitComplete before the event-loops are started.
+func (svr *server) isStarted() bool {
+	return atomic.LoadInt32(&svr.started) == 1
+}
+
 // waitForShutdown waits for a signal to shutdown.
 func (svr *server) waitForShutdown() {
 	svr.cond.L.Lock()
@@ -69,11 +87,23 @@ func (svr *server) signalShutdown() {
 	})
 }
 
+// cpuSteering reports whether the listeners of each event-loop are steered by CPU.
+func (svr *server) cpuSteering() bool {
+	return svr.opts.ReusePort && svr.opts.ReusePortCPUSteering
+}
+
 func (svr *server) startEventLoops() {
 	svr.lb.iterate(func(i int, el *eventloop) bool {
 		svr.wg.Add(1)
 		go func() {
-			el.loopRun(svr.opts.LockOSThread)
+			if svr.cpuSteering() {
+				// The thread is never unlocked, it will be terminated along with the goroutine.
+				runtime.LockOSThread()
+				if err := pinToCPU(el.idx % runtime.NumCPU()); err != nil {
+					svr.logger.Warnf("Failed to pin event-loop(%d) to CPU: %v", el.idx, err)
+				}
+			}
+			doWithLabels(loopLabel(el.idx), svr.lns, func() { el.loopRun(svr.opts.LockOSThread) })
 			svr.wg.Done()
 		}()
 		return true
@@ -91,7 +121,7 @@ func (svr *server) startSubReactors() {
 	svr.lb.iterate(func(i int, el *eventloop) bool {
 		svr.wg.Add(1)
 		go func() {
-			svr.activateSubReactor(el, svr.opts.LockOSThread)
+			doWithLabels(loopLabel(el.idx), svr.lns, func() { svr.activateSubReactor(el, svr.opts.LockOSThread) })
 			svr.wg.Done()
 		}()
 		return true
@@ -101,34 +131,56 @@ func (svr *server) startSubReactors() {
 func (svr *server) activateEventLoops(numEventLoop int) (err error) {
 	// Create loops locally and bind the listeners.
 	for i := 0; i < numEventLoop; i++ {
-		l := svr.ln
-		if i > 0 && svr.opts.ReusePort {
-			if l, err = initListener(svr.ln.network, svr.ln.addr, svr.opts); err != nil {
-				return
+		listeners := make(map[int]*listener, len(svr.lns))
+		for _, ln := range svr.lns {
+			l := ln
+			// The listeners handed over by the user can't be bound again, so they are shared by all event-loops.
+			if i > 0 && svr.opts.ReusePort && !ln.inherited {
+				if l, err = ln.rebind(svr.opts); err != nil {
+					return
+				}
 			}
+			listeners[l.fd] = l
 		}
 
 		var p *netpoll.Poller
 		if p, err = netpoll.OpenPoller(); err == nil {
 			el := new(eventloop)
-			el.ln = l
+			el.listeners = listeners
 			el.svr = svr
 			el.poller = p
+			el.poller.SetBusyPoll(svr.opts.BusyPoll)
 			el.buffer = make([]byte, svr.opts.ReadBufferCap)
-			el.connections = make(map[int]*conn)
 			el.eventHandler = svr.eventHandler
-			_ = el.poller.AddRead(el.ln.fd)
+			el.metrics = newLoopMetrics(svr)
+			for fd := range el.listeners {
+				_ = el.poller.AddRead(fd)
+			}
 			svr.lb.register(el)
 
 			// Start the ticker.
 			if el.idx == 0 && svr.opts.Ticker {
 				go el.loopTicker()
 			}
+			if el.idx == 0 {
+				svr.startNamedTickers(el)
+			}
 		} else {
 			return
 		}
 	}
 
+	if svr.cpuSteering() {
+		for _, ln := range svr.lns {
+			if ln.network == "unix" || ln.inherited {
+				continue
+			}
+			if err = attachReusePortCPUSteering(ln.fd, numEventLoop); err != nil {
+				return
+			}
+		}
+	}
+
 	// Start event-loops in background.
 	svr.startEventLoops()
 
@@ -139,18 +191,21 @@ func (svr *server) activateReactors(numEventLoop int) error {
 	for i := 0; i < numEventLoop; i++ {
 		if p, err := netpoll.OpenPoller(); err == nil {
 			el := new(eventloop)
-			el.ln = svr.ln
 			el.svr = svr
 			el.poller = p
+			el.poller.SetBusyPoll(svr.opts.BusyPoll)
 			el.buffer = make([]byte, svr.opts.ReadBufferCap)
-			el.connections = make(map[int]*conn)
 			el.eventHandler = svr.eventHandler
+			el.metrics = newLoopMetrics(svr)
 			svr.lb.register(el)
 
 			// Start the ticker.
 			if el.idx == 0 && svr.opts.Ticker {
 				go el.loopTicker()
 			}
+			if el.idx == 0 {
+				svr.startNamedTickers(el)
+			}
 		} else {
 			return err
 		}
@@ -161,17 +216,20 @@ func (svr *server) activateReactors(numEventLoop int) error {
 
 	if p, err := netpoll.OpenPoller(); err == nil {
 		el := new(eventloop)
-		el.ln = svr.ln
+		el.listeners = make(map[int]*listener, len(svr.lns))
 		el.idx = -1
 		el.svr = svr
 		el.poller = p
-		_ = el.poller.AddRead(el.ln.fd)
+		for _, ln := range svr.lns {
+			el.listeners[ln.fd] = ln
+			_ = el.poller.AddRead(ln.fd)
+		}
 		svr.mainLoop = el
 
 		// Start main reactor in background.
 		svr.wg.Add(1)
 		go func() {
-			svr.activateMainReactor(svr.opts.LockOSThread)
+			doWithLabels(mainLoopLabel, svr.lns, func() { svr.activateMainReactor(svr.opts.LockOSThread) })
 			svr.wg.Done()
 		}()
 	} else {
@@ -182,13 +240,150 @@ func (svr *server) activateReactors(numEventLoop int) error {
 }
 
 func (svr *server) start(numEventLoop int) error {
-	if svr.opts.ReusePort || svr.ln.network == "udp" {
+	if svr.opts.ReusePort {
 		return svr.activateEventLoops(numEventLoop)
 	}
 
+	// UDP sockets are read by the event-loops directly, so the main reactor is not an option for them.
+	for _, ln := range svr.lns {
+		if ln.network == "udp" {
+			return svr.activateEventLoops(numEventLoop)
+		}
+	}
+
 	return svr.activateReactors(numEventLoop)
 }
 
+func (svr *server) isAcceptPaused() bool {
+	return atomic.LoadInt32(&svr.paused) == 1
+}
+
+// pauseAccept stops the event-loops from accepting on the stream listeners, which are removed from the pollers
+// asynchronously, the connections in the backlog are left pending until accepting is resumed. It doesn't wait for
+// the event-loops so that it can be called on them.
+func (svr *server) pauseAccept() error {
+	if svr.isInShutdown() {
+		return errors.ErrServerInShutdown
+	}
+	if !svr.isStarted() {
+		return errors.ErrServerNotStarted
+	}
+	if !atomic.CompareAndSwapInt32(&svr.paused, 0, 1) {
+		return nil
+	}
+	return svr.iterateListeners(func(el *eventloop, fd int) error {
+		return el.poller.ModNone(fd)
+	})
+}
+
+// resumeAccept puts the stream listeners removed by pauseAccept back to the pollers asynchronously.
+func (svr *server) resumeAccept() error {
+	if svr.isInShutdown() {
+		return errors.ErrServerInShutdown
+	}
+	if !svr.isStarted() {
+		return errors.ErrServerNotStarted
+	}
+	if !atomic.CompareAndSwapInt32(&svr.paused, 1, 0) {
+		return nil
+	}
+	return svr.iterateListeners(func(el *eventloop, fd int) error {
+		return el.watchListener(fd)
+	})
+}
+
+// iterateListeners runs f with every stream listener on the event-loop it is bound to without waiting for them,
+// as the caller may be one of the event-loops.
+func (svr *server) iterateListeners(f func(el *eventloop, fd int) error) error {
+	var loops []*eventloop
+	if svr.mainLoop != nil {
+		loops = append(loops, svr.mainLoop)
+	} else {
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			loops = append(loops, el)
+			return true
+		})
+	}
+
+	for _, el := range loops {
+		el := el
+		err := el.poller.UrgentTrigger(func() error {
+			for fd, ln := range el.listeners {
+				if ln.network == "udp" {
+					continue
+				}
+				if err := f(el, fd); err != nil {
+					el.svr.logger.Warnf("failed to update listener(fd=%d) on event-loop(%d): %v", fd, el.idx, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain stops accepting new connections and datagrams by removing the listeners from the event-loops,
+// then shuts the server down once all the connections are closed.
+func (svr *server) drain() error {
+	if !atomic.CompareAndSwapInt32(&svr.draining, 0, 1) {
+		return nil
+	}
+
+	var loops []*eventloop
+	if svr.mainLoop != nil {
+		loops = append(loops, svr.mainLoop)
+	} else {
+		svr.lb.iterate(func(i int, el *eventloop) bool {
+			loops = append(loops, el)
+			return true
+		})
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		lns []*listener
+	)
+	for _, el := range loops {
+		el := el
+		wg.Add(1)
+		err := el.poller.UrgentTrigger(func() error {
+			mu.Lock()
+			for fd, ln := range el.listeners {
+				_ = el.poller.Delete(fd)
+				delete(el.listeners, fd)
+				lns = append(lns, ln)
+			}
+			mu.Unlock()
+			wg.Done()
+			return nil
+		})
+		if err != nil {
+			wg.Done()
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		for _, ln := range lns {
+			ln.close()
+		}
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for range ticker.C {
+			if (Server{svr: svr}).CountConnections() == svr.admin.countConns() {
+				svr.signalShutdown()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
 func (svr *server) stop(s Server) {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
@@ -197,15 +392,19 @@ func (svr *server) stop(s Server) {
 
 	// Notify all loops to close by closing all listeners
 	svr.lb.iterate(func(i int, el *eventloop) bool {
-		sniffErrorAndLog(el.poller.Trigger(func() error {
+		sniffErrorAndLog(el.poller.UrgentTrigger(func() error {
 			return errors.ErrServerShutdown
 		}))
 		return true
 	})
 
 	if svr.mainLoop != nil {
-		svr.ln.close()
-		sniffErrorAndLog(svr.mainLoop.poller.Trigger(func() error {
+		svr.lnsMu.Lock()
+		for _, ln := range svr.lns {
+			ln.close()
+		}
+		svr.lnsMu.Unlock()
+		sniffErrorAndLog(svr.mainLoop.poller.UrgentTrigger(func() error {
 			return errors.ErrServerShutdown
 		}))
 	}
@@ -223,11 +422,15 @@ func (svr *server) stop(s Server) {
 	if svr.opts.Ticker {
 		close(svr.ticktock)
 	}
+	for _, ticktock := range svr.tickers {
+		close(ticktock)
+	}
+	svr.closePcap()
 
 	atomic.StoreInt32(&svr.inShutdown, 1)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string) error {
+func serve(eventHandler EventHandler, listeners []*listener, options *Options, protoAddrs []string) error {
 	// Figure out the proper number of event-loops/goroutines to run.
 	numEventLoop := 1
 	if options.Multicore {
@@ -239,8 +442,16 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 
 	svr := new(server)
 	svr.opts = options
+	svr.liveOpts.Store(options)
 	svr.eventHandler = eventHandler
-	svr.ln = listener
+	svr.lns = listeners
+	svr.spareFd = -1
+	if options.SpareFd {
+		if err := svr.reserveFd(); err != nil {
+			return err
+		}
+		defer svr.releaseFd()
+	}
 
 	switch options.LB {
 	case RoundRobin:
@@ -253,6 +464,10 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.ticktock = make(chan time.Duration, channelBuffer)
+	svr.tickers = make([]chan time.Duration, len(options.NamedTickers))
+	for i := range svr.tickers {
+		svr.tickers[i] = make(chan time.Duration, 1)
+	}
 	svr.logger = logging.DefaultLogger
 	svr.codec = func() ICodec {
 		if options.Codec == nil {
@@ -264,25 +479,50 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	server := Server{
 		svr:          svr,
 		Multicore:    options.Multicore,
-		Addr:         listener.lnaddr,
+		Addr:         listeners[0].lnaddr,
 		NumEventLoop: numEventLoop,
 		ReusePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
 	}
+	bindServer(server, listeners)
 	switch svr.eventHandler.OnInitComplete(server) {
 	case None:
 	case Shutdown:
 		return nil
 	}
 
+	if options.PcapFile != "" {
+		pcap, err := openPcap(options.PcapFile)
+		if err != nil {
+			svr.logger.Errorf("gnet server failed to open the pcap file: %v", err)
+			return err
+		}
+		svr.pcap = pcap
+	}
+
 	if err := svr.start(numEventLoop); err != nil {
+		svr.closePcap()
 		svr.closeEventLoops()
 		svr.logger.Errorf("gnet server is stopping with error: %v", err)
 		return err
 	}
+	atomic.StoreInt32(&svr.started, 1)
 	defer svr.stop(server)
 
-	allServers.Store(protoAddr, svr)
+	if options.WatchdogInterval > 0 {
+		go svr.watchdog(options.WatchdogInterval)
+	}
+	if options.MemoryLimit > 0 {
+		go svr.memoryGuard(uint64(options.MemoryLimit), options.MemoryPolicy)
+	}
+	if options.OverloadLatency > 0 {
+		go svr.overloadGuard(options.OverloadLatency)
+	}
+	go svr.idleReaper()
+
+	for _, protoAddr := range protoAddrs {
+		allServers.Store(protoAddr, svr)
+	}
 
 	return nil
 }
@@ -35,25 +35,38 @@ import (
 var errCloseAllConns = errors.New("close all connections in event-loop")
 
 type server struct {
-	ln           *listener          // the listeners for accepting new connections
-	lb           loadBalancer       // event-loops for handling events
-	cond         *sync.Cond         // shutdown signaler
-	opts         *Options           // options with server
-	serr         error              // signal error
-	once         sync.Once          // make sure only signalShutdown once
-	codec        ICodec             // codec for TCP stream
-	loopWG       sync.WaitGroup     // loop close WaitGroup
-	logger       logging.Logger     // customized logger for logging info
-	ticktock     chan time.Duration // ticker channel
-	listenerWG   sync.WaitGroup     // listener close WaitGroup
-	inShutdown   int32              // whether the server is in shutdown
-	eventHandler EventHandler       // user eventHandler
+	lns          []*listener          // the listeners for accepting new connections
+	lb           loadBalancer         // event-loops for handling events
+	cond         *sync.Cond           // shutdown signaler
+	opts         *Options             // options with server
+	optsMu       sync.Mutex           // serializes the updates of options
+	liveOpts     atomic.Value         // *Options updated at runtime, see Server.UpdateOptions
+	limiter      acceptLimiter        // rate limiter of the accepted connections, see Options.AcceptRate
+	admin        *adminHandler        // handler of the admin listener, nil if there's none
+	serr         error                // signal error
+	once         sync.Once            // make sure only signalShutdown once
+	codec        ICodec               // codec for TCP stream
+	loopWG       sync.WaitGroup       // loop close WaitGroup
+	logger       logging.Logger       // customized logger for logging info
+	ticktock     chan time.Duration   // ticker channel
+	tickers      []chan time.Duration // channels of the named tickers
+	pcap         *pcapWriter          // capture of the connections selected by Options.PcapFilter
+	listenerWG   sync.WaitGroup       // listener close WaitGroup
+	started      int32                // whether the event-loops are running
+	inShutdown   int32                // whether the server is in shutdown
+	eventHandler EventHandler         // user eventHandler
 }
 
 func (svr *server) isInShutdown() bool {
 	return atomic.LoadInt32(&svr.inShutdown) == 1
 }
 
+// isStarted reports whether the event-loops are running, the APIs that reach for them must check it
+// since Server is handed over to OnInitComplete before the event-loops are started.
+func (svr *server) isStarted() bool {
+	return atomic.LoadInt32(&svr.started) == 1
+}
+
 // waitForShutdown waits for a signal to shutdown.
 func (svr *server) waitForShutdown() error {
 	svr.cond.L.Lock()
@@ -78,12 +91,14 @@ func (svr *server) signalShutdownWithErr(err error) {
 	})
 }
 
-func (svr *server) startListener() {
-	svr.listenerWG.Add(1)
-	go func() {
-		svr.listenerRun(svr.opts.LockOSThread)
-		svr.listenerWG.Done()
-	}()
+func (svr *server) startListeners() {
+	for _, ln := range svr.lns {
+		svr.listenerWG.Add(1)
+		go func(ln *listener) {
+			doWithLabels(mainLoopLabel, []*listener{ln}, func() { svr.listenerRun(ln, svr.opts.LockOSThread) })
+			svr.listenerWG.Done()
+		}(ln)
+	}
 }
 
 func (svr *server) startEventLoops(numEventLoop int) {
@@ -93,29 +108,49 @@ func (svr *server) startEventLoops(numEventLoop int) {
 		el.svr = svr
 		el.connections = make(map[*stdConn]struct{})
 		el.eventHandler = svr.eventHandler
+		el.metrics = newLoopMetrics(svr)
 		svr.lb.register(el)
 
 		// Start the ticker.
 		if el.idx == 0 && svr.opts.Ticker {
 			go el.loopTicker()
 		}
+		if el.idx == 0 {
+			svr.startNamedTickers(el)
+		}
 	}
 
 	svr.loopWG.Add(svr.lb.len())
 	svr.lb.iterate(func(i int, el *eventloop) bool {
-		go el.loopRun(svr.opts.LockOSThread)
+		go doWithLabels(loopLabel(el.idx), svr.lns, func() { el.loopRun(svr.opts.LockOSThread) })
 		return true
 	})
 }
 
+// pauseAccept is not supported on Windows since the listeners are not driven by pollers.
+func (svr *server) pauseAccept() error {
+	return errors2.ErrUnsupportedOp
+}
+
+func (svr *server) resumeAccept() error {
+	return errors2.ErrUnsupportedOp
+}
+
+// drain is not supported on Windows since closing the listeners shuts the server down.
+func (svr *server) drain() error {
+	return errors2.ErrUnsupportedOp
+}
+
 func (svr *server) stop(s Server) {
 	// Wait on a signal for shutdown.
 	svr.logger.Infof("Server is being shutdown on the signal error: %v", svr.waitForShutdown())
 
 	svr.eventHandler.OnShutdown(s)
 
-	// Close listener.
-	svr.ln.close()
+	// Close listeners.
+	for _, ln := range svr.lns {
+		ln.close()
+	}
 	svr.listenerWG.Wait()
 
 	// Notify all loops to close.
@@ -139,11 +174,15 @@ func (svr *server) stop(s Server) {
 	if svr.opts.Ticker {
 		close(svr.ticktock)
 	}
+	for _, ticktock := range svr.tickers {
+		close(ticktock)
+	}
+	svr.closePcap()
 
 	atomic.StoreInt32(&svr.inShutdown, 1)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string) (err error) {
+func serve(eventHandler EventHandler, listeners []*listener, options *Options, protoAddrs []string) (err error) {
 	// Figure out the correct number of loops/goroutines to use.
 	numEventLoop := 1
 	if options.Multicore {
@@ -155,8 +194,9 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 
 	svr := new(server)
 	svr.opts = options
+	svr.liveOpts.Store(options)
 	svr.eventHandler = eventHandler
-	svr.ln = listener
+	svr.lns = listeners
 
 	switch options.LB {
 	case RoundRobin:
@@ -168,6 +208,10 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	}
 
 	svr.ticktock = make(chan time.Duration, 1)
+	svr.tickers = make([]chan time.Duration, len(options.NamedTickers))
+	for i := range svr.tickers {
+		svr.tickers[i] = make(chan time.Duration, 1)
+	}
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.logger = logging.DefaultLogger
 	svr.codec = func() ICodec {
@@ -180,26 +224,37 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	server := Server{
 		svr:          svr,
 		Multicore:    options.Multicore,
-		Addr:         listener.lnaddr,
+		Addr:         listeners[0].lnaddr,
 		NumEventLoop: numEventLoop,
 		ReusePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
 	}
+	bindServer(server, listeners)
 	switch svr.eventHandler.OnInitComplete(server) {
 	case None:
 	case Shutdown:
 		return
 	}
 
+	if options.PcapFile != "" {
+		if svr.pcap, err = openPcap(options.PcapFile); err != nil {
+			svr.logger.Errorf("gnet server failed to open the pcap file: %v", err)
+			return
+		}
+	}
+
 	// Start all event-loops in background.
 	svr.startEventLoops(numEventLoop)
 
-	// Start listener in background.
-	svr.startListener()
+	// Start listeners in background.
+	svr.startListeners()
 
+	atomic.StoreInt32(&svr.started, 1)
 	defer svr.stop(server)
 
-	allServers.Store(protoAddr, svr)
+	for _, protoAddr := range protoAddrs {
+		allServers.Store(protoAddr, svr)
+	}
 
 	return
 }
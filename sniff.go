@@ -0,0 +1,112 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import "bytes"
+
+// sniffLimit is the maximum number of bytes peeked to tell the protocol of a connection,
+// the matchers asking for more bytes beyond that are considered as not matched.
+const sniffLimit = 4096
+
+// MatchResult is the verdict of a Matcher on the first bytes of a connection.
+type MatchResult int
+
+const (
+	// NoMatch indicates that the connection doesn't speak the protocol.
+	NoMatch MatchResult = iota
+
+	// Match indicates that the connection speaks the protocol.
+	Match
+
+	// NeedMore indicates that more bytes are needed to tell.
+	NeedMore
+)
+
+// Matcher tells whether a connection speaks a protocol by the first bytes it sent.
+type Matcher func(head []byte) MatchResult
+
+// Protocol is one of the protocols served on a shared port, see Route.Protocols.
+type Protocol struct {
+	// Match recognizes the connections speaking this protocol.
+	Match Matcher
+
+	// EventHandler handles the connections speaking this protocol, the one of the route is used when it's nil.
+	EventHandler EventHandler
+
+	// Codec encodes and decodes the frames of this protocol, the one of the route is used when it's nil.
+	Codec ICodec
+}
+
+// MatchPrefix returns a Matcher that matches the connections starting with any of the prefixes.
+func MatchPrefix(prefixes ...string) Matcher {
+	return func(head []byte) MatchResult {
+		result := NoMatch
+		for _, prefix := range prefixes {
+			if len(head) >= len(prefix) {
+				if string(head[:len(prefix)]) == prefix {
+					return Match
+				}
+			} else if bytes.HasPrefix([]byte(prefix), head) {
+				result = NeedMore
+			}
+		}
+		return result
+	}
+}
+
+// MatchTLS returns a Matcher that matches the connections starting with a TLS handshake record.
+func MatchTLS() Matcher {
+	return func(head []byte) MatchResult {
+		switch {
+		case len(head) > 0 && head[0] != 0x16:
+			return NoMatch
+		case len(head) < 2:
+			return NeedMore
+		case head[1] == 0x03:
+			return Match
+		default:
+			return NoMatch
+		}
+	}
+}
+
+// MatchHTTP returns a Matcher that matches the connections starting with an HTTP/1.x request line or
+// the HTTP/2 connection preface.
+func MatchHTTP() Matcher {
+	return MatchPrefix("GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH ",
+		"PRI * HTTP/2.0")
+}
+
+// sniff returns the first protocol in order that matches head, done is false if more bytes are needed to tell,
+// p is nil when none of the protocols matches.
+func sniff(protocols []Protocol, head []byte) (p *Protocol, done bool) {
+	for i := range protocols {
+		switch protocols[i].Match(head) {
+		case Match:
+			return &protocols[i], true
+		case NeedMore:
+			if len(head) < sniffLimit {
+				return nil, false
+			}
+		}
+	}
+	return nil, true
+}
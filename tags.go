@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+// TagStats is the traffic of the connections tagged with the same value of a key.
+type TagStats struct {
+	// Connections is the number of open connections with the tag.
+	Connections int `json:"connections"`
+
+	// BytesIn is the number of bytes received on the connections while they had the tag.
+	BytesIn uint64 `json:"bytes_in"`
+
+	// BytesOut is the number of bytes sent on the connections while they had the tag.
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+type tagKey struct {
+	key, value string
+}
+
+// connTag is a tag of a connection along with the statistics of the event-loop it counts into.
+type connTag struct {
+	tagKey
+	stats *TagStats
+}
+
+// tagTable holds the statistics of the tags on an event-loop, which are kept after the connections
+// are closed, it must only be accessed on the event-loop.
+type tagTable map[tagKey]*TagStats
+
+// setTag tags the connection with tags by key and value, replacing the tag of the same key, an empty value
+// removes the tag. It returns the updated tags of the connection.
+func (t *tagTable) setTag(tags []connTag, key, value string) []connTag {
+	for i := range tags {
+		if tags[i].key == key {
+			tags[i].stats.Connections--
+			tags = append(tags[:i], tags[i+1:]...)
+			break
+		}
+	}
+	if value == "" {
+		return tags
+	}
+	if *t == nil {
+		*t = make(tagTable)
+	}
+	k := tagKey{key, value}
+	st := (*t)[k]
+	if st == nil {
+		st = new(TagStats)
+		(*t)[k] = st
+	}
+	st.Connections++
+	return append(tags, connTag{k, st})
+}
+
+// stats returns a copy of the statistics of key, which are indexed by the tag values.
+func (t tagTable) stats(key string) map[string]TagStats {
+	stats := make(map[string]TagStats)
+	for k, st := range t {
+		if k.key == key {
+			stats[k.value] = *st
+		}
+	}
+	return stats
+}
+
+func tagValue(tags []connTag, key string) string {
+	for _, tag := range tags {
+		if tag.key == key {
+			return tag.value
+		}
+	}
+	return ""
+}
+
+// tagMap returns the tags as a map, nil if there are none.
+func tagMap(tags []connTag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[tag.key] = tag.value
+	}
+	return m
+}
+
+func untag(tags []connTag) {
+	for _, tag := range tags {
+		tag.stats.Connections--
+	}
+}
+
+func countTagged(tags []connTag, in, out int) {
+	for _, tag := range tags {
+		tag.stats.BytesIn += uint64(in)
+		tag.stats.BytesOut += uint64(out)
+	}
+}
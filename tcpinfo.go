@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import "time"
+
+// TCPInfo is the statistics of a TCP connection retrieved from the TCP_INFO socket option,
+// the fields that the kernel doesn't support are left zero.
+type TCPInfo struct {
+	// State is the state of the connection as defined by the kernel, e.g. 1 is ESTABLISHED.
+	State uint8
+
+	// RTT is the smoothed round trip time and RTTVar is its mean deviation.
+	RTT, RTTVar time.Duration
+
+	// MinRTT is the minimum round trip time observed.
+	MinRTT time.Duration
+
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+
+	// SndMSS and RcvMSS are the maximum segment sizes for sending and receiving.
+	SndMSS, RcvMSS uint32
+
+	// SndCwnd is the congestion window and SndSsthresh is the slow start threshold, both in segments.
+	SndCwnd, SndSsthresh uint32
+
+	// Unacked, Lost and Retrans are the numbers of segments that are unacknowledged, lost and being retransmitted.
+	Unacked, Lost, Retrans uint32
+
+	// TotalRetrans is the total number of retransmissions over the lifetime of the connection.
+	TotalRetrans uint32
+
+	// PacingRate and DeliveryRate are the current pacing rate and the most recent delivery rate in bytes per second.
+	PacingRate, DeliveryRate uint64
+
+	// BytesAcked is the number of bytes acknowledged by the peer and BytesReceived is the number of bytes received.
+	BytesAcked, BytesReceived uint64
+
+	// NotsentBytes is the number of bytes in the send queue that haven't been sent yet.
+	NotsentBytes uint32
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Andy Pan
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/internal/socket"
+)
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	raw, err := socket.GetTCPInfo(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPInfo{
+		State:         raw.State,
+		RTT:           time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:        time.Duration(raw.Rttvar) * time.Microsecond,
+		MinRTT:        time.Duration(raw.MinRTT) * time.Microsecond,
+		RTO:           time.Duration(raw.Rto) * time.Microsecond,
+		SndMSS:        raw.Snd_mss,
+		RcvMSS:        raw.Rcv_mss,
+		SndCwnd:       raw.Snd_cwnd,
+		SndSsthresh:   raw.Snd_ssthresh,
+		Unacked:       raw.Unacked,
+		Lost:          raw.Lost,
+		Retrans:       raw.Retrans,
+		TotalRetrans:  raw.Total_retrans,
+		PacingRate:    raw.PacingRate,
+		DeliveryRate:  raw.DeliveryRate,
+		BytesAcked:    raw.BytesAcked,
+		BytesReceived: raw.BytesReceived,
+		NotsentBytes:  raw.NotsentBytes,
+	}, nil
+}
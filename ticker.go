@@ -0,0 +1,76 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gnet
+
+import "time"
+
+// tickSchedule turns the delays returned by EventHandler.Tick into the time to wait for the next tick.
+type tickSchedule struct {
+	fixedRate  bool
+	resolution time.Duration
+	target     time.Time // when the last tick was due in fixed-rate mode
+}
+
+func newTickSchedule(opts *Options) *tickSchedule {
+	return &tickSchedule{fixedRate: opts.TickerFixedRate, resolution: opts.TickerResolution}
+}
+
+// next returns how long to wait after Tick returned delay. In fixed-rate mode the next tick is due delay after
+// the last one was due rather than after Tick returned, so that the time spent on ticking doesn't accumulate,
+// the ticks missed by a slow Tick are skipped instead of fired in a burst.
+func (s *tickSchedule) next(delay time.Duration) time.Duration {
+	if delay < s.resolution {
+		delay = s.resolution
+	}
+	if !s.fixedRate {
+		return delay
+	}
+	now := time.Now()
+	if s.target.IsZero() {
+		s.target = now
+	}
+	s.target = s.target.Add(delay)
+	if wait := s.target.Sub(now); wait > 0 {
+		return wait
+	}
+	s.target = now
+	return 0
+}
+
+// startNamedTickers starts the tickers registered by WithNamedTicker on el if the EventHandler can receive them.
+func (svr *server) startNamedTickers(el *eventloop) {
+	h, ok := svr.eventHandler.(NamedTickHandler)
+	if !ok {
+		return
+	}
+	for i, t := range svr.opts.NamedTickers {
+		go el.loopNamedTicker(i, t, h)
+	}
+}
+
+// intervalOf returns the interval of ticker t until the next tick, given the delay returned by NamedTick.
+func intervalOf(t NamedTicker, delay time.Duration) time.Duration {
+	if delay > 0 {
+		return delay
+	}
+	return t.Interval
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2018 Joshua J Baker
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"runtime"
+	"time"
+)
+
+// watchdog checks the event-loops at every interval until the server is shut down, an event-loop whose poller
+// is busy in the same iteration as the previous check is reported once per stall.
+func (svr *server) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	type state struct{ rounds, reported uint64 }
+	states := make(map[*eventloop]*state)
+	check := func(el *eventloop) {
+		st := states[el]
+		if st == nil {
+			st = new(state)
+			states[el] = st
+		}
+		rounds := el.poller.Rounds()
+		if rounds != 0 && rounds%2 == 0 && rounds == st.rounds && rounds != st.reported {
+			st.reported = rounds
+			svr.reportStall(el)
+		}
+		st.rounds = rounds
+	}
+	for range ticker.C {
+		if svr.isInShutdown() {
+			return
+		}
+		if svr.mainLoop != nil {
+			check(svr.mainLoop)
+		}
+		svr.lb.iterate(func(_ int, el *eventloop) bool {
+			check(el)
+			return true
+		})
+	}
+}
+
+func (svr *server) reportStall(el *eventloop) {
+	stacks := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(stacks, true)
+		if n < len(stacks) {
+			stacks = stacks[:n]
+			break
+		}
+		stacks = make([]byte, len(stacks)<<1)
+	}
+	if handler := svr.opts.StallHandler; handler != nil {
+		handler(el.idx, stacks)
+		return
+	}
+	svr.logger.Errorf("Event-loop(%d) has been stuck in one iteration for more than %v, goroutines:\n%s",
+		el.idx, svr.opts.WatchdogInterval, stacks)
+}